@@ -0,0 +1,197 @@
+// Copyright 2012 Lightpoke. All rights reserved.
+// This source code is subject to the terms and
+// conditions defined in the "License.txt" file.
+
+package organics
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+// Errors which an Authenticator returns to reject an connection, they decide
+// the status code which the request is rejected with.
+var (
+	// The request carries no (valid) credentials: 401 Unauthorized.
+	ErrUnauthorized = errors.New("organics: unauthorized")
+
+	// The request carries valid credentials, which are not allowed to
+	// connect: 403 Forbidden.
+	ErrForbidden = errors.New("organics: forbidden")
+)
+
+// Identity is whatever an Authenticator tells an authenticated connection
+// apart by, e.g. an user id, or an user struct.
+type Identity interface{}
+
+// Authenticator authenticates the request which establishes an connection
+// (the long-polling establish request, the WebSocket handshake, or the event
+// stream request), see Server.SetAuthenticator().
+//
+// It returns the identity of the client, or an error to reject the connection.
+// ErrForbidden rejects it with an 403 Forbidden status, any other error with
+// an 401 Unauthorized status.
+type Authenticator func(req *http.Request) (Identity, error)
+
+// identityKey is the request context key which the identity of an
+// authenticated request is stored under.
+type identityKey struct{}
+
+// authenticated is the identity of an authenticated request (which may be nil).
+type authenticated struct {
+	identity Identity
+}
+
+// authenticate invokes the server's authenticator, if any, for the request
+// which establishes an connection. It returns the request, carrying the
+// identity, or writes the status it is rejected with and returns false.
+func (s *Server) authenticate(w http.ResponseWriter, req *http.Request) (*http.Request, bool) {
+	authenticator := s.Authenticator()
+	if authenticator == nil {
+		return req, true
+	}
+
+	identity, err := authenticator(req)
+	if err != nil {
+		logger().Println("Authentication failed:", err)
+		status := http.StatusUnauthorized
+		if errors.Is(err, ErrForbidden) {
+			status = http.StatusForbidden
+		}
+		w.WriteHeader(status)
+		req.Close = true
+		return req, false
+	}
+	return req.WithContext(context.WithValue(req.Context(), identityKey{}, &authenticated{identity})), true
+}
+
+// requestIdentity returns the identity of the authenticated request, or false
+// if the request was not authenticated (as the server has no authenticator).
+func requestIdentity(req *http.Request) (Identity, bool) {
+	a, ok := req.Context().Value(identityKey{}).(*authenticated)
+	if !ok {
+		return nil, false
+	}
+	return a.identity, true
+}
+
+// sameIdentity tells weather two identities are the same.
+func sameIdentity(a, b Identity) bool {
+	return reflect.DeepEqual(a, b)
+}
+
+// BearerToken returns an Authenticator which authenticates requests by an
+// bearer token, sent in the Authorization header:
+//
+//  Authorization: Bearer <token>
+//
+// Browsers cannot send headers with WebSocket handshakes, so the token may also
+// be offered as an "organics-bearer.<hex token>" subprotocol (which the
+// JavaScript client does, see Connection.SetBearerToken).
+//
+// The lookup function is given the token, and returns the identity it belongs
+// to, or an error (e.g. ErrUnauthorized) if there is none.
+func BearerToken(lookup func(token string) (Identity, error)) Authenticator {
+	return func(req *http.Request) (Identity, error) {
+		token, ok := bearerToken(req)
+		if !ok {
+			return nil, ErrUnauthorized
+		}
+		return lookup(token)
+	}
+}
+
+// spBearer is the WebSocket subprotocol which carries the bearer token, see
+// BearerToken.
+const spBearer = "organics-bearer"
+
+// bearerToken returns the bearer token which the request carries, or false if
+// it carries none.
+func bearerToken(req *http.Request) (string, bool) {
+	auth := req.Header.Get("Authorization")
+	if len(auth) > 7 && strings.EqualFold(auth[:7], "Bearer ") {
+		return strings.TrimSpace(auth[7:]), true
+	}
+
+	for _, value := range req.Header["Sec-Websocket-Protocol"] {
+		for _, protocol := range strings.Split(value, ",") {
+			protocol = strings.TrimSpace(protocol)
+			if strings.HasPrefix(protocol, spBearer+".") {
+				token, err := hex.DecodeString(strings.TrimPrefix(protocol, spBearer+"."))
+				if err != nil || len(token) == 0 {
+					return "", false
+				}
+				return string(token), true
+			}
+		}
+	}
+	return "", false
+}
+
+// SignedCookie returns an Authenticator which authenticates requests by an
+// cookie with the specified name, whose value was signed using SignValue with
+// the same key. The identity is the (string) value which was signed.
+//
+// Your login page (or handler) sets the cookie, for example:
+//
+//  http.SetCookie(w, &http.Cookie{
+//      Name:     "user",
+//      Value:    organics.SignValue(key, userId),
+//      HttpOnly: true,
+//  })
+//
+// The key should be at least 32 cryptographically random bytes, and kept
+// secret, anyone who has it can sign in as anyone.
+func SignedCookie(name string, key []byte) Authenticator {
+	return func(req *http.Request) (Identity, error) {
+		cookie, err := req.Cookie(name)
+		if err != nil {
+			return nil, ErrUnauthorized
+		}
+		value, ok := verifyValue(key, cookie.Value)
+		if !ok {
+			return nil, ErrUnauthorized
+		}
+		return value, nil
+	}
+}
+
+// SignValue returns the value signed using HMAC-SHA256 with the key, in an form
+// suitable for cookie values, see SignedCookie.
+func SignValue(key []byte, value string) string {
+	payload := base64.RawURLEncoding.EncodeToString([]byte(value))
+	return payload + "." + base64.RawURLEncoding.EncodeToString(signature(key, payload))
+}
+
+// verifyValue returns the value which was signed using SignValue with the key,
+// or false if the signature is not valid.
+func verifyValue(key []byte, signed string) (string, bool) {
+	i := strings.LastIndex(signed, ".")
+	if i < 0 {
+		return "", false
+	}
+	payload := signed[:i]
+	sig, err := base64.RawURLEncoding.DecodeString(signed[i+1:])
+	if err != nil || !hmac.Equal(sig, signature(key, payload)) {
+		return "", false
+	}
+	value, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return "", false
+	}
+	return string(value), true
+}
+
+// signature returns the HMAC-SHA256 of the payload with the key.
+func signature(key []byte, payload string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
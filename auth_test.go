@@ -0,0 +1,122 @@
+// Copyright 2012 Lightpoke. All rights reserved.
+// This source code is subject to the terms and
+// conditions defined in the "License.txt" file.
+
+package organics_test
+
+import (
+	"encoding/hex"
+	"net/http"
+	"testing"
+
+	"golang.org/x/net/websocket"
+
+	"github.com/sinni800/organics"
+	"github.com/sinni800/organics/client"
+)
+
+func TestBearerToken(t *testing.T) {
+	s := newServer(t)
+	s.SetAuthenticator(organics.BearerToken(func(token string) (organics.Identity, error) {
+		switch token {
+		case "alice", "bob":
+			return token, nil
+		case "banned":
+			return nil, organics.ErrForbidden
+		}
+		return nil, organics.ErrUnauthorized
+	}))
+	expectStatus := func(header http.Header, want int) *http.Response {
+		t.Helper()
+		resp := s.establishWith(t, header)
+		if resp.StatusCode != want {
+			t.Fatalf("got status %v with headers %v, want %d", resp.Status, header, want)
+		}
+		return resp
+	}
+	expectIdentity := func(want organics.Identity) *organics.Connection {
+		t.Helper()
+		c := receive(t, s.connected)
+		if c.Identity() != want || c.Session().Identity() != want {
+			t.Fatalf("connected as %v (session %v), want %v", c.Identity(), c.Session().Identity(), want)
+		}
+		return c
+	}
+
+	expectStatus(nil, http.StatusUnauthorized)
+	expectStatus(http.Header{"Authorization": {"Bearer unknown"}}, http.StatusUnauthorized)
+	expectStatus(http.Header{"Authorization": {"Bearer banned"}}, http.StatusForbidden)
+	resp := expectStatus(http.Header{"Authorization": {"Bearer alice"}}, http.StatusOK)
+	session := expectIdentity("alice").Session()
+
+	// An session is only ever joined by the identity which created it.
+	cookie := resp.Cookies()[0].Name + "=" + resp.Cookies()[0].Value
+	expectStatus(http.Header{"Authorization": {"Bearer bob"}, "Cookie": {cookie}}, http.StatusOK)
+	if expectIdentity("bob").Session() == session {
+		t.Fatal("bob joined alice's session")
+	}
+	expectStatus(http.Header{"Authorization": {"Bearer alice"}, "Cookie": {cookie}}, http.StatusOK)
+	if expectIdentity("alice").Session() != session {
+		t.Fatal("alice did not rejoin the session")
+	}
+
+	// Browsers cannot set headers on an WebSocket, so the token may be sent as
+	// an subprotocol instead.
+	if _, err := websocket.Dial(s.wsURL(), "", s.http.URL); err == nil {
+		t.Fatal("WebSocket connected without an token")
+	}
+	config, err := websocket.NewConfig(s.wsURL(), s.http.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Protocol = []string{"organics.json", "organics-bearer." + hex.EncodeToString([]byte("bob"))}
+	ws, err := websocket.DialConfig(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	expectIdentity("bob")
+
+	c, err := client.DialHeader(s.wsURL(), http.Header{"Authorization": {"Bearer alice"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Kill()
+	expectIdentity("alice")
+	if _, err := client.Dial(s.http.URL); err == nil {
+		t.Fatal("client connected without an token")
+	}
+}
+
+func TestSignedCookie(t *testing.T) {
+	s := newServer(t)
+	key := []byte("0123456789abcdef0123456789abcdef")
+	s.SetAuthenticator(organics.SignedCookie("user", key))
+
+	resp := s.establishWith(t, http.Header{"Cookie": {"user=" + organics.SignValue([]byte("wrong key"), "42")}})
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("got status %v for an forged cookie, want 401", resp.Status)
+	}
+	resp = s.establishWith(t, http.Header{"Cookie": {"user=" + organics.SignValue(key, "42")}})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("got status %v for an signed cookie, want 200", resp.Status)
+	}
+	if id := receive(t, s.connected).Identity(); id != "42" {
+		t.Fatalf("connected as %v, want 42", id)
+	}
+
+	// Event streams are authenticated just the same.
+	req, err := http.NewRequest("GET", s.http.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("got status %v for an event stream without cookie, want 401", resp.Status)
+	}
+}
@@ -0,0 +1,97 @@
+// Copyright 2012 Lightpoke. All rights reserved.
+// This source code is subject to the terms and
+// conditions defined in the "License.txt" file.
+
+package organics_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"golang.org/x/net/websocket"
+
+	"github.com/sinni800/organics"
+)
+
+// readFrame reads the next JSON frame from the WebSocket.
+func readFrame(t *testing.T, ws *websocket.Conn) []interface{} {
+	t.Helper()
+	ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var data string
+	if err := websocket.Message.Receive(ws, &data); err != nil {
+		t.Fatal(err)
+	}
+	var frame []interface{}
+	if err := json.Unmarshal([]byte(data), &frame); err != nil {
+		t.Fatalf("frame %s: %v", data, err)
+	}
+	return frame
+}
+
+func TestCancel(t *testing.T) {
+	s := newServer(t)
+	called := make(chan string, 4)
+	cancelled := make(chan string, 4)
+	s.Handle("Wait", func(ctx context.Context, name string, c *organics.Connection) string {
+		called <- name
+		select {
+		case <-ctx.Done():
+			cancelled <- name
+		case <-time.After(200 * time.Millisecond):
+		}
+		return name
+	})
+	ws, sc := s.rawWebSocket(t)
+
+	// Cancelled while it is handled, the handler's context is cancelled.
+	websocket.Message.Send(ws, `[1,"Wait",["first"]]`)
+	receive(t, called)
+	websocket.Message.Send(ws, `["c",1]`)
+	if name := receive(t, cancelled); name != "first" {
+		t.Fatalf("cancelled %q, want first", name)
+	}
+
+	// Cancelled before it is handled, it never is.
+	websocket.Message.Send(ws, `[2,"Wait",["second"]]`)
+	websocket.Message.Send(ws, `[3,"Wait",["third"]]`)
+	websocket.Message.Send(ws, `["c",3]`)
+	if f := readFrame(t, ws); f[0] != 2.0 {
+		t.Fatalf("got frame %v, want the response to the second request", f)
+	}
+	if name := receive(t, called); name != "second" {
+		t.Fatalf("called %q, want second", name)
+	}
+	select {
+	case name := <-called:
+		t.Fatalf("cancelled request %q was handled", name)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// Unknown ids are ignored.
+	websocket.Message.Send(ws, `["c",99]`)
+	websocket.Message.Send(ws, `[4,"Wait",["fourth"]]`)
+	if f := readFrame(t, ws); f[0] != 4.0 {
+		t.Fatalf("got frame %v, want the response to the fourth request", f)
+	}
+
+	// Giving up on an request tells the other end.
+	errs := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		_, err := sc.RequestContext(ctx, "Never")
+		errs <- err
+	}()
+	request := readFrame(t, ws)
+	if request[1] != "Never" {
+		t.Fatalf("got frame %v, want the Never request", request)
+	}
+	if f := readFrame(t, ws); f[0] != "c" || f[1] != request[0] {
+		t.Fatalf("got frame %v, want it's cancel frame", f)
+	}
+	if err := receive(t, errs); err != context.DeadlineExceeded {
+		t.Fatalf("RequestContext() = %v, want context.DeadlineExceeded", err)
+	}
+}
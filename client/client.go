@@ -0,0 +1,466 @@
+// Copyright 2012 Lightpoke. All rights reserved.
+// This source code is subject to the terms and
+// conditions defined in the "License.txt" file.
+
+// Package client implements an Organics client in Go.
+//
+// It speaks the same protocol as the JavaScript client (see
+// javascript/organics.js), and as such can be used to talk to an
+// organics.Server from Go services, command line tools, or tests, the same way
+// that an web browser would.
+package client
+
+import (
+	"errors"
+	"fmt"
+	"github.com/sinni800/organics"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"reflect"
+	"sync"
+)
+
+var (
+	loggerAccess sync.RWMutex
+	theLogger    = log.New(ioutil.Discard, "organics/client ", 0)
+)
+
+// SetDebugOutput specifies an io.Writer which the client will write debug
+// information to.
+func SetDebugOutput(w io.Writer) {
+	loggerAccess.Lock()
+	defer loggerAccess.Unlock()
+
+	theLogger = log.New(w, "organics/client ", log.Ltime)
+}
+
+func logger() *log.Logger {
+	loggerAccess.RLock()
+	defer loggerAccess.RUnlock()
+
+	return theLogger
+}
+
+var (
+	errorType          = reflect.TypeOf((*error)(nil)).Elem()
+	emptyInterfaceType = reflect.TypeOf((*interface{})(nil)).Elem()
+)
+
+// ErrBadScheme is returned by Dial when the URL scheme is not one of ws, wss,
+// http, or https.
+var ErrBadScheme = errors.New("client: URL scheme must be ws, wss, http, or https")
+
+// Connection represents an single connection to an Organics server.
+type Connection struct {
+	access sync.RWMutex
+
+	url                *url.URL
+	header             http.Header
+	method             organics.Method
+	dead, goingAway    bool
+	deathNotifications []chan bool
+
+	handlers          map[interface{}]interface{}
+	requestCurrentId  float64
+	requestCompleters map[float64]interface{}
+	requestProgress   map[float64]interface{}
+
+	// Used by the WebSocket method, see websocket.go
+	ws          *wsConn
+	writeAccess sync.Mutex
+
+	// Used by the long-polling method, see longpoll.go
+	lp *lpConn
+}
+
+// Dial connects to the Organics server at the specified URL, and returns the
+// new Connection.
+//
+// The URL scheme determines the connection method in use; ws:// and wss://
+// URLs connect using WebSocket, while http:// and https:// URLs connect using
+// long-polling.
+func Dial(rawurl string) (*Connection, error) {
+	return DialHeader(rawurl, nil)
+}
+
+// DialHeader is just like Dial, except the header is sent along with the
+// request which establishes the connection, for example to authenticate with
+// the server (see organics.BearerToken):
+//
+//  c, err := client.DialHeader("wss://example.com/app", http.Header{
+//      "Authorization": {"Bearer " + token},
+//  })
+//
+func DialHeader(rawurl string, header http.Header) (*Connection, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+
+	c := new(Connection)
+	c.url = u
+	c.header = header
+	c.handlers = make(map[interface{}]interface{})
+	c.requestCompleters = make(map[float64]interface{})
+	c.requestProgress = make(map[float64]interface{})
+
+	switch u.Scheme {
+	case "ws", "wss":
+		c.method = organics.WebSocket
+		err = c.wsDial()
+
+	case "http", "https":
+		c.method = organics.LongPolling
+		err = c.lpDial()
+
+	default:
+		return nil, ErrBadScheme
+	}
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// String returns an string representation of this Connection.
+func (c *Connection) String() string {
+	return fmt.Sprintf("Connection(%s, Dead=%t, Method=%s)", c.url, c.Dead(), c.Method().String())
+}
+
+// Method returns one of the predefined constant methods which represents the
+// method in use by this connection.
+func (c *Connection) Method() organics.Method {
+	// Note: no locking needed, never written to past creation time.
+	return c.method
+}
+
+// Dead tells weather this Connection is dead or not.
+func (c *Connection) Dead() bool {
+	c.access.RLock()
+	defer c.access.RUnlock()
+
+	return c.dead
+}
+
+// GoingAway tells weather the server has told us that it is shutting down, in
+// which case it will close the connection shortly, and it should be dialed
+// again later on.
+func (c *Connection) GoingAway() bool {
+	c.access.RLock()
+	defer c.access.RUnlock()
+
+	return c.goingAway
+}
+
+// Kill closes this connection to the server.
+//
+// If this connection is already dead, this function is no-op.
+func (c *Connection) Kill() {
+	c.access.Lock()
+	if c.dead {
+		c.access.Unlock()
+		return
+	}
+	c.dead = true
+
+	deathNotifications := c.deathNotifications
+	c.deathNotifications = nil
+	c.access.Unlock()
+
+	switch c.method {
+	case organics.WebSocket:
+		c.wsClose()
+	case organics.LongPolling:
+		c.lpClose()
+	}
+
+	for _, ch := range deathNotifications {
+		ch <- true
+		close(ch)
+	}
+	logger().Println("DeathNotify():", c)
+}
+
+// DeathNotify returns an new channel on which true will be sent once this
+// connection is killed.
+//
+// If this connection is dead, then this function returns nil.
+func (c *Connection) DeathNotify() chan bool {
+	c.access.Lock()
+	defer c.access.Unlock()
+
+	if c.dead {
+		return nil
+	}
+
+	ch := make(chan bool, 1)
+	c.deathNotifications = append(c.deathNotifications, ch)
+	return ch
+}
+
+// Handle defines that when an request with the specified requestName comes in
+// from the server, that the requestHandler function will be invoked in order
+// to handle the request.
+//
+// The requestHandler parameter must be an function, with the type specified
+// below, where T is any valid json.Marshal() type.
+//
+// func(T, T, ..., *Connection) (T, T, ...)
+//
+// If requestHandler is nil, then any existing handler for requestName is
+// removed.
+func (c *Connection) Handle(requestName, requestHandler interface{}) {
+	c.access.Lock()
+	defer c.access.Unlock()
+
+	if requestHandler == nil {
+		delete(c.handlers, requestName)
+		return
+	}
+
+	fn := reflect.ValueOf(requestHandler)
+	if fn.Kind() != reflect.Func {
+		panic("requestHandler parameter type incorrect! Must be function!")
+	}
+
+	fnType := fn.Type()
+	if fnType.NumIn() == 0 || fnType.In(fnType.NumIn()-1) != reflect.TypeOf(c) {
+		panic("requestHandler parameter type incorrect! Last parameter must be *client.Connection")
+	}
+	c.handlers[requestName] = requestHandler
+}
+
+// Request makes an request to the server.
+//
+// It follows the same rules as organics.Connection.Request(); the last
+// (optional) argument may be an function which is invoked with the response
+// data once the request has completed on the server, or with an
+// *organics.Error if the request failed and it's single parameter can hold
+// one.
+//
+// If the argument before it is an function as well, it is invoked with each
+// partial result which the server sends before the response (see
+// organics.Progress).
+//
+// If this connection is dead, this function is no-op.
+func (c *Connection) Request(requestName interface{}, sequence ...interface{}) {
+	if c.Dead() {
+		return
+	}
+
+	args := sequence
+	id := float64(-1) // Never send response to us, please.
+
+	if len(sequence) > 0 {
+		onComplete := sequence[len(sequence)-1]
+		if reflect.ValueOf(onComplete).Kind() == reflect.Func {
+			args = sequence[:len(sequence)-1]
+
+			var onProgress interface{}
+			if len(args) > 0 && reflect.ValueOf(args[len(args)-1]).Kind() == reflect.Func {
+				onProgress = args[len(args)-1]
+				args = args[:len(args)-1]
+			}
+
+			c.access.Lock()
+			id = c.requestCurrentId
+			c.requestCurrentId += 1
+			// Handle overflow, -1 is special id.
+			if c.requestCurrentId == -1 {
+				c.requestCurrentId += 1
+			}
+			c.requestCompleters[id] = onComplete
+			if onProgress != nil {
+				c.requestProgress[id] = onProgress
+			}
+			c.access.Unlock()
+		}
+	}
+
+	err := c.send(newRequestMessage(id, requestName, args))
+	if err != nil {
+		logger().Println("Request failed:", err)
+		c.Kill()
+	}
+}
+
+// send encodes and sends the message using whichever method this connection
+// uses.
+func (c *Connection) send(msg *message) error {
+	encoded, err := msg.jsonEncode()
+	if err != nil {
+		return err
+	}
+
+	switch c.method {
+	case organics.WebSocket:
+		return c.wsSend(encoded)
+	case organics.LongPolling:
+		return c.lpSend(encoded)
+	}
+	return nil
+}
+
+// handleMessage handles an single message received from the server, it is
+// called by both methods.
+func (c *Connection) handleMessage(data []byte) {
+	decoded := new(message)
+	err := decoded.jsonDecode(data)
+	if err != nil {
+		logger().Println(err)
+		c.Kill()
+		return
+	}
+
+	if decoded.goingAway {
+		logger().Println("Server is going away", c)
+		c.access.Lock()
+		c.goingAway = true
+		c.access.Unlock()
+		return
+	}
+
+	if decoded.isRekey {
+		// The ticket gets browsers the new session cookie, long-polling
+		// responses carry it as well, which our cookie jar stores.
+		return
+	}
+
+	if decoded.isCancel {
+		// Requests are handled as soon as they come in, so it's response was
+		// sent already.
+		return
+	}
+
+	if decoded.isProgress {
+		// It's an partial result of one of our requests
+		c.access.RLock()
+		onProgress, ok := c.requestProgress[decoded.id]
+		c.access.RUnlock()
+		if !ok {
+			return
+		}
+
+		defer func() {
+			if r := recover(); r != nil {
+				logger().Printf("Request onProgress panic: %v\n", r)
+			}
+		}()
+		reflect.ValueOf(onProgress).Call(valueSlice(decoded.args))
+		return
+	}
+
+	if !decoded.isRequest {
+		// It's an response to one of our requests
+		c.access.Lock()
+		onComplete, ok := c.requestCompleters[decoded.id]
+		delete(c.requestCompleters, decoded.id)
+		delete(c.requestProgress, decoded.id)
+		c.access.Unlock()
+		if !ok {
+			logger().Println("Invalid request response, id not valid, ignoring.")
+			return
+		}
+		if decoded.err != nil {
+			fnType := reflect.TypeOf(onComplete)
+			if fnType.NumIn() != 1 || !reflect.TypeOf(decoded.err).AssignableTo(fnType.In(0)) {
+				logger().Println("Request failed:", decoded.err)
+				return
+			}
+			decoded.args = []interface{}{decoded.err}
+		}
+
+		defer func() {
+			if r := recover(); r != nil {
+				logger().Printf("Request onComplete panic: %v\n", r)
+			}
+		}()
+		reflect.ValueOf(onComplete).Call(valueSlice(decoded.args))
+		return
+	}
+
+	// It's an request, so invoke the request handler.
+	response := c.callHandler(decoded)
+	if decoded.id == -1 {
+		// They don't want an response.
+		return
+	}
+
+	err = c.send(response)
+	if err != nil {
+		logger().Println("Response failed:", err)
+		c.Kill()
+	}
+}
+
+// callHandler invokes the request handler for the request message, and returns
+// the response message which should be sent back, like the server does.
+func (c *Connection) callHandler(request *message) (response *message) {
+	var handler interface{}
+	ok := false
+	if request.requestName == nil || reflect.TypeOf(request.requestName).Comparable() {
+		// Anything else (e.g. an JSON array) cannot be an map key.
+		c.access.RLock()
+		handler, ok = c.handlers[request.requestName]
+		c.access.RUnlock()
+	}
+	if !ok {
+		logger().Printf("No handler for message \"%v\"\n", request.requestName)
+		return newErrorMessage(request.id, &organics.Error{
+			Code:    organics.CodeNoHandler,
+			Message: fmt.Sprintf("no handler for request %v", request.requestName),
+		})
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			logger().Printf("Request handler \"%v\" panic: %v\n", request.requestName, r)
+			response = newErrorMessage(request.id, &organics.Error{
+				Code:    organics.CodeHandlerPanic,
+				Message: "request handler panic",
+			})
+		}
+	}()
+
+	fn := reflect.ValueOf(handler)
+	fnType := fn.Type()
+	responseValues := fn.Call(append(valueSlice(request.args), reflect.ValueOf(c)))
+
+	// An trailing error return value is never sent as an response argument,
+	// if it is non-nil an error response is sent instead.
+	if n := fnType.NumOut(); n > 0 && fnType.Out(n-1) == errorType {
+		errValue := responseValues[n-1]
+		responseValues = responseValues[:n-1]
+		if !errValue.IsNil() {
+			err := errValue.Interface().(error)
+			e, ok := err.(*organics.Error)
+			if !ok {
+				e = &organics.Error{Code: organics.CodeHandlerError, Message: err.Error()}
+			}
+			return newErrorMessage(request.id, e)
+		}
+	}
+
+	responseArgs := make([]interface{}, len(responseValues))
+	for i, v := range responseValues {
+		responseArgs[i] = v.Interface()
+	}
+	return newResponseMessage(request.id, responseArgs)
+}
+
+// Utility function to convert []interface{} into []reflect.Value
+func valueSlice(s []interface{}) []reflect.Value {
+	valueArgs := make([]reflect.Value, len(s))
+	for i, value := range s {
+		if value == nil {
+			// JSON null; reflect.ValueOf(nil) would be an invalid value.
+			valueArgs[i] = reflect.Zero(emptyInterfaceType)
+			continue
+		}
+		valueArgs[i] = reflect.ValueOf(value)
+	}
+	return valueArgs
+}
@@ -0,0 +1,170 @@
+// Copyright 2012 Lightpoke. All rights reserved.
+// This source code is subject to the terms and
+// conditions defined in the "License.txt" file.
+
+package client_test
+
+import (
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sinni800/organics"
+	"github.com/sinni800/organics/client"
+	"github.com/sinni800/organics/provider/memory"
+)
+
+// newServer starts an Organics server for the test, and returns it along with
+// the URLs which connect to it using each connection method.
+func newServer(t *testing.T) (*organics.Server, map[string]string) {
+	s := organics.NewServer(memory.Provider())
+	s.SetOriginAccess("*", true)
+	hs := httptest.NewServer(s)
+	t.Cleanup(hs.Close)
+
+	return s, map[string]string{
+		"WebSocket":   "ws" + strings.TrimPrefix(hs.URL, "http"),
+		"LongPolling": hs.URL,
+	}
+}
+
+func dial(t *testing.T, url string) *client.Connection {
+	c, err := client.Dial(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(c.Kill)
+	return c
+}
+
+func receive[T any](t *testing.T, ch chan T) T {
+	t.Helper()
+	select {
+	case v := <-ch:
+		return v
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out")
+	}
+	panic("unreachable")
+}
+
+func TestRoundTrip(t *testing.T) {
+	s, urls := newServer(t)
+	s.Handle("Add", func(a, b float64, c *organics.Connection) float64 {
+		return a + b
+	})
+	connected := make(chan *organics.Connection, 1)
+	s.Handle(organics.Connect, func(c *organics.Connection) {
+		connected <- c
+	})
+
+	for name, url := range urls {
+		t.Run(name, func(t *testing.T) {
+			c := dial(t, url)
+			if c.Method().String() != name {
+				t.Fatalf("Method() = %v, want %s", c.Method(), name)
+			}
+			sc := receive(t, connected)
+
+			// Client to server.
+			sum := make(chan float64, 1)
+			c.Request("Add", 1, 2, func(v float64) {
+				sum <- v
+			})
+			if v := receive(t, sum); v != 3 {
+				t.Fatalf("Add(1, 2) = %v, want 3", v)
+			}
+
+			// Server to client.
+			c.Handle("Greet", func(name string, c *client.Connection) string {
+				return "Hello " + name
+			})
+			greeting := make(chan string, 1)
+			sc.Request("Greet", "Go", func(v string) {
+				greeting <- v
+			})
+			if v := receive(t, greeting); v != "Hello Go" {
+				t.Fatalf("Greet(Go) = %q, want %q", v, "Hello Go")
+			}
+		})
+	}
+}
+
+func TestErrorResponses(t *testing.T) {
+	s, urls := newServer(t)
+	s.Handle("Fail", func(c *organics.Connection) error {
+		return &organics.Error{Code: 42, Message: "nope"}
+	})
+	connected := make(chan *organics.Connection, 1)
+	s.Handle(organics.Connect, func(c *organics.Connection) {
+		connected <- c
+	})
+
+	for name, url := range urls {
+		t.Run(name, func(t *testing.T) {
+			c := dial(t, url)
+			sc := receive(t, connected)
+
+			failed := make(chan *organics.Error, 1)
+			c.Request("Fail", func(err *organics.Error) {
+				failed <- err
+			})
+			if err := receive(t, failed); err.Code != 42 || err.Message != "nope" {
+				t.Fatalf("Fail got %v, want code 42", err)
+			}
+			c.Request("NoSuchRequest", func(err *organics.Error) {
+				failed <- err
+			})
+			if err := receive(t, failed); err.Code != organics.CodeNoHandler {
+				t.Fatalf("NoSuchRequest got %v, want CodeNoHandler", err)
+			}
+
+			c.Handle("ClientFail", func(c *client.Connection) error {
+				return errors.New("bad")
+			})
+			sc.Request("ClientFail", func(err *organics.Error) {
+				failed <- err
+			})
+			if err := receive(t, failed); err.Code != organics.CodeHandlerError || err.Message != "bad" {
+				t.Fatalf("ClientFail got %v, want CodeHandlerError", err)
+			}
+			sc.Request([]interface{}{1, 2}, func(err *organics.Error) {
+				failed <- err
+			})
+			if err := receive(t, failed); err.Code != organics.CodeNoHandler {
+				t.Fatalf("array request name got %v, want CodeNoHandler", err)
+			}
+		})
+	}
+}
+
+func TestKill(t *testing.T) {
+	s, urls := newServer(t)
+	connected := make(chan *organics.Connection, 1)
+	s.Handle(organics.Connect, func(c *organics.Connection) {
+		connected <- c
+	})
+
+	for name, url := range urls {
+		t.Run(name, func(t *testing.T) {
+			c := dial(t, url)
+			sc := receive(t, connected)
+
+			// The server kills the connection, the client must notice.
+			dead := c.DeathNotify()
+			sc.Kill()
+			receive(t, dead)
+			if !c.Dead() {
+				t.Fatal("Dead() = false after the server killed the connection")
+			}
+		})
+	}
+}
+
+func TestBadScheme(t *testing.T) {
+	if _, err := client.Dial("ftp://localhost/"); err != client.ErrBadScheme {
+		t.Fatalf("Dial(ftp://) error = %v, want ErrBadScheme", err)
+	}
+}
@@ -0,0 +1,145 @@
+// Copyright 2012 Lightpoke. All rights reserved.
+// This source code is subject to the terms and
+// conditions defined in the "License.txt" file.
+
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/cookiejar"
+)
+
+type lpConn struct {
+	client       *http.Client
+	connectionId string
+	ctx          context.Context
+	cancel       context.CancelFunc
+
+	// Closed once the previously received message has been handled.
+	lastHandled chan bool
+}
+
+// lpPost performs an single long-polling POST request of the given request
+// type, and returns the response body.
+func (c *Connection) lpPost(requestType string, data []byte) ([]byte, error) {
+	req, err := http.NewRequest("POST", c.url.String(), bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(c.lp.ctx)
+	req.Header.Set("Content-Type", "text/plain;charset=UTF-8")
+	req.Header.Set("X-Organics-Req", requestType)
+	if requestType != rtLongPollEstablishConnection {
+		req.Header.Set("X-Organics-Conn", c.lp.connectionId)
+	} else {
+		for key, values := range c.header {
+			req.Header[key] = values
+		}
+	}
+
+	resp, err := c.lp.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s request failed (%s)", requestType, resp.Status)
+	}
+	return body, nil
+}
+
+func (c *Connection) lpDial() error {
+	// The session cookie is stored in the jar, and sent along with every
+	// request we make afterwards.
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return err
+	}
+
+	c.lp = new(lpConn)
+	c.lp.client = &http.Client{Jar: jar}
+	c.lp.ctx, c.lp.cancel = context.WithCancel(context.Background())
+
+	// The response to an establish connection request is our connection id,
+	// which we send back through the X-Organics-Conn header from now on.
+	connectionId, err := c.lpPost(rtLongPollEstablishConnection, nil)
+	if err != nil {
+		c.lp.cancel()
+		return err
+	}
+	c.lp.connectionId = string(connectionId)
+
+	go c.lpPoll()
+	return nil
+}
+
+func (c *Connection) lpPoll() {
+	for {
+		msg, err := c.lpPost(rtLongPoll, nil)
+		if err != nil {
+			if !c.Dead() {
+				logger().Println("long-polling request failed:", err)
+			}
+			c.Kill()
+			return
+		}
+
+		// An empty response is an ping, all we need to do is to long-poll
+		// again ASAP to respond to it.
+		if len(msg) == 0 {
+			continue
+		}
+
+		// Otherwise it is an JSON array of every message the server had
+		// queued for us, in order.
+		var batch []json.RawMessage
+		err = json.Unmarshal(msg, &batch)
+		if err != nil {
+			logger().Println("long-polling response invalid:", err)
+			c.Kill()
+			return
+		}
+		for _, m := range batch {
+			c.lpHandleMessage(m)
+		}
+	}
+}
+
+// lpHandleMessage handles the message without blocking the long-polling loop,
+// because the server only completes our own requests once it can respond to
+// them through an long-poll request. Messages are still handled in the order
+// they where received.
+func (c *Connection) lpHandleMessage(msg []byte) {
+	previous := c.lp.lastHandled
+	handled := make(chan bool)
+	c.lp.lastHandled = handled
+
+	go func() {
+		if previous != nil {
+			<-previous
+		}
+		c.handleMessage(msg)
+		close(handled)
+	}()
+}
+
+func (c *Connection) lpSend(msg []byte) error {
+	_, err := c.lpPost(rtMessage, msg)
+	return err
+}
+
+func (c *Connection) lpClose() {
+	// Cancelling the outstanding long-poll request closes it's HTTP
+	// connection, which the server takes as the client going away.
+	c.lp.cancel()
+}
@@ -0,0 +1,211 @@
+// Copyright 2012 Lightpoke. All rights reserved.
+// This source code is subject to the terms and
+// conditions defined in the "License.txt" file.
+
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/sinni800/organics"
+)
+
+// These constants are acronyms, and are used inside HTTP headers to determine
+// the type of long-polling request, they must match the ones the server uses.
+const (
+	rtLongPollEstablishConnection = "lpec" // long-poll-establish-connection
+	rtLongPoll                    = "lp"   // long-poll
+	rtMessage                     = "m"    // message
+)
+
+// Frames which are not an request or response to one begin with an string
+// instead of an numeric id, they must match the ones the server uses.
+const (
+	ftError     = "e" // error response
+	ftGoingAway = "g" // the server is shutting down
+	ftCancel    = "c" // cancels an request which is in flight
+	ftProgress  = "p" // partial result of an request
+	ftRekey     = "k" // ticket of an regenerated session
+)
+
+// See the message type in the organics package for an description of the two
+// types of message (request and response) which may be sent either way.
+type message struct {
+	id          float64
+	requestName interface{}
+	args        []interface{}
+	isRequest   bool
+	err         *organics.Error
+	goingAway   bool
+	isCancel    bool
+	isProgress  bool
+	isRekey     bool
+}
+
+func newRequestMessage(id float64, requestName interface{}, args []interface{}) *message {
+	m := &message{}
+	m.id = id
+	m.requestName = requestName
+	m.args = args
+	m.isRequest = true
+	return m
+}
+
+func newResponseMessage(id float64, args []interface{}) *message {
+	m := &message{}
+	m.id = id
+	m.args = args
+	m.isRequest = false
+	return m
+}
+
+func newErrorMessage(id float64, err *organics.Error) *message {
+	m := &message{}
+	m.id = id
+	m.err = err
+	m.isRequest = false
+	return m
+}
+
+// JsonEncode encodes this *message, m, into an JSON-encoded []byte, or returns
+// an error if one is encountered.
+func (m *message) jsonEncode() (encoded []byte, err error) {
+	if m.isRequest {
+		args := m.args
+		if args == nil {
+			// Always an JSON array, never null.
+			args = make([]interface{}, 0)
+		}
+		encoded, err = json.Marshal([]interface{}{m.id, m.requestName, args})
+	} else if m.err != nil {
+		encoded, err = json.Marshal([]interface{}{ftError, m.id, m.err})
+	} else {
+		if len(m.args) == 0 {
+			encoded, err = json.Marshal([]interface{}{m.id})
+		} else {
+			encoded, err = json.Marshal([]interface{}{m.id, m.args})
+		}
+	}
+	if err != nil {
+		err = errors.New("Error encoding JSON; " + err.Error())
+	}
+	return
+}
+
+// JsonDecode decodes the data parameter, an array of JSON-encoded []byte, into
+// this *message, m, or returns an error if one is encountered.
+func (m *message) jsonDecode(data []byte) error {
+	var decoded []interface{}
+	err := json.Unmarshal(data, &decoded)
+	if err != nil {
+		return errors.New("Error decoding JSON; " + err.Error())
+	}
+
+	var ok bool
+	if len(decoded) == 1 {
+		if frame, isFrame := decoded[0].(string); isFrame && frame == ftGoingAway {
+			// The server is going away, in format of ["g"]
+			m.goingAway = true
+			return nil
+		}
+	}
+	if len(decoded) == 2 {
+		if frame, isFrame := decoded[0].(string); isFrame && frame == ftCancel {
+			// The server cancelled an request, in format of ["c", id]
+			m.isCancel = true
+			m.id, ok = decoded[1].(float64)
+			if !ok {
+				return errors.New("Error decoding JSON; id is not an json number!")
+			}
+			return nil
+		}
+		if frame, isFrame := decoded[0].(string); isFrame && frame == ftRekey {
+			// Our session was regenerated, in format of ["k", ticket]
+			m.isRekey = true
+			return nil
+		}
+	}
+	if len(decoded) == 3 {
+		if frame, isFrame := decoded[0].(string); isFrame && frame == ftProgress {
+			// It's an partial result, in format of ["p", id, args]
+			m.isProgress = true
+
+			m.id, ok = decoded[1].(float64)
+			if !ok {
+				return errors.New("Error decoding JSON; id is not an json number!")
+			}
+
+			m.args, ok = decoded[2].([]interface{})
+			if !ok {
+				return errors.New("Error decoding JSON; args list is not an json array!")
+			}
+			return nil
+		}
+		if frame, isFrame := decoded[0].(string); isFrame && frame == ftError {
+			// It's an error response, in format of ["e", id, error]
+			m.isRequest = false
+
+			m.id, ok = decoded[1].(float64)
+			if !ok {
+				return errors.New("Error decoding JSON; id is not an json number!")
+			}
+
+			e, ok := decoded[2].(map[string]interface{})
+			if !ok {
+				return errors.New("Error decoding JSON; error is not an json object!")
+			}
+			m.err = new(organics.Error)
+			code, _ := e["code"].(float64)
+			m.err.Code = int(code)
+			m.err.Message, _ = e["message"].(string)
+			m.err.Data = e["data"]
+			return nil
+		}
+	}
+
+	switch len(decoded) {
+	case 1:
+		// It's an response, in format of [id]
+		m.isRequest = false
+
+		m.id, ok = decoded[0].(float64)
+		if !ok {
+			return errors.New("Error decoding JSON; id is not an json number!")
+		}
+		m.args = make([]interface{}, 0)
+
+	case 2:
+		// It's an response, in format of [id, args]
+		m.isRequest = false
+
+		m.id, ok = decoded[0].(float64)
+		if !ok {
+			return errors.New("Error decoding JSON; id is not an json number!")
+		}
+
+		m.args, ok = decoded[1].([]interface{})
+		if !ok {
+			return errors.New("Error decoding JSON; args list is not an json array!")
+		}
+
+	case 3:
+		// It's an request, in format of [id, requestName, args]
+		m.isRequest = true
+
+		m.id, ok = decoded[0].(float64)
+		if !ok {
+			return errors.New("Error decoding JSON; id is not an json number!")
+		}
+
+		m.requestName = decoded[1]
+
+		m.args, ok = decoded[2].([]interface{})
+		if !ok {
+			return errors.New("Error decoding JSON; args list is not an json array!")
+		}
+
+	default:
+		return errors.New("Error decoding JSON; message must be array of length 1, 2, or 3!")
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+// Copyright 2012 Lightpoke. All rights reserved.
+// This source code is subject to the terms and
+// conditions defined in the "License.txt" file.
+
+package client
+
+import (
+	"golang.org/x/net/websocket"
+	"io"
+	"io/ioutil"
+)
+
+type wsConn struct {
+	*websocket.Conn
+}
+
+// See https://code.google.com/p/organics/issues/detail?id=4 (issue 4)
+func (ws *wsConn) sendMessage(msg []byte) error {
+	w, err := ws.NewFrameWriter(websocket.TextFrame)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(msg)
+	w.Close()
+	return err
+}
+
+func (ws *wsConn) receiveMessage() ([]byte, error) {
+again:
+	frame, err := ws.NewFrameReader()
+	if err != nil {
+		return nil, err
+	}
+	frame, err = ws.HandleFrame(frame)
+	if err != nil {
+		return nil, err
+	}
+	if frame == nil {
+		goto again
+	}
+	return ioutil.ReadAll(frame)
+}
+
+func (c *Connection) wsDial() error {
+	// The server requires an allowed Origin header on WebSocket connections,
+	// so we claim to be from the same origin as the server itself.
+	origin := *c.url
+	origin.Path = ""
+	origin.RawQuery = ""
+	if origin.Scheme == "wss" {
+		origin.Scheme = "https"
+	} else {
+		origin.Scheme = "http"
+	}
+
+	config, err := websocket.NewConfig(c.url.String(), origin.String())
+	if err != nil {
+		return err
+	}
+	for key, values := range c.header {
+		config.Header[key] = values
+	}
+
+	ws, err := websocket.DialConfig(config)
+	if err != nil {
+		return err
+	}
+	c.ws = &wsConn{ws}
+
+	go c.wsRead()
+	return nil
+}
+
+func (c *Connection) wsRead() {
+	for {
+		msg, err := c.ws.receiveMessage()
+		if err != nil {
+			if err != io.EOF && !c.Dead() {
+				logger().Println("receiveMessage() failed:", err)
+			}
+			c.Kill()
+			return
+		}
+
+		// Messages of length zero are pings, which we respond to with an
+		// empty message (A.K.A. Pong).
+		if len(msg) == 0 {
+			err = c.wsSend(msg)
+			if err != nil {
+				logger().Println("Error writing", err, c)
+				c.Kill()
+				return
+			}
+			continue
+		}
+
+		c.handleMessage(msg)
+	}
+}
+
+func (c *Connection) wsSend(msg []byte) error {
+	c.writeAccess.Lock()
+	defer c.writeAccess.Unlock()
+
+	return c.ws.sendMessage(msg)
+}
+
+func (c *Connection) wsClose() {
+	// Closing writes an close frame, so it must not happen during wsSend.
+	c.writeAccess.Lock()
+	defer c.writeAccess.Unlock()
+
+	c.ws.Close()
+}
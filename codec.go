@@ -0,0 +1,189 @@
+// Copyright 2012 Lightpoke. All rights reserved.
+// This source code is subject to the terms and
+// conditions defined in the "License.txt" file.
+
+package organics
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"reflect"
+	"strings"
+)
+
+// Codec encodes and decodes the frames carried over an connection, it is
+// negotiated with each client as they connect (see Server.SetCodec).
+//
+// Every frame is an array (see messages.go), so an codec only needs to handle
+// arrays of the generic values which JSON decodes into; it must decode into
+// the same types: nil, bool, float64, string, []interface{} and
+// map[string]interface{}, except that integers may instead be decoded into
+// int64 (or uint64, if too large for an int64) and binary data into []byte.
+type Codec interface {
+	// Name returns the name of this codec, clients ask for it by offering the
+	// "organics." + Name() WebSocket subprotocol, or by naming it in the
+	// X-Organics-Codec header (long-polling) or organics-codec query parameter
+	// (server-sent events) of the request which establishes their connection.
+	Name() string
+
+	// Binary tells weather frames are binary: they are sent as binary (instead
+	// of text) WebSocket frames, and base64 encoded in event streams.
+	Binary() bool
+
+	// Marshal encodes the frame.
+	Marshal(frame []interface{}) ([]byte, error)
+
+	// Unmarshal decodes an frame encoded by Marshal.
+	Unmarshal(data []byte) ([]interface{}, error)
+}
+
+// CodecTransport is implemented by transports whose frames may be encoded
+// using an codec other than JSON.
+type CodecTransport interface {
+	Transport
+
+	// Codec returns the codec which frames carried by this transport are
+	// encoded using.
+	Codec() Codec
+}
+
+// JSON is the default codec, it encodes frames as JSON text.
+var JSON Codec = jsonCodec{}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string {
+	return "json"
+}
+
+func (jsonCodec) Binary() bool {
+	return false
+}
+
+func (jsonCodec) Marshal(frame []interface{}) ([]byte, error) {
+	return json.Marshal(frame)
+}
+
+func (jsonCodec) Unmarshal(data []byte) ([]interface{}, error) {
+	var frame []interface{}
+	err := json.Unmarshal(data, &frame)
+	return frame, err
+}
+
+// Prefix of the WebSocket subprotocols which name an codec.
+const codecProtocolPrefix = "organics."
+
+// The header which carries the codecs an long-polling client offers (and the
+// one chosen for it, in the response), and the query parameter which carries
+// those an server-sent events client offers. Both hold codec names separated
+// by commas, like "msgpack, json".
+const (
+	hdrCodec = "X-Organics-Codec"
+	qpCodec  = "organics-codec"
+)
+
+// negotiateCodec chooses the codec to use for an WebSocket connection, given
+// the subprotocols the client offered, and returns the subprotocol which tells
+// the client which one was chosen (empty if they offered none).
+//
+// The server's codec is chosen if they offered it, otherwise JSON is.
+func (s *Server) negotiateCodec(offered []string) (codec Codec, protocol string) {
+	preferred := s.Codec()
+	for _, c := range []Codec{preferred, JSON} {
+		for _, p := range offered {
+			if p == codecProtocolPrefix+c.Name() {
+				return c, p
+			}
+		}
+	}
+	return JSON, ""
+}
+
+// negotiateCodecNames chooses the codec to use for an long-polling or
+// server-sent events connection, given the names of the codecs the client
+// offered (see hdrCodec), just like negotiateCodec does.
+func (s *Server) negotiateCodecNames(offered string) Codec {
+	var protocols []string
+	for _, name := range strings.Split(offered, ",") {
+		if name = strings.TrimSpace(name); len(name) > 0 {
+			protocols = append(protocols, codecProtocolPrefix+name)
+		}
+	}
+	codec, _ := s.negotiateCodec(protocols)
+	return codec
+}
+
+// codecForProtocol returns the codec which the negotiated WebSocket subprotocol
+// names.
+func (s *Server) codecForProtocol(protocols []string) Codec {
+	if len(protocols) == 1 && strings.HasPrefix(protocols[0], codecProtocolPrefix) {
+		codec, _ := s.negotiateCodec(protocols)
+		return codec
+	}
+	return JSON
+}
+
+// transportCodec returns the codec which frames carried by the transport are
+// encoded using.
+func transportCodec(t Transport) Codec {
+	if ct, ok := t.(CodecTransport); ok {
+		return ct.Codec()
+	}
+	return JSON
+}
+
+// toFloat64 converts an decoded number into an float64.
+func toFloat64(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case int64:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	}
+	return 0, false
+}
+
+// convertNumber converts the numeric value into an value of the numeric type
+// t, if that can be done without losing anything.
+func convertNumber(v reflect.Value, t reflect.Type) (reflect.Value, bool) {
+	if !isNumber(v.Kind()) || !isNumber(t.Kind()) {
+		return v, false
+	}
+	converted := v.Convert(t)
+	if converted.Convert(v.Type()).Interface() != v.Interface() {
+		return v, false
+	}
+	if f, ok := v.Interface().(float64); ok && (math.IsNaN(f) || math.IsInf(f, 0)) {
+		return v, false
+	}
+	return converted, true
+}
+
+func isNumber(k reflect.Kind) bool {
+	switch k {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+// toGeneric converts v into the generic values which JSON decodes into, using
+// JSON itself (so that struct tags, json.Marshaler and the like are honored).
+//
+// Numbers are json.Number values, so that integers keep their precision.
+func toGeneric(v interface{}) (interface{}, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var generic interface{}
+	err = dec.Decode(&generic)
+	return generic, err
+}
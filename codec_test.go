@@ -0,0 +1,250 @@
+// Copyright 2012 Lightpoke. All rights reserved.
+// This source code is subject to the terms and
+// conditions defined in the "License.txt" file.
+
+package organics_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"math"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/websocket"
+
+	"github.com/sinni800/organics"
+)
+
+func TestMessagePack(t *testing.T) {
+	frame := []interface{}{
+		int64(1), "Add", []interface{}{
+			int64(-5), 3.5, int64(300), int64(-40000), uint64(math.MaxUint64),
+			nil, true, false, strings.Repeat("x", 300), []byte{1, 2},
+			map[string]interface{}{"a": int64(1)},
+		},
+	}
+	data, err := organics.MessagePack.Marshal(frame)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := organics.MessagePack.Unmarshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, frame) {
+		t.Fatalf("decoded %#v, want %#v", decoded, frame)
+	}
+
+	// Other types are encoded the way JSON would, integers as integers.
+	type point struct {
+		X int `json:"x"`
+		Y float64
+	}
+	data, err = organics.MessagePack.Marshal([]interface{}{float64(2), []interface{}{point{1, 2.5}, float32(1.5), []int{1, 2}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err = organics.MessagePack.Unmarshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []interface{}{int64(2), []interface{}{map[string]interface{}{"x": int64(1), "Y": 2.5}, 1.5, []interface{}{int64(1), int64(2)}}}
+	if !reflect.DeepEqual(decoded, want) {
+		t.Fatalf("decoded %#v, want %#v", decoded, want)
+	}
+
+	for _, bad := range [][]byte{{0x93, 0x01}, {0xdd, 0xff, 0xff, 0xff, 0xff}, {0xc1}, {0x91, 0x01, 0x02}} {
+		if _, err := organics.MessagePack.Unmarshal(bad); err == nil {
+			t.Errorf("Unmarshal(%x) did not fail", bad)
+		}
+	}
+}
+
+func TestCodecNegotiation(t *testing.T) {
+	s := newServer(t)
+	s.SetCodec(organics.MessagePack)
+	s.Handle("Double", func(n int, c *organics.Connection) int {
+		return n * 2
+	})
+
+	// Clients which offer MessagePack get it.
+	ws, _ := s.rawWebSocket(t, "organics.msgpack", "organics.json")
+	if p := ws.Config().Protocol; len(p) != 1 || p[0] != "organics.msgpack" {
+		t.Fatalf("negotiated %v, want organics.msgpack", p)
+	}
+	frame, err := organics.MessagePack.Marshal([]interface{}{1, "Double", []interface{}{21}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	websocket.Message.Send(ws, frame)
+	ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var data []byte
+	if err := websocket.Message.Receive(ws, &data); err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := organics.MessagePack.Unmarshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []interface{}{int64(1), []interface{}{int64(42)}}; !reflect.DeepEqual(decoded, want) {
+		t.Fatalf("got frame %#v, want %#v", decoded, want)
+	}
+
+	// Clients which only offer JSON, or nothing at all, get JSON.
+	for _, protocols := range [][]string{{"organics.json"}, nil} {
+		ws, _ := s.rawWebSocket(t, protocols...)
+		websocket.Message.Send(ws, `[1,"Double",[4]]`)
+		ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+		var frame string
+		if err := websocket.Message.Receive(ws, &frame); err != nil || frame != `[1,[8]]` {
+			t.Fatalf("offering %v got frame %s (%v), want [1,[8]]", protocols, frame, err)
+		}
+	}
+
+	// The Go client offers nothing over long-polling, so it gets JSON.
+	c, _ := s.dial(t, s.urls()["LongPolling"])
+	doubled := make(chan float64, 1)
+	c.Request("Double", 5, func(n float64) {
+		doubled <- n
+	})
+	if n := receive(t, doubled); n != 10 {
+		t.Fatalf("Double(5) over long-polling = %v, want 10", n)
+	}
+}
+
+// msgpackBatch encodes the frames using MessagePack, as an batch of
+// length-prefixed frames.
+func msgpackBatch(t *testing.T, frames ...[]interface{}) string {
+	t.Helper()
+	batch := new(bytes.Buffer)
+	for _, frame := range frames {
+		data, err := organics.MessagePack.Marshal(frame)
+		if err != nil {
+			t.Fatal(err)
+		}
+		binary.Write(batch, binary.BigEndian, uint32(len(data)))
+		batch.Write(data)
+	}
+	return batch.String()
+}
+
+// splitMsgpackBatch decodes an batch of length-prefixed MessagePack frames.
+func splitMsgpackBatch(t *testing.T, batch string) [][]interface{} {
+	t.Helper()
+	var frames [][]interface{}
+	data := []byte(batch)
+	for len(data) > 0 {
+		if len(data) < 4 {
+			t.Fatalf("truncated batch %x", batch)
+		}
+		n := binary.BigEndian.Uint32(data)
+		if uint64(n) > uint64(len(data)-4) {
+			t.Fatalf("truncated batch %x", batch)
+		}
+		frame, err := organics.MessagePack.Unmarshal(data[4 : 4+n])
+		if err != nil {
+			t.Fatal(err)
+		}
+		frames = append(frames, frame)
+		data = data[4+n:]
+	}
+	return frames
+}
+
+func TestLongPollCodec(t *testing.T) {
+	s := newServer(t)
+	s.SetCodec(organics.MessagePack)
+	s.Handle("Double", func(n int, c *organics.Connection) int {
+		return n * 2
+	})
+
+	// Clients which offer MessagePack get it, for batches both ways.
+	lp := &lpClient{t: t, url: s.http.URL, header: http.Header{"X-Organics-Codec": {"msgpack, json"}}}
+	lp.post("lpec", "")
+	if lp.codec != "msgpack" {
+		t.Fatalf("negotiated %q, want msgpack", lp.codec)
+	}
+	if status, _ := lp.post("m", msgpackBatch(t, []interface{}{1, "Double", []interface{}{21}}, []interface{}{2, "Double", []interface{}{2}})); status != http.StatusOK {
+		t.Fatalf("message POST status = %d", status)
+	}
+	results := make(map[int64]interface{})
+	for len(results) < 2 {
+		_, body := lp.post("lp", "")
+		for _, frame := range splitMsgpackBatch(t, body) {
+			results[frame[0].(int64)] = frame[1]
+		}
+	}
+	want := map[int64]interface{}{1: []interface{}{int64(42)}, 2: []interface{}{int64(4)}}
+	if !reflect.DeepEqual(results, want) {
+		t.Fatalf("got responses %#v, want %#v", results, want)
+	}
+	if status, _ := lp.post("m", "\x00\x00\x00\x09ab"); status != http.StatusBadRequest {
+		t.Fatalf("truncated batch got status %d, want 400", status)
+	}
+
+	// Clients which only offer JSON get it.
+	lp = &lpClient{t: t, url: s.http.URL, header: http.Header{"X-Organics-Codec": {"json"}}}
+	lp.post("lpec", "")
+	if lp.codec != "json" {
+		t.Fatalf("negotiated %q, want json", lp.codec)
+	}
+	lp.post("m", `[1,"Double",[4]]`)
+	if _, body := lp.post("lp", ""); body != `[[1,[8]]]` {
+		t.Fatalf("got batch %s, want [[1,[8]]]", body)
+	}
+}
+
+func TestServerSentEventsCodec(t *testing.T) {
+	s := newServer(t)
+	s.SetCodec(organics.MessagePack)
+	s.Handle("Double", func(n int, c *organics.Connection) int {
+		return n * 2
+	})
+
+	// The chosen codec follows the connection id, and binary frames are
+	// base64 encoded.
+	hc, events := s.eventStream(t, s.http.URL+"?organics-codec=msgpack,json")
+	first := receive(t, events)
+	lines := strings.Split(first.data, "\n")
+	if first.name != "c" || len(lines) != 2 || lines[1] != "msgpack" {
+		t.Fatalf("first event = %+v, want the connection id and msgpack", first)
+	}
+	req, err := http.NewRequest("POST", s.http.URL, strings.NewReader(msgpackBatch(t, []interface{}{1, "Double", []interface{}{21}})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-Organics-Req", "m")
+	req.Header.Set("X-Organics-Conn", lines[0])
+	resp, err := hc.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("message POST status = %s", resp.Status)
+	}
+
+	for {
+		e := receive(t, events)
+		if e.name != "" {
+			continue
+		}
+		data, err := base64.StdEncoding.DecodeString(e.data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		frame, err := organics.MessagePack.Unmarshal(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := []interface{}{int64(1), []interface{}{int64(42)}}; !reflect.DeepEqual(frame, want) {
+			t.Fatalf("got frame %#v, want %#v", frame, want)
+		}
+		return
+	}
+}
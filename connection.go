@@ -5,8 +5,12 @@
 package organics
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"reflect"
+	"runtime/debug"
 	"sync"
 	"time"
 )
@@ -20,6 +24,11 @@ const (
 
 	// Describes the web socket connection method.
 	WebSocket
+
+	// Describes the server-sent events connection method, an EventSource
+	// stream carries frames to the browser, and POST requests (just like
+	// long-polling) carry frames to the server.
+	ServerSentEvents
 )
 
 // String returns an string formatted version of the specified method, or an
@@ -28,6 +37,7 @@ const (
 // For example:
 //  WebSocket.String() would return "WebSocket"
 //  LongPolling.String() would return "LongPolling"
+//  ServerSentEvents.String() would return "ServerSentEvents"
 //
 func (m Method) String() string {
 	switch m {
@@ -36,10 +46,17 @@ func (m Method) String() string {
 
 	case WebSocket:
 		return "WebSocket"
+
+	case ServerSentEvents:
+		return "ServerSentEvents"
 	}
 	return ""
 }
 
+// ErrDead is returned by RequestContext() when the Connection, or it's
+// Session, is dead or dies before the request has completed.
+var ErrDead = errors.New("organics: connection is dead")
+
 // Connection represents an single connection to an web browser, this
 // connection will remain for as long as the user keeps the web page open
 // through their browser.
@@ -55,11 +72,43 @@ type Connection struct {
 	method                                                   Method
 	session                                                  *Session
 	disconnectFromTimeout, disconnectTimerReset, performPing chan bool
-	lpWaitingForDeath, hasDisconnectTimer                    bool
+	hasDisconnectTimer                                       bool
+	died                                                     chan bool
+	transport                                                Transport
+	requests                                                 chan *message
+	goingAway, flushNotify                                   chan chan bool
+
+	// Non-nil for resumable connections (see resume.go).
+	resume *resumeState
 
-	messageChan       chan *message
+	// Cancelled once this connection dies.
+	ctx       context.Context
+	ctxCancel context.CancelFunc
+
+	outgoing          *messageQueue
 	requestCurrentId  float64
 	requestCompleters map[float64]interface{}
+
+	// Requests from the other end which are being handled, or waiting to be,
+	// by request id (see track).
+	inFlight map[float64]*inFlightRequest
+
+	// The store keys which the other end watches, by scope (see watch.go).
+	watching map[StoreScope]map[string]bool
+
+	// Weather the other end is in token mode (see Server.SetSessionTokens).
+	tokens bool
+
+	// Why this connection died, set (once) before it does.
+	reason    DisconnectReason
+	hasReason bool
+}
+
+// inFlightRequest is an request from the other end which is being handled, or
+// waiting to be, it's context is cancelled if they cancel the request.
+type inFlightRequest struct {
+	ctx    context.Context
+	cancel context.CancelFunc
 }
 
 // Request makes an request to the other end of this Connection.
@@ -82,6 +131,14 @@ type Connection struct {
 //
 // Where T are whatever data types the function on the other end will return
 // once invoked.
+//
+// If the request fails on the other end, the function is instead given an
+// *Error as it's only argument, as long as it's single parameter can hold one
+// (e.g. func(error) or func(*Error)), otherwise the failure is only logged.
+//
+// Requests arrive on the other end in the order they where made. This function
+// does not block, unless the outgoing queue of this connection is full and the
+// server's queue policy is QueueBlock (see Server.SetQueuePolicy).
 func (c *Connection) Request(requestName interface{}, sequence ...interface{}) {
 	if c.Dead() {
 		return
@@ -91,44 +148,216 @@ func (c *Connection) Request(requestName interface{}, sequence ...interface{}) {
 		return
 	}
 
-	var onComplete interface{}
-	var hasOnComplete bool
-	var args []interface{}
+	args := sequence
+	id := float64(-1) // Never send response to us, please.
+
+	if len(sequence) > 0 {
+		onComplete := sequence[len(sequence)-1]
+		if reflect.ValueOf(onComplete).Kind() == reflect.Func {
+			args = sequence[:len(sequence)-1]
+			id = c.addCompleter(onComplete)
+		}
+	}
+
+	c.outgoing.push(newRequestMessage(id, requestName, args))
+}
+
+// RequestContext makes an request to the other end of this Connection, and
+// blocks until the request has completed, returning the response data from
+// the request.
+//
+// The parameters are the same as for Request(), except that no completion
+// function may be given, as the response data is returned instead.
+//
+// If the context is cancelled, or it's deadline is exceeded, before the
+// request has completed then ctx.Err() is returned. If this Connection or it's
+// Session is dead, or dies before the request has completed, then ErrDead is
+// returned. If the request failed on the other end, then the *Error it
+// responded with is returned (or, if the request was dropped from the outgoing
+// queue, an *Error with the CodeQueueFull code).
+//
+// If the context or connection ends first, the request is forgotten, any
+// response that arrives later on is ignored. If the context ends first, the
+// other end is told that the request was cancelled, too.
+func (c *Connection) RequestContext(ctx context.Context, requestName interface{}, args ...interface{}) ([]interface{}, error) {
+	if c.Dead() || c.session.Dead() {
+		return nil, ErrDead
+	}
+
+	type response struct {
+		results []interface{}
+		err     *Error
+	}
+	done := make(chan response, 1)
+	id := c.addCompleter(completer(func(results []interface{}, err *Error) {
+		done <- response{results, err}
+	}))
+
+	// Queued in order along with any other request, now wait for the response.
+	c.outgoing.push(newRequestMessage(id, requestName, args))
+	select {
+	case r := <-done:
+		if r.err != nil {
+			return nil, r.err
+		}
+		return r.results, nil
+
+	case <-c.ctx.Done():
+		c.removeCompleter(id)
+		return nil, ErrDead
 
+	case <-ctx.Done():
+		if _, ok := c.takeCompleter(id); ok {
+			// Tell them we gave up, so they can stop handling it.
+			c.outgoing.push(newCancelMessage(id))
+		}
+		return nil, ctx.Err()
+	}
+}
+
+// completer is an request completion function which is used internally; unlike
+// the user-provided ones it is told about error responses.
+type completer func(results []interface{}, err *Error)
+
+// addCompleter stores the request completion function, and returns the new
+// request id which it is stored under.
+func (c *Connection) addCompleter(onComplete interface{}) float64 {
 	c.access.Lock()
-	id := c.requestCurrentId
+	defer c.access.Unlock()
 
+	id := c.requestCurrentId
 	c.requestCurrentId += 1
 	// Handle overflow, -1 is special id.
 	if c.requestCurrentId == -1 {
 		c.requestCurrentId += 1
 	}
+
+	c.requestCompleters[id] = onComplete
+	return id
+}
+
+// takeCompleter removes and returns the request completion function stored
+// under the specified request id.
+func (c *Connection) takeCompleter(id float64) (onComplete interface{}, ok bool) {
+	c.access.Lock()
+	defer c.access.Unlock()
+
+	onComplete, ok = c.requestCompleters[id]
+	delete(c.requestCompleters, id)
+	return
+}
+
+func (c *Connection) removeCompleter(id float64) {
+	c.takeCompleter(id)
+}
+
+// track starts tracking the request from the other end, until it is untracked
+// or cancelled by them. Requests which want no response cannot be cancelled,
+// and are not tracked.
+func (c *Connection) track(request *message) {
+	if request.id == -1 {
+		return
+	}
+	ctx, cancel := context.WithCancel(c.ctx)
+
+	c.access.Lock()
+	defer c.access.Unlock()
+
+	c.inFlight[request.id] = &inFlightRequest{ctx, cancel}
+}
+
+// requestContext returns the context of the request from the other end, which
+// is cancelled if they cancel the request, or false if they already did.
+func (c *Connection) requestContext(request *message) (context.Context, bool) {
+	if request.id == -1 {
+		return c.ctx, true
+	}
+
+	c.access.RLock()
+	defer c.access.RUnlock()
+
+	r, ok := c.inFlight[request.id]
+	if !ok {
+		return nil, false
+	}
+	return r.ctx, true
+}
+
+// untrack stops tracking the request from the other end once it is handled, it
+// returns false if they cancelled it meanwhile.
+func (c *Connection) untrack(id float64) bool {
+	c.access.Lock()
+	r, ok := c.inFlight[id]
+	delete(c.inFlight, id)
 	c.access.Unlock()
 
-	if len(sequence) > 0 {
-		onComplete = sequence[len(sequence)-1]
-		hasOnComplete = reflect.ValueOf(onComplete).Kind() == reflect.Func
+	if ok {
+		r.cancel()
+	}
+	return ok
+}
 
-		if hasOnComplete {
-			args = sequence[:len(sequence)-1]
-			c.requestCompleters[id] = onComplete
-		} else {
-			args = sequence
-			id = -1 // Never send response to us, please.
-		}
+// cancelRequest cancels the request from the other end, as they asked.
+func (c *Connection) cancelRequest(id float64) {
+	if !c.untrack(id) {
+		// Already handled, it's response is on the way.
+		logger().Println("Cancelled request is not in flight, ignoring.")
 	}
+}
 
-	go func() {
-		select {
-		case c.messageChan <- newRequestMessage(id, requestName, args):
-			// Sent message okay!
-			return
+// complete invokes the request completion function for the response message.
+func (c *Connection) complete(response *message) {
+	onComplete, ok := c.takeCompleter(response.id)
+	if !ok {
+		// Either the request was given up on, or the id is bogus.
+		logger().Println("Invalid request response, id not valid, ignoring.")
+		return
+	}
+	if internal, ok := onComplete.(completer); ok {
+		internal(response.args, response.err)
+		return
+	}
 
-		case <-c.DeathNotify():
-			// Connection closed; can't send.
+	args := response.args
+	if response.err != nil {
+		if !acceptsError(onComplete) {
+			logger().Println("Request failed:", response.err)
 			return
 		}
+		args = []interface{}{response.err}
+	}
+
+	valueArgs := interfaceToValueSlice(args)
+	fn := reflect.ValueOf(onComplete)
+
+	// Converts numeric arguments (which depend on the codec in use) to the
+	// parameter types; arguments which don't fit make the call below panic.
+	argumentsAssignable(fn.Type(), valueArgs)
+
+	defer func() {
+		if r := recover(); r != nil {
+			buf := new(bytes.Buffer)
+			fmt.Fprintf(buf, "Request handler onComplete panic:\n\n")
+			fmt.Fprintf(buf, "Expected type:\n")
+			fmt.Fprintf(buf, "\t")
+
+			fmt.Fprintf(buf, "func(")
+			for n := 0; n < len(valueArgs); n++ {
+				fmt.Fprint(buf, valueArgs[n].Type().String())
+				if n+1 < len(valueArgs) {
+					fmt.Fprintf(buf, ", ")
+				}
+			}
+			fmt.Fprintf(buf, ") ...")
+
+			fmt.Fprintf(buf, "\nFound type:\n\t")
+			fmt.Fprintf(buf, "%s\n\n", fn.Type().String())
+			fmt.Fprintf(buf, "%v\n\n", r)
+			fmt.Fprintf(buf, "%s", string(debug.Stack()))
+			logger().Println(buf.String())
+		}
 	}()
+	fn.Call(valueArgs)
 }
 
 // String returns an string representation of this Connection.
@@ -150,17 +379,53 @@ func (c *Connection) Address() string {
 
 // Method returns one of the predefined constant methods which represents the
 // method in use by this connection.
+//
+// An resumed connection may have been resumed using an different method than
+// the one it was created with.
 func (c *Connection) Method() Method {
-	// Note: no locking needed, never written to past creation time.
+	c.access.RLock()
+	defer c.access.RUnlock()
+
 	return c.method
 }
 
+// currentTransport returns the transport which currently carries this
+// connection, or nil if it has none (an resumable connection which lost it's
+// transport).
+func (c *Connection) currentTransport() Transport {
+	c.access.RLock()
+	defer c.access.RUnlock()
+
+	return c.transport
+}
+
 // Session returns the session object that is associated with this connection.
 func (c *Connection) Session() *Session {
 	// Note: no locking needed, never written to past createion time.
 	return c.session
 }
 
+// Identity returns the identity which the server's authenticator gave this
+// connection's client (see Server.SetAuthenticator), or nil if there is none.
+//
+// It is the identity of the connection's session, see Session.Identity().
+func (c *Connection) Identity() Identity {
+	return c.Session().Identity()
+}
+
+// Context returns an context which is cancelled once this connection dies, for
+// example:
+//
+//  go func() {
+//      <-c.Context().Done()
+//      fmt.Println("Connection died", c)
+//  }()
+//
+func (c *Connection) Context() context.Context {
+	// Note: no locking needed, never written to past creation time.
+	return c.ctx
+}
+
 // Dead tells weather this Connection is dead or not.
 func (c *Connection) Dead() bool {
 	c.access.RLock()
@@ -174,29 +439,60 @@ func (c *Connection) Dead() bool {
 //
 // If this connection is already dead, this function is no-op.
 func (c *Connection) Kill() {
-	if !c.Dead() {
-		// Signal death
-		c.deathWantedNotify <- true
+	c.kill(DisconnectKilled)
+}
+
+// kill kills this connection like Kill() does, for the specified reason (unless
+// it is already dying for another one).
+func (c *Connection) kill(reason DisconnectReason) {
+	c.setDisconnectReason(reason)
+
+	// Signal death, unless it is already dead.
+	select {
+	case c.deathWantedNotify <- true:
+	case <-c.died:
+	}
+
+	// Wait for completion
+	<-c.died
+}
+
+// setDisconnectReason sets the reason this connection dies for, unless one was
+// set already.
+func (c *Connection) setDisconnectReason(reason DisconnectReason) {
+	c.access.Lock()
+	defer c.access.Unlock()
 
-		// Wait for completion
-		<-c.deathCompletedNotify
+	if !c.hasReason {
+		c.reason = reason
+		c.hasReason = true
 	}
 }
 
+// disconnectReason returns the reason this connection died for.
+func (c *Connection) disconnectReason() DisconnectReason {
+	c.access.RLock()
+	defer c.access.RUnlock()
+
+	return c.reason
+}
+
 // DeathNotify returns an new channel on which true will be sent once this
 // connection is killed.
 //
 // An connection is considered killed once it's Kill() method has been called.
 //
-// If this connection is dead, then this function returns nil.
+// If this connection is dead, then this function returns nil. Context() is
+// usually more convenient, as it's Done channel is closed even if the
+// connection is already dead.
 func (c *Connection) DeathNotify() chan bool {
-	if c.Dead() {
-		return nil
-	}
-
 	c.access.Lock()
 	defer c.access.Unlock()
 
+	if c.dead {
+		return nil
+	}
+
 	ch := make(chan bool, 1)
 	c.deathNotifications = append(c.deathNotifications, ch)
 	return ch
@@ -213,7 +509,11 @@ func (c *Connection) waitForDeath() {
 		deathNotifications[i] = ch
 	}
 	c.deathNotifications = make([]chan bool, 0)
+
+	// Nobody is going to answer the outstanding requests anymore.
+	c.requestCompleters = make(map[float64]interface{})
 	c.access.Unlock()
+	c.ctxCancel()
 
 	for _, ch := range deathNotifications {
 		ch <- true
@@ -223,6 +523,7 @@ func (c *Connection) waitForDeath() {
 	logger().Println("DeathNotify():", c)
 	c.Session().removeConnection(c.key)
 	c.deathCompletedNotify <- true
+	close(c.died)
 }
 
 func (c *Connection) disconnectTimer(timeout, rate time.Duration) {
@@ -244,7 +545,11 @@ func (c *Connection) disconnectTimer(timeout, rate time.Duration) {
 					break
 
 				case <-time.After(rate):
-					c.performPing <- true
+					select {
+					case c.performPing <- true:
+					default:
+						// An ping is already pending.
+					}
 					select {
 					case <-deathNotify:
 						return
@@ -263,21 +568,33 @@ func (c *Connection) disconnectTimer(timeout, rate time.Duration) {
 }
 
 func (c *Connection) resetDisconnectTimer() {
-	c.disconnectTimerReset <- true
+	select {
+	case c.disconnectTimerReset <- true:
+	default:
+		// An reset is already pending.
+	}
 }
 
-func newConnection(address string, session *Session, key interface{}, method Method) *Connection {
+func newConnection(address string, session *Session, key interface{}, t Transport) *Connection {
 	c := new(Connection)
 	c.Store = NewStore()
 	c.key = key // Used for removal from session via removeConnection()
 	c.deathNotify = make(chan bool)
 	c.deathWantedNotify = make(chan bool)
 	c.deathCompletedNotify = make(chan bool)
-	c.messageChan = make(chan *message)
+	c.died = make(chan bool)
+	c.requests = make(chan *message, 16)
+	c.goingAway = make(chan chan bool)
+	c.flushNotify = make(chan chan bool)
 	c.requestCompleters = make(map[float64]interface{})
+	c.inFlight = make(map[float64]*inFlightRequest)
+	c.watching = make(map[StoreScope]map[string]bool)
+	c.ctx, c.ctxCancel = context.WithCancel(context.Background())
+	c.outgoing = newMessageQueue(c.ctx.Done())
 	c.session = session
 	c.address = address
-	c.method = method
+	c.transport = t
+	c.method = t.Method()
 
 	c.disconnectFromTimeout = make(chan bool, 1)
 	c.disconnectTimerReset = make(chan bool, 1)
@@ -0,0 +1,53 @@
+// Copyright 2012 Lightpoke. All rights reserved.
+// This source code is subject to the terms and
+// conditions defined in the "License.txt" file.
+
+package organics_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/sinni800/organics"
+	"github.com/sinni800/organics/client"
+)
+
+func TestRequestContext(t *testing.T) {
+	s := newServer(t)
+	for name, url := range s.urls() {
+		t.Run(name, func(t *testing.T) {
+			c, sc := s.dial(t, url)
+			c.Handle("Echo", func(v string, c *client.Connection) string {
+				return v + "!"
+			})
+			block := make(chan bool)
+			defer close(block)
+			c.Handle("Block", func(c *client.Connection) {
+				<-block
+			})
+
+			results, err := sc.RequestContext(context.Background(), "Echo", "hi")
+			if err != nil || len(results) != 1 || results[0] != "hi!" {
+				t.Fatalf("Echo = %v, %v; want [hi!]", results, err)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+			if _, err := sc.RequestContext(ctx, "Block"); err != context.DeadlineExceeded {
+				t.Fatalf("Block error = %v, want context.DeadlineExceeded", err)
+			}
+
+			errs := make(chan error, 1)
+			go func() {
+				_, err := sc.RequestContext(context.Background(), "Block")
+				errs <- err
+			}()
+			time.Sleep(50 * time.Millisecond)
+			sc.Kill()
+			if err := receive(t, errs); err != organics.ErrDead {
+				t.Fatalf("Block error after Kill = %v, want ErrDead", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,407 @@
+// Copyright 2012 Lightpoke. All rights reserved.
+// This source code is subject to the terms and
+// conditions defined in the "License.txt" file.
+
+package organics
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// ErrTransportClosed is returned by an Transport's Send and Receive methods
+// once the transport has been closed.
+var ErrTransportClosed = errors.New("organics: transport is closed")
+
+// Transport is the interface which an connection method implements in order to
+// carry encoded frames between the server and the other end of an connection.
+//
+// Send is only ever called by one goroutine at an time, as is Receive, but the
+// two may be called concurrently. Close may be called at any time, and must
+// cause any blocked Send or Receive call to return.
+type Transport interface {
+	// Send sends an single encoded frame to the other end of the connection.
+	// An empty frame is an ping, which the other end answers with an empty
+	// frame (or, for long-polling, by polling again).
+	Send(frame []byte) error
+
+	// Receive blocks until an single encoded frame is received from the other
+	// end of the connection, and returns it.
+	Receive() ([]byte, error)
+
+	// Close closes the transport.
+	Close() error
+
+	// Method returns the connection method this transport implements.
+	Method() Method
+}
+
+// queuingTransport is implemented by transports whose Send method queues the
+// frame, rather than sending it.
+type queuingTransport interface {
+	// waitSent blocks until every queued frame is sent, or until the transport
+	// is closed.
+	waitSent()
+}
+
+// ServeTransport serves an new connection over the transport, it blocks until
+// the connection is dead, or until the transport is lost.
+//
+// The request is the one which established the transport, it must carry the
+// session cookie of an existing session (see ensureSessionExists), which the
+// new connection will belong to. If the request asks to resume an connection
+// (see SetResumeWindow), then that connection is served instead.
+//
+// ErrShuttingDown is returned once the server is shutting down.
+func (s *Server) ServeTransport(t Transport, req *http.Request) error {
+	if s.ShuttingDown() {
+		return ErrShuttingDown
+	}
+
+	session := s.getSession(req)
+	if session == nil || session.Dead() {
+		return errors.New("organics: transport request has no valid session")
+	}
+
+	key, err := s.generateSessionKey()
+	if err != nil {
+		return err
+	}
+
+	connection, resumed := s.connectionFor(req, session, key, t)
+	s.serve(t, connection, resumed)
+	return nil
+}
+
+// serve moves messages between the transport and the connection, it blocks
+// until the connection is dead, or until the transport is lost.
+//
+// This is the transport-agnostic core which every connection method uses;
+// decoded messages are dispatched to request handlers (or request completion
+// functions) in the exact same way no matter which method is in use.
+//
+// An resumable connection outlives it's transport, in which case serve is
+// called again (with resumed set) for each transport which resumes it.
+func (s *Server) serve(t Transport, connection *Connection, resumed bool) {
+	if !resumed {
+		go s.serveWaitForDeath(connection)
+		connection.disconnectTimer(s.PingTimeout(), s.PingRate())
+
+		// Defined in workers.go
+		go s.serveRequests(connection)
+	}
+
+	// Frames carried by this transport are encoded using it's codec, an resumed connection may
+	// use an different one on each transport.
+	codec := transportCodec(t)
+
+	stop := make(chan bool)
+	writeDone := make(chan bool)
+	go func() {
+		s.serveWrite(t, codec, connection, stop)
+		close(writeDone)
+	}()
+
+	if !resumed {
+		s.doConnectHandler(connection)
+	}
+
+	err := s.serveRead(t, codec, connection)
+
+	// Stop writing, the transport is no good anymore.
+	close(stop)
+	t.Close()
+	<-writeDone
+
+	if connection.Dead() {
+		return
+	}
+	reason := DisconnectClosed
+	if err != ErrTransportClosed {
+		logger().Println(err, connection)
+		reason = DisconnectError
+		if err == errBufferOverflow {
+			reason = DisconnectBufferOverflow
+		}
+	}
+	if connection.resume != nil && reason != DisconnectBufferOverflow && err != errOutOfSequence {
+		// Wait for them to come back (see resume.go).
+		connection.detach(t, s.ResumeWindow())
+		return
+	}
+	connection.kill(reason)
+}
+
+// serveRead reads and dispatches frames from the transport, decoded using the
+// codec, until an error occurs.
+func (s *Server) serveRead(t Transport, codec Codec, connection *Connection) error {
+	for {
+		frame, err := t.Receive()
+		if err != nil {
+			return err
+		}
+
+		// Any frame means they're active, sense they sent it to us.
+		connection.resetDisconnectTimer()
+
+		// Frames of length zero are ping responses (A.K.A. Pong), nothing more
+		// than that.
+		if len(frame) == 0 {
+			continue
+		}
+
+		decoded := new(message)
+		err = decoded.decode(codec, frame)
+		if err != nil {
+			return err
+		}
+
+		if decoded.isAck || decoded.seq > 0 {
+			if connection.resume == nil {
+				return errors.New("Error decoding message; connection is not resumable")
+			}
+			if decoded.isAck {
+				connection.resume.acknowledge(int64(decoded.seq))
+				continue
+			}
+			next, err := connection.resume.receive(int64(decoded.seq))
+			if err != nil {
+				return err
+			}
+			if !next {
+				// Sent again after an resume, but we already have it.
+				continue
+			}
+		}
+
+		if decoded.isWatch {
+			s.watch(connection, decoded.storeKey)
+			continue
+		}
+		if decoded.isUnwatch {
+			connection.unwatch(decoded.storeKey)
+			continue
+		}
+
+		if decoded.isCancel {
+			// They no longer want the response to one of their requests.
+			connection.cancelRequest(decoded.id)
+			continue
+		}
+
+		if !decoded.isRequest {
+			// It's an response to one of our requests
+			connection.complete(decoded)
+			continue
+		}
+
+		if !validRequestName(decoded.requestName) {
+			// No request handler can be found (or registered) for it.
+			logger().Printf("bad request | invalid request name \"%v\"\n", decoded.requestName)
+			if decoded.id != -1 {
+				connection.outgoing.push(newErrorMessage(decoded.id, &Error{
+					Code:    CodeNoHandler,
+					Message: fmt.Sprintf("no handler for request %v", decoded.requestName),
+				}))
+			}
+			continue
+		}
+
+		connection.track(decoded)
+		select {
+		case connection.requests <- decoded:
+		case <-connection.ctx.Done():
+			return ErrTransportClosed
+		}
+	}
+}
+
+// handleRequest invokes the request handler for the request message, and sends
+// the response back, if the other end wants one.
+//
+// Once the server is shutting down, requests are refused instead. Requests
+// which the other end cancelled are not handled (or, if they cancelled it
+// while it was being handled, are not responded to).
+func (s *Server) handleRequest(request *message, connection *Connection) {
+	defer s.requestDone()
+
+	ctx, ok := connection.requestContext(request)
+	if !ok {
+		return
+	}
+
+	var response *message
+	if s.ShuttingDown() {
+		response = newErrorMessage(request.id, &Error{
+			Code:    CodeShuttingDown,
+			Message: "server is shutting down",
+		})
+	} else {
+		// The handler may send partial results (see Progress) until it
+		// returns.
+		ctx, progress := withProgress(ctx, request, connection)
+		response = s.callHandler(ctx, request, connection)
+		progress.finish()
+	}
+	if request.id == -1 {
+		// Never send response to them.
+		return
+	}
+	if !connection.untrack(request.id) {
+		// They cancelled it meanwhile.
+		return
+	}
+
+	connection.outgoing.push(response)
+}
+
+func (s *Server) serveWrite(t Transport, codec Codec, connection *Connection, stop chan bool) {
+	r := connection.resume
+
+	var space, ackWanted chan bool
+	var flushing []chan bool
+	if r != nil {
+		// Tell them who they are, and send them whatever they missed.
+		for _, frame := range r.replay(connection.key) {
+			encoded, err := codec.Marshal(frame)
+			if err != nil {
+				logger().Println("Error encoding message;", err)
+				connection.Kill()
+				return
+			}
+			if t.Send(encoded) != nil {
+				t.Close()
+				return
+			}
+		}
+		space = r.space
+		ackWanted = r.ackWanted
+	}
+
+	for {
+		var frame []interface{}
+		var wentAway chan bool
+
+		if len(flushing) > 0 && connection.outgoing.len() == 0 {
+			// Everything queued before they asked was sent (the queue is in
+			// order), unless the transport queues it (see longpoll.go).
+			if q, ok := t.(queuingTransport); ok {
+				q.waitSent()
+			}
+			for _, flushed := range flushing {
+				close(flushed)
+			}
+			flushing = nil
+		}
+
+		// Nothing more is sent once the resume buffer is full, until they
+		// acknowledge some of it.
+		messages := connection.outgoing.ready
+		if r != nil && r.full(s.ResumeBufferSize()) {
+			messages = nil
+		}
+
+		select {
+		case <-stop:
+			return
+
+		case <-connection.ctx.Done():
+			return
+
+		case <-space:
+			continue
+
+		case <-ackWanted:
+			frame = r.ack()
+			if frame == nil {
+				continue
+			}
+
+		case <-connection.performPing:
+			// An empty (nil) frame is an ping.
+
+		case wentAway = <-connection.goingAway:
+			frame = []interface{}{ftGoingAway}
+
+		case flushed := <-connection.flushNotify:
+			// Told once everything queued so far was sent, see above.
+			flushing = append(flushing, flushed)
+			continue
+
+		case <-messages:
+			msg := connection.outgoing.pop()
+			if msg == nil {
+				continue
+			}
+			frame = msg.array()
+			if r != nil {
+				frame = r.sequence(msg)
+			}
+		}
+
+		var encoded []byte
+		if frame != nil {
+			var err error
+			encoded, err = codec.Marshal(frame)
+			if err != nil {
+				logger().Println("Error encoding message;", err)
+				connection.Kill()
+				return
+			}
+		}
+
+		err := t.Send(encoded)
+		if wentAway != nil {
+			close(wentAway)
+		}
+		if err != nil {
+			if err != ErrTransportClosed && !connection.Dead() {
+				logger().Println("Error writing", err, connection)
+			}
+			t.Close()
+			return
+		}
+
+		if frame == nil && r != nil {
+			// Acknowledge what we got along with each ping, so they don't have
+			// to wait for ackInterval more messages.
+			select {
+			case ackWanted <- true:
+			default:
+			}
+		}
+	}
+}
+
+func (s *Server) serveWaitForDeath(connection *Connection) {
+	// Wait untill someone wants this connection dead
+	for waiting := true; waiting; {
+		select {
+		case <-connection.deathWantedNotify:
+			waiting = false
+
+		case <-connection.disconnectFromTimeout:
+			if connection.resume == nil {
+				connection.setDisconnectReason(DisconnectPingTimeout)
+				waiting = false
+				break
+			}
+			// The transport is lost, but the connection may be resumed.
+			if t := connection.currentTransport(); t != nil {
+				t.Close()
+			}
+		}
+	}
+
+	// Inform everyone it's dead
+	connection.deathNotify <- true
+
+	// Wait for above to complete
+	<-connection.deathCompletedNotify
+
+	// Close the transport now
+	if t := connection.currentTransport(); t != nil {
+		t.Close()
+	}
+}
@@ -0,0 +1,116 @@
+// Copyright 2012 Lightpoke. All rights reserved.
+// This source code is subject to the terms and
+// conditions defined in the "License.txt" file.
+
+package organics_test
+
+import (
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/sinni800/organics"
+)
+
+// pipeTransport is an in-memory organics.Transport, the test is the other end.
+type pipeTransport struct {
+	in, out   chan []byte
+	closeOnce sync.Once
+	closed    chan bool
+}
+
+func newPipeTransport() *pipeTransport {
+	return &pipeTransport{
+		in:     make(chan []byte, 16),
+		out:    make(chan []byte, 16),
+		closed: make(chan bool),
+	}
+}
+
+func (p *pipeTransport) Send(frame []byte) error {
+	select {
+	case p.out <- frame:
+		return nil
+	case <-p.closed:
+		return organics.ErrTransportClosed
+	}
+}
+
+func (p *pipeTransport) Receive() ([]byte, error) {
+	select {
+	case frame := <-p.in:
+		return frame, nil
+	case <-p.closed:
+		return nil, organics.ErrTransportClosed
+	}
+}
+
+func (p *pipeTransport) Close() error {
+	p.closeOnce.Do(func() { close(p.closed) })
+	return nil
+}
+
+func (p *pipeTransport) Method() organics.Method {
+	return organics.WebSocket
+}
+
+// establish makes an long-polling establish request, and returns an request
+// which carries the session cookie it's response sets.
+func (ts *testServer) establish(t *testing.T) *http.Request {
+	t.Helper()
+	req, err := http.NewRequest("POST", ts.http.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-Organics-Req", "lpec")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	<-ts.connected
+
+	req, err = http.NewRequest("GET", ts.http.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, cookie := range resp.Cookies() {
+		req.AddCookie(cookie)
+	}
+	return req
+}
+
+func TestServeTransport(t *testing.T) {
+	s := newServer(t)
+	s.Handle("Echo", func(v string, c *organics.Connection) string {
+		return v
+	})
+
+	// Without an session, the transport is refused.
+	p := newPipeTransport()
+	req, _ := http.NewRequest("GET", s.http.URL, nil)
+	if err := s.ServeTransport(p, req); err == nil {
+		t.Fatal("ServeTransport() without an session did not fail")
+	}
+
+	req = s.establish(t)
+	served := make(chan error, 1)
+	go func() {
+		served <- s.ServeTransport(p, req)
+	}()
+	c := receive(t, s.connected)
+	if c.Method() != organics.WebSocket {
+		t.Fatalf("Method() = %v, want WebSocket", c.Method())
+	}
+
+	p.in <- []byte(`[1,"Echo",["hi"]]`)
+	if frame := string(receive(t, p.out)); frame != `[1,["hi"]]` {
+		t.Fatalf("got frame %s, want [1,[\"hi\"]]", frame)
+	}
+
+	p.Close()
+	if err := receive(t, served); err != nil {
+		t.Fatalf("ServeTransport() = %v, want nil", err)
+	}
+	eventually(t, "the connection is dead", c.Dead)
+}
@@ -0,0 +1,73 @@
+// Copyright 2012 Lightpoke. All rights reserved.
+// This source code is subject to the terms and
+// conditions defined in the "License.txt" file.
+
+package organics
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// Error codes which Organics itself uses in error responses. Applications may
+// use any other code for their own errors.
+const (
+	// The request handler returned an non-nil error which was not an *Error.
+	CodeHandlerError = 1
+
+	// The request handler panicked.
+	CodeHandlerPanic = 2
+
+	// There is no request handler for the request name.
+	CodeNoHandler = 3
+
+	// The request arguments did not match the request handler's parameters.
+	CodeBadArguments = 4
+
+	// The server is shutting down, and no longer handles requests.
+	CodeShuttingDown = 5
+
+	// The request was dropped before it was sent, as the connection's outgoing
+	// queue was full (see Server.SetQueuePolicy). Request completion functions
+	// are given it, the other end never sees it.
+	CodeQueueFull = 6
+)
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+// Error is an error that is sent to the other end of an connection in response
+// to an failed request.
+//
+// An request handler may return an *Error as it's trailing error return value
+// in order to control the code and data which the other end receives, any
+// other non-nil error is sent using the CodeHandlerError code and it's Error()
+// string as the message.
+//
+// In JavaScript, the request's completion function is given an Organics.Error
+// object as it's only argument, with the Code, Message and Data properties.
+type Error struct {
+	Code    int         `json:"code"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+// Error implements the error interface.
+func (e *Error) Error() string {
+	return fmt.Sprintf("organics: error %d: %s", e.Code, e.Message)
+}
+
+// toError converts any error into an *Error suitable for sending.
+func toError(err error) *Error {
+	if e, ok := err.(*Error); ok {
+		return e
+	}
+	return &Error{Code: CodeHandlerError, Message: err.Error()}
+}
+
+// acceptsError tells weather the request completion function onComplete can be
+// given an *Error as it's only argument, in which case it is invoked with the
+// error when the request fails.
+func acceptsError(onComplete interface{}) bool {
+	fnType := reflect.TypeOf(onComplete)
+	return fnType.NumIn() == 1 && reflect.TypeOf((*Error)(nil)).AssignableTo(fnType.In(0))
+}
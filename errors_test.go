@@ -0,0 +1,65 @@
+// Copyright 2012 Lightpoke. All rights reserved.
+// This source code is subject to the terms and
+// conditions defined in the "License.txt" file.
+
+package organics_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/sinni800/organics"
+)
+
+func TestErrorResponses(t *testing.T) {
+	s := newServer(t)
+	s.Handle("Fail", func(c *organics.Connection) (int, error) {
+		return 0, &organics.Error{Code: 42, Message: "nope", Data: "details"}
+	})
+	s.Handle("PlainFail", func(c *organics.Connection) (int, error) {
+		return 0, errors.New("plain")
+	})
+	s.Handle("Panic", func(c *organics.Connection) int {
+		panic("oops")
+	})
+	s.Handle("OK", func(c *organics.Connection) (int, error) {
+		return 7, nil
+	})
+
+	tests := []struct {
+		name    string
+		args    []interface{}
+		code    int
+		message string
+	}{
+		{"Fail", nil, 42, "nope"},
+		{"PlainFail", nil, organics.CodeHandlerError, "plain"},
+		{"Panic", nil, organics.CodeHandlerPanic, "request handler panic"},
+		{"NoSuchRequest", nil, organics.CodeNoHandler, "no handler for request NoSuchRequest"},
+		{"OK", []interface{}{1, 2}, organics.CodeBadArguments, "bad request arguments for request OK"},
+	}
+	for name, url := range s.urls() {
+		t.Run(name, func(t *testing.T) {
+			c, _ := s.dial(t, url)
+			for _, test := range tests {
+				failed := make(chan *organics.Error, 1)
+				c.Request(test.name, append(test.args, func(err *organics.Error) {
+					failed <- err
+				})...)
+				err := receive(t, failed)
+				if err.Code != test.code || err.Message != test.message {
+					t.Errorf("%s got %v, want code %d %q", test.name, err, test.code, test.message)
+				}
+			}
+
+			// Succeeding requests are unaffected.
+			result := make(chan float64, 1)
+			c.Request("OK", func(v float64) {
+				result <- v
+			})
+			if v := receive(t, result); v != 7 {
+				t.Fatalf("OK = %v, want 7", v)
+			}
+		})
+	}
+}
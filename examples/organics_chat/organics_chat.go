@@ -23,6 +23,7 @@ import (
 
 var (
 	server               *organics.Server
+	lobby                *organics.Room
 	recentMessages       []string
 	recentMessagesAccess sync.RWMutex
 )
@@ -41,26 +42,24 @@ func sendMessageToAll(msg string) {
 	recentMessagesAccess.Unlock()
 
 	log.Println(msg)
-	for _, conn := range server.Connections() {
-		conn.Request("DisplayMessage", msg)
-	}
+	lobby.Request("DisplayMessage", msg)
 }
 
 func doConnect(connection *organics.Connection) {
+	// Everyone is in the lobby, they leave it automatically once they disconnect.
+	lobby.Join(connection)
+
 	// Send them the most recent 25 messages
 	recentMessagesAccess.RLock()
 	for _, msg := range recentMessages {
 		connection.Request("DisplayMessage", msg)
 	}
 	recentMessagesAccess.RUnlock()
+}
 
-	// Wait untill they disconnect
-	go func() {
-		<-connection.DeathNotify()
-
-		username := connection.Get("username", "").(string)
-		sendMessageToAll(username + " has left.")
-	}()
+func doDisconnect(reason organics.DisconnectReason, connection *organics.Connection) {
+	username := connection.Get("username", "").(string)
+	sendMessageToAll(username + " has left.")
 }
 
 func doMessage(msg string, connection *organics.Connection) {
@@ -70,9 +69,7 @@ func doMessage(msg string, connection *organics.Connection) {
 
 	if username == "admin" {
 		log.Println(msg)
-		for _, conn := range server.Connections() {
-			conn.Request("DisplayMessage", "("+username+"): "+msg)
-		}
+		lobby.Request("DisplayMessage", "("+username+"): "+msg)
 		return
 	}
 	sendMessageToAll("(" + username + "): " + msg)
@@ -116,7 +113,11 @@ func main() {
 	//
 	// server.SetOriginAccess("www.twitter.com", true)
 
+	// The room everyone chats in.
+	lobby = server.Room("lobby")
+
 	server.Handle(organics.Connect, doConnect)
+	server.Handle(organics.Disconnect, doDisconnect)
 	server.Handle("SetUsername", doSetUsername)
 	server.Handle("Message", doMessage)
 
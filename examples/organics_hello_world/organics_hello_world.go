@@ -33,8 +33,6 @@ func init() {
 func HandleConnect(connection *organics.Connection) {
 	// This timer will be used to display the connection's time on the browser.
 	go func() {
-		deathNotify := connection.DeathNotify()
-
 		// Initially, the page will load, so we want to display the time right
 		// away.
 		intervalTime := 0 * time.Second
@@ -58,7 +56,7 @@ func HandleConnect(connection *organics.Connection) {
 				// Give the browser an request.
 				connection.Request("SetConnectionTime", t)
 
-			case <-deathNotify:
+			case <-connection.Context().Done():
 				// If they disconnect, we'll basically 'pause' this timer by
 				// killing the goroutine, next time they connect we'll start an
 				// new timer.
@@ -99,7 +97,7 @@ func HandleConnect(connection *organics.Connection) {
 				// session.
 				session.Request("SetSessionTime", t)
 
-			case <-connection.DeathNotify():
+			case <-connection.Context().Done():
 				// If they disconnect, we'll basically 'pause' this timer by
 				// killing the goroutine, next time they connect we'll start an
 				// new timer.
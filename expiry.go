@@ -0,0 +1,224 @@
+// Copyright 2012 Lightpoke. All rights reserved.
+// This source code is subject to the terms and
+// conditions defined in the "License.txt" file.
+
+package organics
+
+import (
+	"time"
+)
+
+// SessionExpirer is the interface that an session provider may implement in
+// addition to SessionProvider, in order to expire the data of sessions which
+// are no longer used. Without it, the data of every session is kept forever.
+//
+// Like the SessionProvider methods, it's methods must be safe to call from
+// multiple goroutines.
+type SessionExpirer interface {
+	// Delete should delete the data of the session, if there is any.
+	Delete(sessionKey string) error
+
+	// Expire specifies when the data of the session expires, see the Expiry
+	// type for the meaning of the idle and absolute times. It may be called
+	// before any data was saved for the session, in which case the times must
+	// be kept for when it is.
+	//
+	// Once expired, Load should return nil for the session.
+	Expire(sessionKey string, idle, absolute time.Time) error
+
+	// Expiry should return the expiry of the session, as left by the calls to
+	// Expire so far, or an zero Expiry if there were none.
+	Expiry(sessionKey string) (Expiry, error)
+
+	// Sweep should delete the data of each session which has expired at the
+	// specified time, and return their keys.
+	Sweep(now time.Time) ([]string, error)
+}
+
+// Expiry is when the data of an session expires, it is provided for the use of
+// SessionExpirer implementations.
+//
+// The data expires at the idle time, which is moved forward while the session
+// is used, or at the absolute time, which never changes, whichever comes first.
+// An zero time means the data never expires for that reason.
+type Expiry struct {
+	Idle, Absolute time.Time
+}
+
+// Update returns the expiry after an call to SessionExpirer.Expire with the
+// specified idle and absolute times. The idle time replaces the current one,
+// while the absolute time is only set once: an later (or zero) absolute time
+// never replaces an earlier one.
+func (e Expiry) Update(idle, absolute time.Time) Expiry {
+	e.Idle = idle
+	if e.Absolute.IsZero() || (!absolute.IsZero() && absolute.Before(e.Absolute)) {
+		e.Absolute = absolute
+	}
+	return e
+}
+
+// Expired tells weather the data has expired at the specified time.
+func (e Expiry) Expired(now time.Time) bool {
+	if !e.Idle.IsZero() && !now.Before(e.Idle) {
+		return true
+	}
+	return !e.Absolute.IsZero() && !now.Before(e.Absolute)
+}
+
+// expirer returns the server's session provider as an SessionExpirer, or false
+// if it is not one.
+func (s *Server) expirer() (SessionExpirer, bool) {
+	se, ok := s.Provider().(SessionExpirer)
+	return se, ok
+}
+
+// expireSession tells the session provider when the data of the session
+// expires, from now on. The absolute time is only given for new sessions.
+func (s *Server) expireSession(session *Session, isNew bool) {
+	se, ok := s.expirer()
+	if !ok {
+		return
+	}
+	var absoluteTime time.Time
+	if maxAge := s.SessionMaxAge(); isNew && maxAge > 0 {
+		absoluteTime = time.Now().Add(maxAge)
+	}
+	s.setExpiry(se, session, absoluteTime)
+}
+
+// moveExpiry gives the session, whose key was regenerated (see
+// Session.Regenerate), the absolute time of it's old key. Otherwise an session
+// could be kept alive past the session max age by regenerating it over and
+// over again.
+func (s *Server) moveExpiry(session *Session, oldKey string) {
+	se, ok := s.expirer()
+	if !ok {
+		return
+	}
+	expiry, err := se.Expiry(oldKey)
+	if err != nil {
+		logger().Println("Failed to expire session:", err)
+	}
+	s.setExpiry(se, session, expiry.Absolute)
+}
+
+// setExpiry tells the session provider that the data of the session expires
+// once the session idle timeout elapses from now on, or at the absolute time.
+func (s *Server) setExpiry(se SessionExpirer, session *Session, absoluteTime time.Time) {
+	var idleTime time.Time
+	if idle := s.SessionIdleTimeout(); idle > 0 {
+		idleTime = time.Now().Add(idle)
+	}
+	if idleTime.IsZero() && absoluteTime.IsZero() {
+		return
+	}
+	if err := se.Expire(session.sessionKey(), idleTime, absoluteTime); err != nil {
+		logger().Println("Failed to expire session:", err)
+		return
+	}
+
+	session.access.Lock()
+	session.idleTime = idleTime
+	session.access.Unlock()
+}
+
+// idleSoon tells weather the idle time last given to the session provider for
+// this session passes before the specified time.
+func (s *Session) idleSoon(t time.Time) bool {
+	s.access.RLock()
+	defer s.access.RUnlock()
+
+	return !s.idleTime.IsZero() && s.idleTime.Before(t)
+}
+
+// startSweeping starts the sweeper, unless it is running already or there is
+// nothing to sweep: session data never expires, the session provider is not an
+// SessionExpirer or the server has shut down. s.access must be held.
+func (s *Server) startSweeping() {
+	if s.sweeping || (s.sessionIdleTimeout <= 0 && s.sessionMaxAge <= 0) || s.ctx.Err() != nil {
+		return
+	}
+	if _, ok := s.sessionProvider.(SessionExpirer); !ok {
+		return
+	}
+	s.sweeping = true
+	go s.sweep()
+}
+
+// keepSweeping tells weather the sweeper should keep running, that is unless
+// session data no longer expires.
+func (s *Server) keepSweeping() bool {
+	s.access.Lock()
+	defer s.access.Unlock()
+
+	if s.sessionIdleTimeout <= 0 && s.sessionMaxAge <= 0 {
+		s.sweeping = false
+	}
+	return s.sweeping
+}
+
+// sweep periodically moves the idle time of each live session forward, as
+// they are in use, and sweeps expired sessions from the session provider,
+// until the server has shut down or session data no longer expires. It is
+// started by startSweeping.
+func (s *Server) sweep() {
+	se, _ := s.expirer()
+	for {
+		select {
+		case <-time.After(s.SweepInterval()):
+		case <-s.sweepReset:
+			// The interval changed, wait for the new one.
+			continue
+		case <-s.ctx.Done():
+			return
+		}
+
+		if !s.keepSweeping() {
+			return
+		}
+		// Live sessions are in use, their idle time is moved forward once it
+		// would pass before the sweep after the next one (rather than each
+		// time, as that means an write per session for most providers).
+		soon := time.Now().Add(2 * s.SweepInterval())
+		for _, session := range s.cachedSessions() {
+			if session.idleSoon(soon) {
+				s.expireSession(session, false)
+			}
+		}
+
+		keys, err := se.Sweep(time.Now())
+		if err != nil {
+			logger().Println("Failed to sweep sessions:", err)
+		}
+		for _, key := range keys {
+			// An live session may only expire due to it's absolute time.
+			if session, ok := s.cachedSession(key); ok {
+				go session.expire()
+			}
+		}
+		if len(keys) > 0 {
+			logger().Printf("Swept %d expired sessions\n", len(keys))
+		}
+	}
+}
+
+// expire kills this session as it's data has expired, which is not saved
+// anymore, and not restored.
+func (s *Session) expire() {
+	s.access.Lock()
+	s.expired = true
+	s.access.Unlock()
+
+	for _, c := range s.Connections() {
+		c.kill(DisconnectExpired)
+	}
+	s.Kill()
+}
+
+// isExpired tells weather this session was killed because it's data expired.
+func (s *Session) isExpired() bool {
+	s.access.RLock()
+	defer s.access.RUnlock()
+
+	return s.expired
+}
@@ -0,0 +1,222 @@
+// Copyright 2012 Lightpoke. All rights reserved.
+// This source code is subject to the terms and
+// conditions defined in the "License.txt" file.
+
+package organics_test
+
+import (
+	"net/http"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sinni800/organics"
+	"github.com/sinni800/organics/provider/filesystem"
+	"github.com/sinni800/organics/provider/memory"
+)
+
+// sweepingProvider is an memory session provider which counts the calls to
+// Expire and Sweep.
+type sweepingProvider struct {
+	organics.SessionProvider
+	expires, sweeps int32
+}
+
+func (p *sweepingProvider) Delete(sessionKey string) error {
+	return p.SessionProvider.(organics.SessionExpirer).Delete(sessionKey)
+}
+
+func (p *sweepingProvider) Expire(sessionKey string, idle, absolute time.Time) error {
+	atomic.AddInt32(&p.expires, 1)
+	return p.SessionProvider.(organics.SessionExpirer).Expire(sessionKey, idle, absolute)
+}
+
+func (p *sweepingProvider) Expiry(sessionKey string) (organics.Expiry, error) {
+	return p.SessionProvider.(organics.SessionExpirer).Expiry(sessionKey)
+}
+
+func (p *sweepingProvider) Sweep(now time.Time) ([]string, error) {
+	atomic.AddInt32(&p.sweeps, 1)
+	return p.SessionProvider.(organics.SessionExpirer).Sweep(now)
+}
+
+func TestSessionIdleTimeout(t *testing.T) {
+	provider := memory.Provider()
+	s := newProviderServer(t, provider)
+	s.SetSessionIdleTimeout(150 * time.Millisecond)
+	s.SetSweepInterval(20 * time.Millisecond)
+
+	resp := s.establishWith(t, nil)
+	key := resp.Cookies()[0].Value
+	c := receive(t, s.connected)
+	c.Session().Set("cart", 1)
+
+	// An session in use never goes idle.
+	time.Sleep(400 * time.Millisecond)
+	if c.Dead() || provider.Load(key) == nil {
+		t.Fatal("the session expired while in use")
+	}
+
+	c.Kill()
+	eventually(t, "the session is dead", c.Session().Dead)
+	if provider.Load(key) == nil {
+		t.Fatal("the session expired as soon as it died")
+	}
+	eventually(t, "the session is swept", func() bool {
+		return provider.Load(key) == nil
+	})
+	s.establishWith(t, http.Header{"Cookie": {"organics-session=" + key}})
+	if receive(t, s.connected).Session().Has("cart") {
+		t.Fatal("an expired session was restored")
+	}
+}
+
+func TestSessionMaxAge(t *testing.T) {
+	provider := memory.Provider()
+	s := newProviderServer(t, provider)
+	s.SetSessionMaxAge(200 * time.Millisecond)
+	s.SetSweepInterval(20 * time.Millisecond)
+	reasons := make(chan organics.DisconnectReason, 1)
+	s.Handle(organics.Disconnect, func(reason organics.DisconnectReason, c *organics.Connection) {
+		reasons <- reason
+	})
+
+	resp := s.establishWith(t, nil)
+	key := resp.Cookies()[0].Value
+	c := receive(t, s.connected)
+	c.Session().Set("cart", 1)
+
+	// An session in use expires all the same.
+	if reason := receive(t, reasons); reason != organics.DisconnectExpired {
+		t.Fatalf("disconnected for reason %v, want DisconnectExpired", reason)
+	}
+	eventually(t, "the session is dead", c.Session().Dead)
+	eventually(t, "the session data is deleted", func() bool {
+		return provider.Load(key) == nil
+	})
+}
+
+func TestSweeper(t *testing.T) {
+	provider := &sweepingProvider{SessionProvider: memory.Provider()}
+	s := newProviderServer(t, provider)
+	s.SetSweepInterval(10 * time.Millisecond)
+	sweeps := func() int32 {
+		return atomic.LoadInt32(&provider.sweeps)
+	}
+
+	// Nothing expires, so nothing is swept.
+	time.Sleep(100 * time.Millisecond)
+	if n := sweeps(); n != 0 {
+		t.Fatalf("swept %d times without expiry", n)
+	}
+
+	s.SetSessionIdleTimeout(time.Minute)
+	eventually(t, "the sweeper runs", func() bool {
+		return sweeps() > 0
+	})
+
+	// Once expiry is turned off again, the sweeper stops.
+	s.SetSessionIdleTimeout(0)
+	time.Sleep(50 * time.Millisecond)
+	n := sweeps()
+	time.Sleep(100 * time.Millisecond)
+	if sweeps() != n {
+		t.Fatal("the sweeper kept running without expiry")
+	}
+}
+
+func TestSweeperWrites(t *testing.T) {
+	provider := &sweepingProvider{SessionProvider: memory.Provider()}
+	s := newProviderServer(t, provider)
+	s.SetSessionIdleTimeout(time.Minute)
+	s.SetSweepInterval(10 * time.Millisecond)
+	s.rawWebSocket(t)
+
+	// The idle time of an live session is far off, there is no need to move
+	// it forward on each sweep.
+	eventually(t, "the sweeper runs", func() bool {
+		return atomic.LoadInt32(&provider.sweeps) > 10
+	})
+	if n := atomic.LoadInt32(&provider.expires); n != 1 {
+		t.Fatalf("Expire() called %d times, want once for the new session", n)
+	}
+}
+
+func TestRegenerateMaxAge(t *testing.T) {
+	s := newServer(t)
+	s.SetSessionMaxAge(300 * time.Millisecond)
+	s.SetSweepInterval(20 * time.Millisecond)
+	reasons := make(chan organics.DisconnectReason, 1)
+	s.Handle(organics.Disconnect, func(reason organics.DisconnectReason, c *organics.Connection) {
+		reasons <- reason
+	})
+	_, c := s.rawWebSocket(t)
+
+	// Regenerating an session does not make it any younger.
+	deadline := time.After(2 * time.Second)
+	for {
+		select {
+		case reason := <-reasons:
+			if reason != organics.DisconnectExpired {
+				t.Fatalf("disconnected for reason %v, want DisconnectExpired", reason)
+			}
+			return
+		case <-deadline:
+			t.Fatal("regenerating the session kept it alive past it's max age")
+		case <-time.After(50 * time.Millisecond):
+			c.Session().Regenerate()
+		}
+	}
+}
+
+func TestFilesystemExpirer(t *testing.T) {
+	// An absolute directory, which is created as needed.
+	dir := filepath.Join(t.TempDir(), "sessions")
+	provider, err := filesystem.Provider(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expirer := provider.(organics.SessionExpirer)
+	store := organics.NewStore()
+	store.Set("cart", "apples")
+	key := "abc+/=defghijklmnopqrstuvwxyz0123456789"
+	if err := provider.Save(key, "cart", store); err != nil {
+		t.Fatal(err)
+	}
+
+	// An later absolute time never replaces an earlier one.
+	now := time.Now()
+	expirer.Expire(key, now.Add(time.Hour), now.Add(2*time.Hour))
+	expirer.Expire(key, now.Add(time.Hour), now.Add(3*time.Hour))
+
+	// The expiry is read back from disk.
+	reopened, err := filesystem.Provider(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reopened.Load(key) == nil {
+		t.Fatal("the session was not loaded before it expired")
+	}
+	swept, err := reopened.(organics.SessionExpirer).Sweep(now.Add(90 * time.Minute))
+	if err != nil || len(swept) != 1 || swept[0] != key {
+		t.Fatalf("Sweep() = %v, %v, want the session", swept, err)
+	}
+	if reopened.Load(key) != nil {
+		t.Fatal("an swept session was loaded")
+	}
+	if matches, _ := filepath.Glob(filepath.Join(dir, "*")); len(matches) != 0 {
+		t.Fatalf("files %v were left after sweeping", matches)
+	}
+
+	// Nor is the absolute time forgotten once the idle time moves.
+	provider.Save("other", "cart", store)
+	expirer.Expire("other", time.Time{}, now.Add(time.Minute))
+	expirer.Expire("other", now.Add(time.Hour), time.Time{})
+	if swept, _ := expirer.Sweep(now.Add(2 * time.Minute)); len(swept) != 1 {
+		t.Fatalf("Sweep() = %v, want the other session", swept)
+	}
+	if err := expirer.Delete("unknown"); err != nil {
+		t.Fatalf("Delete() of an unknown session = %v", err)
+	}
+}
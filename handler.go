@@ -0,0 +1,316 @@
+// Copyright 2012 Lightpoke. All rights reserved.
+// This source code is subject to the terms and
+// conditions defined in the "License.txt" file.
+
+package organics
+
+import (
+	"bytes"
+	"context"
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"runtime/debug"
+)
+
+var (
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	contextType       = reflect.TypeOf((*context.Context)(nil)).Elem()
+)
+
+// typedHandler is the form in which request handlers registered through
+// HandleFunc() are stored in the server's request handlers map. It decodes
+// the raw JSON arguments itself.
+type typedHandler func(ctx context.Context, c *Connection, args []interface{}) ([]interface{}, error)
+
+// HandleFunc defines that when an request with the specified requestName comes
+// in, that the handler function will be invoked in order to handle the
+// request.
+//
+// Unlike Server.Handle(), the handler's signature is checked at compile time.
+// The request is expected to carry (at most) a single argument, which is
+// decoded from JSON into an value of type Req, and the handler's response is
+// sent back as the single response argument. For example:
+//
+//  type Point struct{ X, Y int }
+//
+//  organics.HandleFunc(s, "Move", func(ctx context.Context, c *organics.Connection, p Point) (Point, error) {
+//      return Point{p.X + 1, p.Y + 1}, nil
+//  })
+//
+// The context is the request's, see Server.Handle().
+//
+// If handler is nil, then any existing handler for requestName is removed.
+//
+// This function panics if requestName is not comparable (see Server.Handle()),
+// if Req cannot be decoded from JSON, or if Resp cannot be encoded into JSON.
+func HandleFunc[Req, Resp any](s *Server, requestName interface{}, handler func(ctx context.Context, c *Connection, req Req) (Resp, error)) {
+	checkRequestName(requestName)
+	if requestName == Connect || requestName == Disconnect || sessionHook(requestName) {
+		panic("HandleFunc() cannot be used for special message handlers! Use Handle() instead.")
+	}
+
+	if handler == nil {
+		s.access.Lock()
+		defer s.access.Unlock()
+
+		delete(s.requestHandlers, requestName)
+		return
+	}
+
+	reqType := reflect.TypeOf((*Req)(nil)).Elem()
+	if !jsonCompatible(reqType) {
+		panic(fmt.Sprintf("HandleFunc() request type %s cannot be decoded from JSON!", reqType))
+	}
+	respType := reflect.TypeOf((*Resp)(nil)).Elem()
+	if !jsonCompatible(respType) {
+		panic(fmt.Sprintf("HandleFunc() response type %s cannot be encoded into JSON!", respType))
+	}
+
+	typed := typedHandler(func(ctx context.Context, c *Connection, args []interface{}) ([]interface{}, error) {
+		var req Req
+		if err := decodeArgument(args, &req); err != nil {
+			return nil, err
+		}
+
+		resp, err := handler(ctx, c, req)
+		if err != nil {
+			return nil, err
+		}
+		return []interface{}{resp}, nil
+	})
+
+	s.access.Lock()
+	defer s.access.Unlock()
+
+	s.requestHandlers[requestName] = typed
+}
+
+// decodeArgument decodes the single JSON argument of an request into the
+// value pointed to by v. No arguments at all leaves v untouched.
+func decodeArgument(args []interface{}, v interface{}) error {
+	switch len(args) {
+	case 0:
+		return nil
+
+	case 1:
+		// The argument was already decoded into generic JSON types, so
+		// encode it again and decode it into the concrete type.
+		data, err := json.Marshal(args[0])
+		if err != nil {
+			return err
+		}
+		err = json.Unmarshal(data, v)
+		if err != nil {
+			return &Error{Code: CodeBadArguments, Message: "bad request argument; " + err.Error()}
+		}
+		return nil
+	}
+	return &Error{
+		Code:    CodeBadArguments,
+		Message: fmt.Sprintf("bad request arguments; expected one argument, got %d", len(args)),
+	}
+}
+
+// jsonCompatible tells weather values of type t can be encoded to and decoded
+// from JSON.
+func jsonCompatible(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Chan, reflect.Func, reflect.Complex64, reflect.Complex128, reflect.UnsafePointer:
+		return false
+
+	case reflect.Ptr, reflect.Slice, reflect.Array:
+		return jsonCompatible(t.Elem())
+
+	case reflect.Map:
+		switch t.Key().Kind() {
+		case reflect.String,
+			reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		default:
+			if !t.Key().Implements(textMarshalerType) {
+				return false
+			}
+		}
+		return jsonCompatible(t.Elem())
+	}
+	return true
+}
+
+// callHandler invokes the request handler for the request message, through
+// the server's middleware, and returns the response message which should be
+// sent back.
+//
+// If there is no such request handler, or it (or any middleware) fails,
+// panics, or cannot accept the request arguments, then an error response is
+// returned instead.
+func (s *Server) callHandler(parent context.Context, request *message, c *Connection) (response *message) {
+	ctx, cancel := s.handlerContext(parent)
+	defer cancel()
+
+	call := &Call{
+		Name:       request.requestName,
+		Args:       request.args,
+		Connection: c,
+		Context:    ctx,
+	}
+
+	// The request handler, once looked up, for the panic message. The handlers
+	// map is never touched again in here, in case that is what panicked.
+	var handler interface{}
+	defer func() {
+		if r := recover(); r != nil {
+			valueArgs := append(interfaceToValueSlice(call.Args), reflect.ValueOf(c))
+			logger().Println(handlerPanicMessage(call.Name, handler, valueArgs, r))
+			response = newErrorMessage(request.id, &Error{
+				Code:    CodeHandlerPanic,
+				Message: "request handler panic",
+			})
+		}
+	}()
+
+	results, err := s.invoker(func(call *Call) ([]interface{}, error) {
+		handler = s.getHandler(call.Name)
+		return s.invoke(call, handler)
+	})(call)
+	if err != nil {
+		return newErrorMessage(request.id, toError(err))
+	}
+	return newResponseMessage(request.id, results)
+}
+
+// handlerContext returns an new context for an request handler, derived from
+// the request's context (which is cancelled once the connection dies, or the
+// other end cancels the request), which is also cancelled once the server has
+// shut down, or once the server's handler timeout (if any) elapses.
+func (s *Server) handlerContext(parent context.Context) (context.Context, context.CancelFunc) {
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if timeout := s.HandlerTimeout(); timeout > 0 {
+		ctx, cancel = context.WithTimeout(parent, timeout)
+	} else {
+		ctx, cancel = context.WithCancel(parent)
+	}
+	stop := context.AfterFunc(s.ctx, cancel)
+	return ctx, func() {
+		stop()
+		cancel()
+	}
+}
+
+// invoke invokes the request handler for the call, it is what the innermost
+// Invoker of the middleware chain calls.
+func (s *Server) invoke(call *Call, handler interface{}) ([]interface{}, error) {
+	if handler == nil {
+		logger().Printf("No handler for message \"%v\"\n", call.Name)
+		return nil, &Error{
+			Code:    CodeNoHandler,
+			Message: fmt.Sprintf("no handler for request %v", call.Name),
+		}
+	}
+
+	if typed, ok := handler.(typedHandler); ok {
+		// Registered through HandleFunc(), it decodes it's own arguments.
+		return typed(call.Context, call.Connection, call.Args)
+	}
+
+	fn := reflect.ValueOf(handler)
+	fnType := fn.Type()
+
+	valueArgs := interfaceToValueSlice(call.Args)
+	valueArgs = append(valueArgs, reflect.ValueOf(call.Connection))
+	if acceptsContext(fnType) {
+		valueArgs = append([]reflect.Value{reflect.ValueOf(call.Context)}, valueArgs...)
+	}
+
+	if !argumentsAssignable(fnType, valueArgs) {
+		logger().Println(handlerPanicMessage(call.Name, handler, valueArgs, "bad request arguments"))
+		return nil, &Error{
+			Code:    CodeBadArguments,
+			Message: fmt.Sprintf("bad request arguments for request %v", call.Name),
+		}
+	}
+	responseValues := fn.Call(valueArgs)
+
+	// An trailing error return value is never sent as an response argument,
+	// if it is non-nil an error response is sent instead.
+	if n := fnType.NumOut(); n > 0 && fnType.Out(n-1) == errorType {
+		errValue := responseValues[n-1]
+		responseValues = responseValues[:n-1]
+		if !errValue.IsNil() {
+			return nil, errValue.Interface().(error)
+		}
+	}
+
+	results := make([]interface{}, len(responseValues))
+	for i, v := range responseValues {
+		results[i] = v.Interface()
+	}
+	return results, nil
+}
+
+// acceptsContext tells weather the request handler of type fnType takes an
+// leading context.Context parameter.
+func acceptsContext(fnType reflect.Type) bool {
+	return fnType.NumIn() > 1 && fnType.In(0) == contextType
+}
+
+// argumentsAssignable tells weather an function of type fnType can be called
+// using the specified arguments.
+//
+// Numeric arguments are converted in place to the numeric parameter type they
+// are given for, as long as they hold an number which that type holds exactly
+// (e.g. an float64 for an int parameter, or an int64 for an float64 one, which
+// depends on the codec in use).
+func argumentsAssignable(fnType reflect.Type, args []reflect.Value) bool {
+	numIn := fnType.NumIn()
+	if fnType.IsVariadic() {
+		if len(args) < numIn-1 {
+			return false
+		}
+	} else if len(args) != numIn {
+		return false
+	}
+
+	for i, arg := range args {
+		var in reflect.Type
+		if fnType.IsVariadic() && i >= numIn-1 {
+			in = fnType.In(numIn - 1).Elem()
+		} else {
+			in = fnType.In(i)
+		}
+		if !arg.Type().AssignableTo(in) {
+			converted, ok := convertNumber(arg, in)
+			if !ok {
+				return false
+			}
+			args[i] = converted
+		}
+	}
+	return true
+}
+
+// handlerPanicMessage formats an descriptive message about an request handler
+// which panicked, or could not be called using the request arguments.
+func handlerPanicMessage(requestName, handler interface{}, valueArgs []reflect.Value, r interface{}) string {
+	buf := new(bytes.Buffer)
+	fmt.Fprintf(buf, "Request handler \"%v\" panic:\n\n", requestName)
+	fmt.Fprintf(buf, "Expected type:\n")
+	fmt.Fprintf(buf, "\t")
+
+	fmt.Fprintf(buf, "func(")
+	for n := 0; n < len(valueArgs); n++ {
+		fmt.Fprint(buf, valueArgs[n].Type().String())
+		if n+1 < len(valueArgs) {
+			fmt.Fprintf(buf, ", ")
+		}
+	}
+	fmt.Fprintf(buf, ") ...")
+
+	fmt.Fprintf(buf, "\nFound type:\n\t")
+	fmt.Fprintf(buf, "%v\n\n", reflect.TypeOf(handler))
+	fmt.Fprintf(buf, "%v\n\n", r)
+	fmt.Fprintf(buf, "%s", string(debug.Stack()))
+	return buf.String()
+}
@@ -0,0 +1,152 @@
+// Copyright 2012 Lightpoke. All rights reserved.
+// This source code is subject to the terms and
+// conditions defined in the "License.txt" file.
+
+package organics_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sinni800/organics"
+)
+
+type point struct{ X, Y int }
+
+func TestHandleFunc(t *testing.T) {
+	s := newServer(t)
+	organics.HandleFunc(s.Server, "Move", func(ctx context.Context, c *organics.Connection, p point) (point, error) {
+		return point{p.X + 1, p.Y + 1}, nil
+	})
+
+	for name, url := range s.urls() {
+		t.Run(name, func(t *testing.T) {
+			c, _ := s.dial(t, url)
+
+			moved := make(chan map[string]interface{}, 1)
+			c.Request("Move", point{1, 2}, func(p map[string]interface{}) {
+				moved <- p
+			})
+			if p := receive(t, moved); p["X"] != 2.0 || p["Y"] != 3.0 {
+				t.Fatalf("Move({1, 2}) = %v, want {2, 3}", p)
+			}
+
+			failed := make(chan *organics.Error, 1)
+			c.Request("Move", "not a point", func(err *organics.Error) {
+				failed <- err
+			})
+			if err := receive(t, failed); err.Code != organics.CodeBadArguments {
+				t.Fatalf("Move(string) got %v, want CodeBadArguments", err)
+			}
+		})
+	}
+}
+
+func TestHandleFuncIncompatibleType(t *testing.T) {
+	s := newServer(t)
+	defer func() {
+		if recover() == nil {
+			t.Fatal("HandleFunc() did not panic for an channel request type")
+		}
+	}()
+	organics.HandleFunc(s.Server, "Bad", func(ctx context.Context, c *organics.Connection, ch chan int) (int, error) {
+		return 0, nil
+	})
+}
+
+func TestHandleInvalidName(t *testing.T) {
+	s := newServer(t)
+	mustPanic := func(register func()) {
+		t.Helper()
+		defer func() {
+			if msg, _ := recover().(string); !strings.HasPrefix(msg, "organics: request name") {
+				t.Fatalf("got panic %q, want an invalid request name panic", msg)
+			}
+		}()
+		register()
+	}
+	mustPanic(func() {
+		s.Handle([]interface{}{1, 2}, func(c *organics.Connection) {})
+	})
+	mustPanic(func() {
+		organics.HandleFunc(s.Server, map[string]int{}, func(ctx context.Context, c *organics.Connection, n int) (int, error) {
+			return n, nil
+		})
+	})
+}
+
+type contextKey struct{}
+
+func TestHandlerContext(t *testing.T) {
+	s := newServer(t)
+	s.SetHandlerTimeout(100 * time.Millisecond)
+	s.Use(func(call *organics.Call, next organics.Invoker) ([]interface{}, error) {
+		call.Context = context.WithValue(call.Context, contextKey{}, "middleware")
+		return next(call)
+	})
+	s.Handle("Value", func(ctx context.Context, c *organics.Connection) string {
+		v, _ := ctx.Value(contextKey{}).(string)
+		return v
+	})
+	s.Handle("Wait", func(ctx context.Context, c *organics.Connection) string {
+		select {
+		case <-ctx.Done():
+			return ctx.Err().Error()
+		case <-time.After(5 * time.Second):
+			return "not cancelled"
+		}
+	})
+	organics.HandleFunc(s.Server, "Deadline", func(ctx context.Context, c *organics.Connection, n int) (bool, error) {
+		_, ok := ctx.Deadline()
+		return ok, nil
+	})
+
+	c, sc := s.dial(t, s.wsURL())
+	results := make(chan interface{}, 1)
+	complete := func(v interface{}) { results <- v }
+	c.Request("Value", complete)
+	if v := receive(t, results); v != "middleware" {
+		t.Fatalf("Value = %v, want the value set by the middleware", v)
+	}
+	c.Request("Wait", complete)
+	if v := receive(t, results); v != context.DeadlineExceeded.Error() {
+		t.Fatalf("Wait = %v, want the handler timeout to cancel it", v)
+	}
+	c.Request("Deadline", 1, complete)
+	if v := receive(t, results); v != true {
+		t.Fatal("HandleFunc() handler context has no deadline")
+	}
+
+	// The connection's context is cancelled once it dies.
+	ctx := sc.Context()
+	if ctx.Err() != nil {
+		t.Fatal("connection context cancelled while alive")
+	}
+	c.Kill()
+	receive(t, ctx.Done())
+}
+
+func TestHandlerContextShutdown(t *testing.T) {
+	s := newServer(t)
+	started := make(chan bool)
+	cancelled := make(chan bool)
+	s.Handle("Wait", func(ctx context.Context, c *organics.Connection) {
+		close(started)
+		<-ctx.Done()
+		close(cancelled)
+	})
+
+	c, _ := s.dial(t, s.wsURL())
+	c.Request("Wait")
+	receive(t, started)
+
+	// Shutdown gives up on the handler, which is then cancelled.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("Shutdown() = %v, want context.DeadlineExceeded", err)
+	}
+	receive(t, cancelled)
+}
@@ -512,18 +512,122 @@ if (typeof JSON !== 'object') {
 var Organics = new function() {
 	this.Debug = false;
 	this.WebSocketSupported = "WebSocket" in window || "MozWebSocket" in window;
+	this.ServerSentEventsSupported = "EventSource" in window;
+	this.MessagePackSupported = typeof ArrayBuffer != "undefined" && typeof Uint8Array != "undefined" && typeof DataView != "undefined";
+
+	// Connection methods, as returned by Connection.Method().
+	this.LongPolling      = "LongPolling";
+	this.WebSocket        = "WebSocket";
+	this.ServerSentEvents = "ServerSentEvents";
 
 	// Special 'unique' messages.
 	this.Connect = "A!B@C#D$E%F^G&H*I(J)";
 	this.Disconnect = "a1b2c3d4e5f6g7h8i9j0";
 
+	// Stores whose keys may be watched, see Connection.Watch().
+	this.SessionStore    = "s";
+	this.ConnectionStore = "c";
+
 	// Constants (save extra bytes by making them acronyms)
 	this.__rtLongPollEstablishConnection  = "lpec"; // long-poll-establish-connection
 	this.__rtLongPoll                     = "lp";   // long-poll
 	this.__rtMessage                      = "m";    // message
+	this.__rtRekey                        = "rk";   // rekey (gets the regenerated session cookie)
+
+	// Event names used in server-sent event streams.
+	this.__seConnect = "c"; // carries the connection id
+	this.__sePing    = "p"; // an ping, we answer it with an empty message
 
 	this.ErrNotConnected = "not currently connected to server";
 
+	// Frames which are not an request or response to one begin with an string instead of an
+	// numeric id, the string tells what type of frame it is.
+	this.__ftError     = "e"; // error response
+	this.__ftSequenced = "s"; // sequenced message (resumable connections only)
+	this.__ftAck       = "a"; // acknowledgement of sequenced messages
+	this.__ftResume    = "r"; // resume information, sent by the server only
+	this.__ftGoingAway = "g"; // the server is shutting down, sent by the server only
+	this.__ftCancel    = "c"; // cancels an request which is in flight
+	this.__ftProgress  = "p"; // partial result of an request, sent by the server only
+	this.__ftWatch     = "w"; // watches an store key
+	this.__ftUnwatch   = "u"; // stops watching an store key
+	this.__ftValue     = "v"; // value of an watched store key, sent by the server only
+	this.__ftToken     = "t"; // session key in token mode, sent by the server only
+	this.__ftRekey     = "k"; // ticket of an regenerated session, sent by the server only
+
+	// The header, and the WebSocket subprotocol, which carry the session key in token mode (see
+	// the SessionTokens parameter of Connection).
+	this.__hdrSession = "X-Organics-Session";
+	this.__spSession  = "organics-session";
+
+	// The WebSocket subprotocol which carries the bearer token (see SetBearerToken).
+	this.__spBearer = "organics-bearer";
+
+	// Codecs which frames are encoded using, connections use MessagePack if the server chooses it
+	// as they connect (see Server.SetCodec), otherwise JSON. WebSockets offer codecs as
+	// subprotocols, long-polling in an header of the establish request and event streams in an
+	// query parameter.
+	this.__codecProtocolPrefix = "organics.";
+	this.__hdrCodec = "X-Organics-Codec";
+	this.__qpCodec  = "organics-codec";
+	this.__json = {
+		Name: "json",
+		encode: function(frame) {
+			return JSON.stringify(frame);
+		},
+		decode: function(data) {
+			return JSON.parse(data);
+		}
+	};
+
+	// __offeredCodecs returns the names of the codecs we support, preferred first, the way
+	// long-polling and event streams offer them.
+	this.__offeredCodecs = function() {
+		if(Organics.MessagePackSupported) {
+			return Organics.__msgpack.Name + ", " + Organics.__json.Name;
+		}
+		return Organics.__json.Name;
+	}
+
+	// __codecNamed returns the codec which the server chose, JSON unless it named another one.
+	this.__codecNamed = function(name) {
+		if(name == Organics.__msgpack.Name) {
+			return Organics.__msgpack;
+		}
+		return Organics.__json;
+	}
+
+	// Resumable connections acknowledge received messages at least this often.
+	this.__ackInterval = 16;
+
+	// Resumable connections try to resume this many times, waiting ResumeDelay milliseconds
+	// before each attempt, before they give up and disconnect.
+	this.ResumeAttempts = 5;
+	this.ResumeDelay    = 1000;
+
+	// Error codes which Organics itself uses in error responses.
+	this.CodeHandlerError = 1; // The request handler failed (threw an exception).
+	this.CodeHandlerPanic = 2; // The request handler panicked.
+	this.CodeNoHandler    = 3; // There is no request handler for the request name.
+	this.CodeBadArguments = 4; // The request arguments did not match the request handler.
+	this.CodeShuttingDown = 5; // The server is shutting down, and no longer handles requests.
+
+	// Once the server tells us it is going away, we reconnect this many milliseconds (plus up to
+	// as many at random, so not everyone reconnects at once) after it disconnects us.
+	this.ReconnectDelay = 5000;
+
+	// Error is given to an request's OnComplete function, as it's only argument, when the
+	// request failed on the other end. Request handlers may also throw an Organics.Error in
+	// order to control the code and data that the other end receives.
+	this.Error = function(Code, Message, Data) {
+		this.Code = Code;
+		this.Message = Message;
+		this.Data = Data;
+	}
+	this.Error.prototype.toString = function() {
+		return "Organics.Error(" + this.Code + "): " + this.Message;
+	}
+
 //	this.ErrorDisconnected = "disconnected from server or connection closed";
 //	this.ErrorConnectionFailed = "unable to connect to server";
 //	this.ErrorBadData = "server sent corrupted, bad, or unreadable data";
@@ -600,6 +704,320 @@ var Organics = new function() {
 		return str.slice(-w.length) == w;
 	}
 
+	// Returns (str) hex encoded, byte for byte (as UTF-8).
+	this.__hexEncode = function(str) {
+		var bytes = unescape(encodeURIComponent(str));
+		var hex = "";
+		for(var i = 0; i < bytes.length; i++) {
+			var c = bytes.charCodeAt(i).toString(16);
+			hex += (c.length < 2) ? "0" + c : c;
+		}
+		return hex;
+	}
+
+	// __base64Decode decodes the base64 string into an ArrayBuffer, an exception is thrown if it
+	// is not valid base64.
+	this.__base64Decode = function(str) {
+		var bin = window.atob(str);
+		var bytes = new Uint8Array(bin.length);
+		for(var i = 0; i < bin.length; i++) {
+			bytes[i] = bin.charCodeAt(i);
+		}
+		return bytes.buffer;
+	}
+
+	// __joinBatch joins the binary (Uint8Array) messages into an single batch, where each one is
+	// prefixed by it's length as an 4 byte big-endian integer. This is how long-polling batches
+	// are encoded for any codec but JSON.
+	this.__joinBatch = function(messages) {
+		var size = 0;
+		for(var i = 0; i < messages.length; i++) {
+			size += 4 + messages[i].length;
+		}
+		var batch = new Uint8Array(size);
+		var view = new DataView(batch.buffer);
+		var pos = 0;
+		for(var i = 0; i < messages.length; i++) {
+			view.setUint32(pos, messages[i].length);
+			batch.set(messages[i], pos + 4);
+			pos += 4 + messages[i].length;
+		}
+		return batch;
+	}
+
+	// __splitBatch splits an batch joined by __joinBatch (an ArrayBuffer) into it's messages, an
+	// exception is thrown if it is truncated.
+	this.__splitBatch = function(buffer) {
+		var view = new DataView(buffer);
+		var messages = [];
+		var pos = 0;
+		while(pos < buffer.byteLength) {
+			if(pos + 4 > buffer.byteLength) {
+				throw "truncated message length";
+			}
+			var n = view.getUint32(pos);
+			if(pos + 4 + n > buffer.byteLength) {
+				throw "truncated message";
+			}
+			messages.push(buffer.slice(pos + 4, pos + 4 + n));
+			pos += 4 + n;
+		}
+		return messages;
+	}
+
+	// MessagePack encoding and decoding, see http://msgpack.org
+	//
+	// Values are encoded just like JSON.stringify would encode them; integers are sent as such,
+	// other numbers as 64-bit floats. Binary data (ArrayBuffer, Uint8Array) is decoded into an
+	// Uint8Array.
+	this.__msgpack = {
+		Name: "msgpack",
+
+		encode: function(frame) {
+			var bytes = [];
+			var scratch = new DataView(new ArrayBuffer(8));
+
+			var pushUint = function(v, size) {
+				for(var i = size - 1; i >= 0; i--) {
+					bytes.push(Math.floor(v / Math.pow(2, 8 * i)) & 0xff);
+				}
+			};
+			var pushLength = function(n, fixed, fixedMax, code8, code16, code32) {
+				if(fixed != null && n <= fixedMax) {
+					bytes.push(fixed | n);
+				} else if(code8 != null && n <= 0xff) {
+					bytes.push(code8, n);
+				} else if(n <= 0xffff) {
+					bytes.push(code16);
+					pushUint(n, 2);
+				} else {
+					bytes.push(code32);
+					pushUint(n, 4);
+				}
+			};
+			var pushString = function(str) {
+				var utf8 = [];
+				for(var i = 0; i < str.length; i++) {
+					var c = str.charCodeAt(i);
+					if(c < 0x80) {
+						utf8.push(c);
+					} else if(c < 0x800) {
+						utf8.push(0xc0 | (c >> 6), 0x80 | (c & 0x3f));
+					} else if((c & 0xfc00) == 0xd800 && i + 1 < str.length && (str.charCodeAt(i + 1) & 0xfc00) == 0xdc00) {
+						c = 0x10000 + ((c & 0x3ff) << 10) + (str.charCodeAt(++i) & 0x3ff);
+						utf8.push(0xf0 | (c >> 18), 0x80 | ((c >> 12) & 0x3f), 0x80 | ((c >> 6) & 0x3f), 0x80 | (c & 0x3f));
+					} else {
+						utf8.push(0xe0 | (c >> 12), 0x80 | ((c >> 6) & 0x3f), 0x80 | (c & 0x3f));
+					}
+				}
+				pushLength(utf8.length, 0xa0, 31, 0xd9, 0xda, 0xdb);
+				for(var i = 0; i < utf8.length; i++) {
+					bytes.push(utf8[i]);
+				}
+			};
+			var pushNumber = function(v) {
+				if(Math.floor(v) === v && Math.abs(v) <= 9007199254740991) {
+					if(v >= 0) {
+						if(v <= 0x7f) {
+							bytes.push(v);
+						} else if(v <= 0xff) {
+							bytes.push(0xcc, v);
+						} else if(v <= 0xffff) {
+							bytes.push(0xcd);
+							pushUint(v, 2);
+						} else if(v <= 0xffffffff) {
+							bytes.push(0xce);
+							pushUint(v, 4);
+						} else {
+							bytes.push(0xcf);
+							pushUint(v, 8);
+						}
+					} else if(v >= -32) {
+						bytes.push(v & 0xff);
+					} else if(v >= -128) {
+						bytes.push(0xd0, v & 0xff);
+					} else if(v >= -32768) {
+						bytes.push(0xd1);
+						pushUint(v + 0x10000, 2);
+					} else if(v >= -2147483648) {
+						bytes.push(0xd2);
+						pushUint(v + 0x100000000, 4);
+					} else {
+						// 64-bit two's complement, in two halves.
+						var hi = Math.floor(v / 0x100000000);
+						bytes.push(0xd3);
+						pushUint(hi + 0x100000000, 4);
+						pushUint(v - hi * 0x100000000, 4);
+					}
+					return;
+				}
+				if(!isFinite(v)) {
+					// Just like JSON.stringify.
+					bytes.push(0xc0);
+					return;
+				}
+				scratch.setFloat64(0, v);
+				bytes.push(0xcb);
+				for(var i = 0; i < 8; i++) {
+					bytes.push(scratch.getUint8(i));
+				}
+			};
+			var push = function(v) {
+				if(v == null || typeof v == "function") {
+					bytes.push(0xc0);
+				} else if(typeof v == "boolean") {
+					bytes.push(v ? 0xc3 : 0xc2);
+				} else if(typeof v == "number") {
+					pushNumber(v);
+				} else if(typeof v == "string") {
+					pushString(v);
+				} else if(v instanceof ArrayBuffer || v instanceof Uint8Array) {
+					var bin = new Uint8Array(v);
+					pushLength(bin.length, null, 0, 0xc4, 0xc5, 0xc6);
+					for(var i = 0; i < bin.length; i++) {
+						bytes.push(bin[i]);
+					}
+				} else if(typeof v.toJSON == "function") {
+					push(v.toJSON());
+				} else if(v instanceof Array) {
+					pushLength(v.length, 0x90, 15, null, 0xdc, 0xdd);
+					for(var i = 0; i < v.length; i++) {
+						push(v[i]);
+					}
+				} else {
+					var keys = [];
+					for(var key in v) {
+						if(Object.prototype.hasOwnProperty.call(v, key) && v[key] !== undefined && typeof v[key] != "function") {
+							keys.push(key);
+						}
+					}
+					pushLength(keys.length, 0x80, 15, null, 0xde, 0xdf);
+					for(var i = 0; i < keys.length; i++) {
+						pushString(keys[i]);
+						push(v[keys[i]]);
+					}
+				}
+			};
+			push(frame);
+			return new Uint8Array(bytes);
+		},
+
+		decode: function(buffer) {
+			var view = new DataView(buffer);
+			var pos = 0;
+
+			var uint = function(size) {
+				var v = 0;
+				for(var i = 0; i < size; i++) {
+					v = v * 256 + view.getUint8(pos++);
+				}
+				return v;
+			};
+			var string = function(n) {
+				var str = "";
+				var end = pos + n;
+				if(end > view.byteLength) {
+					throw new Error("msgpack: unexpected end of data");
+				}
+				while(pos < end) {
+					var c = view.getUint8(pos++);
+					if(c >= 0xf0) {
+						c = ((c & 0x07) << 18) | ((view.getUint8(pos++) & 0x3f) << 12) | ((view.getUint8(pos++) & 0x3f) << 6) | (view.getUint8(pos++) & 0x3f);
+						c -= 0x10000;
+						str += String.fromCharCode(0xd800 + (c >> 10), 0xdc00 + (c & 0x3ff));
+						continue;
+					} else if(c >= 0xe0) {
+						c = ((c & 0x0f) << 12) | ((view.getUint8(pos++) & 0x3f) << 6) | (view.getUint8(pos++) & 0x3f);
+					} else if(c >= 0xc0) {
+						c = ((c & 0x1f) << 6) | (view.getUint8(pos++) & 0x3f);
+					}
+					str += String.fromCharCode(c);
+				}
+				return str;
+			};
+			var array = function(n) {
+				var a = [];
+				for(var i = 0; i < n; i++) {
+					a.push(read());
+				}
+				return a;
+			};
+			var map = function(n) {
+				var m = {};
+				for(var i = 0; i < n; i++) {
+					var key = read();
+					m[key] = read();
+				}
+				return m;
+			};
+			var read = function() {
+				var c = view.getUint8(pos++);
+				if(c <= 0x7f) {
+					return c;
+				} else if(c >= 0xe0) {
+					return c - 0x100;
+				} else if((c & 0xf0) == 0x80) {
+					return map(c & 0x0f);
+				} else if((c & 0xf0) == 0x90) {
+					return array(c & 0x0f);
+				} else if((c & 0xe0) == 0xa0) {
+					return string(c & 0x1f);
+				}
+
+				var v;
+				switch(c) {
+				case 0xc0: return null;
+				case 0xc2: return false;
+				case 0xc3: return true;
+				case 0xc4: case 0xc5: case 0xc6:
+					var n = uint(1 << (c - 0xc4));
+					v = new Uint8Array(buffer.slice(pos, pos + n));
+					pos += n;
+					return v;
+				case 0xca:
+					v = view.getFloat32(pos);
+					pos += 4;
+					return v;
+				case 0xcb:
+					v = view.getFloat64(pos);
+					pos += 8;
+					return v;
+				case 0xcc: case 0xcd: case 0xce: case 0xcf:
+					return uint(1 << (c - 0xcc));
+				case 0xd0:
+					v = view.getInt8(pos);
+					pos += 1;
+					return v;
+				case 0xd1:
+					v = view.getInt16(pos);
+					pos += 2;
+					return v;
+				case 0xd2:
+					v = view.getInt32(pos);
+					pos += 4;
+					return v;
+				case 0xd3:
+					v = view.getInt32(pos) * 0x100000000 + view.getUint32(pos + 4);
+					pos += 8;
+					return v;
+				case 0xd9: case 0xda: case 0xdb:
+					return string(uint(1 << (c - 0xd9)));
+				case 0xdc: case 0xdd:
+					return array(uint(2 << (c - 0xdc)));
+				case 0xde: case 0xdf:
+					return map(uint(2 << (c - 0xde)));
+				}
+				throw new Error("msgpack: unsupported type " + c);
+			};
+
+			var frame = read();
+			if(pos != view.byteLength) {
+				throw new Error("msgpack: trailing data after frame");
+			}
+			return frame;
+		}
+	};
+
 	// Returns an new XMLHttpRequest object for this browser
 	this.__xhr = function() {
 		if(window.XMLHttpRequest) {
@@ -681,7 +1099,9 @@ var Organics = new function() {
 	}
 
 	// Performs ajax request on our behalf, do not use externally.
-	this.__ajax = function(url, method, handlers, data, timeout, headers) {
+	//
+	// The responseType is that of the XMLHttpRequest, if given.
+	this.__ajax = function(url, method, handlers, data, timeout, headers, responseType) {
 		var xhr = new Organics.__xhr();
 		if(!xhr) {
 	 		handlers["error"](null, "Browser has no support for AJAX");
@@ -699,7 +1119,7 @@ var Organics = new function() {
 		xhr.onreadystatechange = function() {
 			if(xhr.readyState == 4) {
 				if(timer) {
-					clearTimer(timer);
+					clearTimeout(timer);
 				}
 				if(xhr.status == 200) {
 					handlers["complete"](xhr);
@@ -727,6 +1147,9 @@ var Organics = new function() {
 			handlers["error"](xhr, "XMLHttpRequest.open failed: " + actualError);
 			return;
 		}
+		if(responseType) {
+			xhr.responseType = responseType;
+		}
 
 		if(headers == null) {
 			headers = {};
@@ -770,11 +1193,81 @@ var Organics = new function() {
 	//         (optional):    yes
 	//         (default):     15000 (15 seconds)
 	//
-	this.Connection = function(URL, TLS, Timeout) {
+	//     Resume
+	//         (description): weather to resume the connection (I.e. to get the same connection on
+	//                        the server back, along with any messages which got lost) after it's
+	//                        WebSocket, event stream, or long-polling request fails. The server
+	//                        must have an resume window set for this to work.
+	//         (type):        boolean
+	//         (optional):    yes
+	//         (default):     false
+	//
+	//     SessionTokens
+	//         (description): weather to use token mode, for pages which cannot store cookies (e.g.
+	//                        embedded web views, or third-party iframes): the server gives us our
+	//                        session key, which we send back ourselves instead of the session
+	//                        cookie (see SessionToken). The server must allow token mode for this
+	//                        to work. Server-sent events are never used in token mode.
+	//         (type):        boolean
+	//         (optional):    yes
+	//         (default):     false
+	//
+	this.Connection = function(URL, TLS, Timeout, Resume, SessionTokens) {
 		var self = this;
 
 		self.__requestCounter = -1;
 		self.__requestHandlers = {};
+		self.__progressHandlers = {};
+
+		// Functions watching store keys, by scope and then key (see Watch).
+		self.__watchers = {};
+		self.__watchers[Organics.SessionStore] = {};
+		self.__watchers[Organics.ConnectionStore] = {};
+
+		self.Resume = Resume;
+		if(self.Resume == null) {
+			self.Resume = false;
+		}
+		if(typeof self.Resume != "boolean") {
+			throw TypeError("Resume optional parameter must be bool!");
+		}
+
+		self.SessionTokens = SessionTokens;
+		if(self.SessionTokens == null) {
+			self.SessionTokens = false;
+		}
+		if(typeof self.SessionTokens != "boolean") {
+			throw TypeError("SessionTokens optional parameter must be bool!");
+		}
+		self.__sessionToken = null;
+		self.__bearerToken = null;
+
+		// Incremented for each connection attempt, events belonging to an previous one are
+		// ignored.
+		self.__generation = 0;
+
+		// Set once the server tells us it is going away.
+		self.__goingAway = false;
+
+		// The codec frames are encoded using, WebSocket connections negotiate it.
+		self.__codec = Organics.__json;
+
+		// Messages waiting for the POST request in flight (of the generation given), see
+		// __postMessage().
+		self.__postQueue = [];
+		self.__postGeneration = null;
+
+		// Resumable connection state, see __send() and __handleResume().
+		self.__resumeKey = null;
+		self.__resuming = false;
+		self.__resumeAttempts = 0;
+		self.__resetSequence = function() {
+			self.__sent = 0;
+			self.__sentBuffer = []; // [seq, frame] for each sent message not yet acknowledged
+			self.__received = 0;
+			self.__receivedAcked = 0;
+		}
+		self.__resetSequence();
 
 		self.__URL = URL;
 		if(typeof self.__URL != "string") {
@@ -821,11 +1314,24 @@ var Organics = new function() {
 		}
 
 
-		// Create URL with proper method depending on WebSocket support in browser, and TLS option.
+		// Create URLs with proper method depending on TLS option.
 		if(self.TLS) {
 			self.__HTTP_URL = methodHttps + self.__URL;
+			self.__WS_URL = methodWss + self.__URL;
 		} else {
 			self.__HTTP_URL = methodHttp + self.__URL;
+			self.__WS_URL = methodWs + self.__URL;
+		}
+
+		// The connection method is negotiated; we start with the best one the browser supports and
+		// fall back to the next one if we fail to connect using it (e.g. an proxy which breaks
+		// WebSocket upgrades).
+		if(Organics.WebSocketSupported) {
+			self.__method = Organics.WebSocket;
+		} else if(self.__serverSentEventsUsable()) {
+			self.__method = Organics.ServerSentEvents;
+		} else {
+			self.__method = Organics.LongPolling;
 		}
 
 		if(Organics.WebSocketSupported) {
@@ -847,6 +1353,20 @@ var Organics = new function() {
 		}
 
 		self.__handleDisconnect = function(err, later) {
+			self.__resumeKey = null;
+			self.__resuming = false;
+			self.__resetSequence();
+
+			if(self.__goingAway) {
+				// The server asked us to come back later.
+				self.__goingAway = false;
+				var delay = Organics.ReconnectDelay + Math.random() * Organics.ReconnectDelay;
+				self.__logMessage("-> Server is going away; reconnecting in " + delay + "ms");
+				setTimeout(function() {
+					self.Connect();
+				}, delay);
+			}
+
 			if(self.__connected == true || self.__connecting == true) {
 				self.__connected = false;
 				self.__connecting = false;
@@ -861,8 +1381,27 @@ var Organics = new function() {
 			self.__connecting = false;
 		}
 
+		// __handleOpen is called once the transport is connected.
+		self.__handleOpen = function() {
+			if(self.__resuming) {
+				// Still connected, as far as anyone else knows; wait for the resume information.
+				return;
+			}
+			self.__connected = true;
+			self.__connecting = false;
+			self.__handleConnect();
+		}
+
 		self.__handleConnect = function() {
 			self.__logMessage("-> Connected");
+
+			// An new connection watches nothing yet.
+			for(var scope in self.__watchers) {
+				for(var key in self.__watchers[scope]) {
+					self.__send([Organics.__ftWatch, scope, key]);
+				}
+			}
+
 			var fn = self.__handlers[Organics.Connect];
 			if(fn) {
 				fn()
@@ -870,6 +1409,12 @@ var Organics = new function() {
 		}
 	}
 
+	// Method returns the connection method currently in use, one of Organics.WebSocket,
+	// Organics.ServerSentEvents or Organics.LongPolling.
+	this.Connection.prototype.Method = function() {
+		return this.__method;
+	}
+
 	// Connected tells weather this Connection is currently connected (boolean).
 	this.Connection.prototype.Connected = function() {
 		var self = this;
@@ -886,8 +1431,12 @@ var Organics = new function() {
 
 		if(self.__connected == true) {
 			self.__connected = false;
-			if(Organics.WebSocketSupported) {
+			self.__resuming = false;
+			self.__generation++;
+			if(self.__method == Organics.WebSocket) {
 				self.__webSocket.close()
+			} else if(self.__method == Organics.ServerSentEvents) {
+				self.__eventSource.close()
 			}
 		}
 	}
@@ -896,12 +1445,6 @@ var Organics = new function() {
 	this.Connection.prototype.Connect = function() {
 		var self = this;
 
-		if(Organics.WebSocketSupported) {
-			self.__logMessage("WebSocket is supported");
-		} else {
-			self.__logMessage("No WebSocket support; using long polling");
-		}
-
 		if(self.__connected || self.__connecting) {
 			return;
 		}
@@ -909,36 +1452,62 @@ var Organics = new function() {
 		self.__connecting = true;
 
 		var doConnect = function() {
-			if(Organics.WebSocketSupported) {
-				self.__connectWebSocket()
+			var generation = ++self.__generation;
+
+			self.__codec = Organics.__json;
+			self.__logMessage("Connecting using " + self.__method);
+			if(self.__method == Organics.WebSocket) {
+				self.__connectWebSocket(doConnect, generation)
+				return
+			} else if(self.__method == Organics.ServerSentEvents) {
+				self.__connectEventSource(doConnect, generation)
 				return
 			}
 
-			// Perform the create session request
-			Organics.__ajax(self.__HTTP_URL, "POST", {
+			// Perform the create session request, which offers the codecs we support.
+			var headers = self.__headers({
+				// This informs the server this is an session creation request, and they it should
+				// respond immedietly after ensuring we have an request.
+				"X-Organics-Req": Organics.__rtLongPollEstablishConnection
+			});
+			headers[Organics.__hdrCodec] = Organics.__offeredCodecs();
+			Organics.__ajax(self.__connectURL(self.__HTTP_URL), "POST", {
 				complete: function(xhr) {
+					if(generation != self.__generation) {
+						return;
+					}
 					self.__connectionId = xhr.responseText;
+					self.__codec = Organics.__codecNamed(xhr.getResponseHeader(Organics.__hdrCodec));
+					if(self.SessionTokens) {
+						var token = xhr.getResponseHeader(Organics.__hdrSession);
+						if(token) {
+							self.__sessionToken = token;
+						}
+					}
 					self.__logMessage("-> Create session request successful: connected to server");
-					self.__connected = true;
-					self.__connecting = false;
 					setTimeout(function() {
-						self.__doLongPolling();
+						self.__doLongPolling(generation);
 					}, 0);
-					self.__handleConnect();
+					self.__handleOpen();
 				},
 				error: function(xhr, msg) {
+					if(generation != self.__generation) {
+						return;
+					} else if(self.__resuming) {
+						self.__retryResume();
+						return;
+					}
+
 					// Handle the disconnection error, use an delay of 0.25 seconds to ensure that
 					// an error handler is assigned before the error is dispatched
 					self.__handleDisconnect("Create session request failed (" + msg + ")");
 				}
-			}, null, self.Timeout, {
-				// This informs the server this is an session creation request, and they it should
-				// respond immedietly after ensuring we have an request.
-				"X-Organics-Req": Organics.__rtLongPollEstablishConnection
-			});
+			}, null, self.Timeout, headers);
 		};
 
 
+		self.__doConnect = doConnect;
+
 		if(!Organics.__hasAlreadyLoaded) {
 			if(document.readyState === 'complete') {
 				doConnect();
@@ -951,173 +1520,742 @@ var Organics = new function() {
 		}
 	}
 
-	this.Connection.prototype.__handleMessage = function(msg) {
+	// __closeTransport closes the WebSocket or event stream, after the server sent bad data.
+	this.Connection.prototype.__closeTransport = function() {
 		var self = this;
 
-		var doClose = function() {
-			if(Organics.webSocketSupported) {
-				self.__webSocket.close();
-			}
+		if(self.__method == Organics.WebSocket) {
+			self.__webSocket.close();
+		} else if(self.__method == Organics.ServerSentEvents) {
+			self.__eventSource.close();
 		}
+	}
 
-		if(msg.length > 0) {
+	// __handleMessage decodes and handles an single message, and returns the response to send
+	// back (if any).
+	this.Connection.prototype.__handleMessage = function(msg) {
+		var self = this;
+
+		// Binary frames (ArrayBuffer) are MessagePack encoded, text frames are JSON.
+		var binary = typeof msg != "string";
+		if((binary && msg.byteLength > 0) || (!binary && msg.length > 0)) {
 			// It's an request OR an response
 
-			// Firstly, it must be valid JSON data, so try to parse it as JSON first.
+			// Firstly, it must be valid data, so try to decode it first.
 			try{
-				var json = JSON.parse(msg);
+				if(binary) {
+					var json = Organics.__msgpack.decode(msg);
+				} else {
+					var json = JSON.parse(msg);
+				}
 			} catch(parseError) {
-				self.__handleDisconnect("Server sent bad JSON data: " + parseError);
-				doClose();
+				self.__handleDisconnect("Server sent bad data: " + parseError);
+				self.__closeTransport();
 				return;
 			}
+			return self.__handleFrame(json);
 
-			// If we made it this far, it's at least JSON. Now check if it's an array, it must be.
-			if(json.length == 3) {
-				// It's an request: [id, requestName, args]
-				var id = json[0];
-				var requestName = json[1];
-				var args = json[2];
+		} else {
+			// It's an ping, resumable connections acknowledge what they got along with it.
+			self.__sendAck();
+			if(self.__method == Organics.WebSocket) {
+				// For WebSockets, an ping is an empty message, and we send an empty message back
+				// to respond to their ping.
+				return "";
 
-				var responseArgs = null;
-				var fn = self.__handlers[requestName];
-				if(fn) {
+			} else {
+				// For long-polling, all we need to do is request again ASAP, to respond to their
+				// ping (which means just return here).
+				return;
+			}
+		}
+	}
+
+	// __handleFrame handles an single decoded message (an request OR an response), and returns
+	// the response to send back (if any).
+	this.Connection.prototype.__handleFrame = function(json) {
+		var self = this;
+
+		// Sequenced messages are unwrapped first, then handled just like any other frame.
+		if(json.length == 3 && json[0] === Organics.__ftSequenced) {
+			if(json[1] <= self.__received) {
+				// Sent again after an resume, but we already have it.
+				return;
+			}
+			self.__received = json[1];
+			if(self.__received - self.__receivedAcked >= Organics.__ackInterval) {
+				self.__sendAck();
+			}
+			json = json[2];
+		}
+
+		// The resumable connection frames, and those which are not an request or response, are
+		// handled right away.
+		if(json.length == 2 && json[0] === Organics.__ftAck) {
+			while(self.__sentBuffer.length > 0 && self.__sentBuffer[0][0] <= json[1]) {
+				self.__sentBuffer.shift();
+			}
+			return;
+
+		} else if(json.length == 3 && json[0] === Organics.__ftResume) {
+			self.__handleResume(json[1], json[2]);
+			return;
+
+		} else if(json.length == 2 && json[0] === Organics.__ftToken) {
+			// Our session key, in token mode (it changes when the session is regenerated).
+			self.__sessionToken = json[1];
+			return;
+
+		} else if(json.length == 2 && json[0] === Organics.__ftRekey) {
+			// Our session was regenerated, the ticket gets us the new session cookie.
+			self.__rekey(json[1]);
+			return;
+
+		} else if(json.length == 1 && json[0] === Organics.__ftGoingAway) {
+			// The server is shutting down, it disconnects us shortly, we shouldn't resume
+			// this connection but reconnect later on instead.
+			self.__logMessage("-> Server is going away");
+			self.__goingAway = true;
+			return;
+
+		} else if(json.length == 2 && json[0] === Organics.__ftCancel) {
+			// The server gave up on one of it's requests, our handlers respond right away, so
+			// there is nothing to cancel.
+			return;
+
+		} else if((json.length == 3 || json.length == 4) && json[0] === Organics.__ftValue) {
+			// It's the value of an store key we watch: ["v", scope, key, value], or
+			// ["v", scope, key] if there is no such key.
+			var watchers = self.__watchers[json[1]] && self.__watchers[json[1]][json[2]];
+			if(watchers) {
+				watchers = watchers.slice();
+				for(var i = 0; i < watchers.length; i++) {
 					try{
-						var responseArgs = fn.apply(undefined, args);
-						// Function could return null, if it does, it's "no args"
-						if(responseArgs == null) {
-							responseArgs = [];
-						}
+						watchers[i](json[3], json.length == 4);
 					} catch(e) {
-						Organics.__Log("Request handler exception:\n" + e);
-						return;
+						Organics.__Log("Store watcher exception:\n" + e);
 					}
-				} else {
-					Organics.__Log("Ignoring request \"" + requestName + "\", no handler.");
-					return
 				}
+			}
+			return;
 
-				if(id !== -1) {
-					try{
-						return JSON.stringify([id, responseArgs]);
-					} catch(e) {
-						Organics.__Log("Error encoding response:\n" + e);
-						return;
-					}
+		} else if(json.length == 3 && json[0] === Organics.__ftProgress) {
+			// It's an partial result of one of our requests: ["p", id, args]
+			var onProgress = self.__progressHandlers[json[1]];
+			if(onProgress) {
+				try{
+					onProgress.apply(undefined, json[2]);
+				} catch(e) {
+					Organics.__Log("Request handler onProgress exception:\n" + e);
 				}
+			}
+			return;
+		}
 
-			} else if(json.length == 2 || json.length == 1) {
-				// It's an response: [id, args], or [id]
-				var id = json[0];
-				if(json.length == 1) {
-					var args = [];
-				} else {
-					var args = json[1];
+		// If we made it this far, it's at least JSON. Now check if it's an array, it must be.
+		if(json.length == 3 && json[0] === Organics.__ftError) {
+			// It's an error response: ["e", id, {code, message, data}]
+			var id = json[1];
+			var err = new Organics.Error(json[2].code, json[2].message, json[2].data);
+
+			var onComplete = self.__requestHandlers[id];
+			delete self.__requestHandlers[id];
+			delete self.__progressHandlers[id];
+			if(onComplete) {
+				try{
+					onComplete(err);
+				} catch(e) {
+					Organics.__Log("Request handler onComplete exception:\n" + e);
+					return;
 				}
+			} else {
+				Organics.__Log("Got invalid error response; id is invalid; ignored.")
+				return
+			}
 
-				var onComplete = self.__requestHandlers[id];
-				if(onComplete) {
-					try{
-						onComplete.apply(undefined, args);
-					} catch(e) {
-						Organics.__Log("Request handler onComplete exception:\n" + e);
-						return;
+		} else if(json.length == 3) {
+			// It's an request: [id, requestName, args]
+			var id = json[0];
+			var requestName = json[1];
+			var args = json[2];
+
+			var responseArgs = null;
+			var responseErr = null;
+			var fn = self.__handlers[requestName];
+			if(fn) {
+				try{
+					var responseArgs = fn.apply(undefined, args);
+					// Function could return null, if it does, it's "no args"
+					if(responseArgs == null) {
+						responseArgs = [];
+					}
+				} catch(e) {
+					Organics.__Log("Request handler exception:\n" + e);
+					if(e instanceof Organics.Error) {
+						responseErr = e;
+					} else {
+						responseErr = new Organics.Error(Organics.CodeHandlerError, "" + e);
 					}
-				} else {
-					Organics.__Log("Got invalid response; id is invalid; ignored.")
-					return
 				}
-
 			} else {
-				self.__handleDisconnect("Server sent bad JSON data: Must be array of length 3");
-				doClose();
-				return;
+				Organics.__Log("Ignoring request \"" + requestName + "\", no handler.");
+				responseErr = new Organics.Error(Organics.CodeNoHandler, "no handler for request " + requestName);
 			}
 
-		} else {
-			// It's an ping
-			if(Organics.WebSocketSupported) {
-				// For WebSockets, an ping is an empty message, and we send an empty message back
-				// to respond to their ping.
-				return "";
+			if(id !== -1) {
+				if(responseErr != null) {
+					return [Organics.__ftError, id, {
+						code: responseErr.Code,
+						message: responseErr.Message,
+						data: responseErr.Data
+					}];
+				}
+				return [id, responseArgs];
+			}
 
+		} else if(json.length == 2 || json.length == 1) {
+			// It's an response: [id, args], or [id]
+			var id = json[0];
+			if(json.length == 1) {
+				var args = [];
 			} else {
-				// For long-polling, all we need to do is request again ASAP, to respond to their
-				// ping (which means just return here).
-				return;
+				var args = json[1];
+			}
+
+			var onComplete = self.__requestHandlers[id];
+			delete self.__requestHandlers[id];
+			delete self.__progressHandlers[id];
+			if(onComplete) {
+				try{
+					onComplete.apply(undefined, args);
+				} catch(e) {
+					Organics.__Log("Request handler onComplete exception:\n" + e);
+					return;
+				}
+			} else {
+				Organics.__Log("Got invalid response; id is invalid; ignored.")
+				return
 			}
+
+		} else {
+			self.__handleDisconnect("Server sent bad data: Must be array of length 3");
+			self.__closeTransport();
+			return;
+		}
+	}
+
+	// __fallback switches to the next connection method after we failed to connect using the
+	// current one, it returns false if there is no other method left to try.
+	this.Connection.prototype.__fallback = function() {
+		var self = this;
+
+		if(self.__method == Organics.WebSocket && self.__serverSentEventsUsable()) {
+			self.__method = Organics.ServerSentEvents;
+		} else if(self.__method != Organics.LongPolling) {
+			self.__method = Organics.LongPolling;
+		} else {
+			return false;
 		}
+		self.__logMessage("Falling back to " + self.__method);
+		return true;
+	}
+
+	// __serverSentEventsUsable tells weather we may connect using server-sent events, which cannot
+	// carry the session key in token mode, nor the bearer token.
+	this.Connection.prototype.__serverSentEventsUsable = function() {
+		return Organics.ServerSentEventsSupported && !this.SessionTokens && !this.__bearerToken;
 	}
 
-	this.Connection.prototype.__connectWebSocket = function() {
+	this.Connection.prototype.__connectWebSocket = function(doConnect, generation) {
 		var self = this;
 
+		// We offer the codecs we support as subprotocols, the server chooses one of them.
+		var protocols = [Organics.__codecProtocolPrefix + Organics.__json.Name];
+		if(Organics.MessagePackSupported) {
+			protocols.unshift(Organics.__codecProtocolPrefix + Organics.__msgpack.Name);
+		}
+
+		// WebSockets cannot carry headers, so in token mode the session key is offered as an
+		// subprotocol, hex encoded (subprotocols may not contain all the characters it may).
+		if(self.SessionTokens) {
+			if(self.__sessionToken) {
+				protocols.push(Organics.__spSession + "." + Organics.__hexEncode(self.__sessionToken));
+			} else {
+				protocols.push(Organics.__spSession);
+			}
+		}
+		if(self.__bearerToken) {
+			protocols.push(Organics.__spBearer + "." + Organics.__hexEncode(self.__bearerToken));
+		}
+
+		var url = self.__connectURL(self.__WS_URL);
 		if(window.WebSocket) {
-			self.__webSocket = new WebSocket(self.__URL);
+			self.__webSocket = new WebSocket(url, protocols);
 		} else if(window.MozWebSocket) {
-			self.__webSocket = new MozWebSocket(self.__URL);
+			self.__webSocket = new MozWebSocket(url, protocols);
 		}
+		self.__webSocket.binaryType = "arraybuffer";
 
 		self.__webSocket.onopen = function(evt) {
-			self.__connected = true;
-			self.__connecting = false;
-			self.__handleConnect();
+			if(generation != self.__generation) {
+				return;
+			}
+			if(self.__webSocket.protocol == Organics.__codecProtocolPrefix + Organics.__msgpack.Name) {
+				self.__codec = Organics.__msgpack;
+			}
+			self.__handleOpen();
 		}
 		self.__webSocket.onclose = function(evt) {
+			if(generation != self.__generation) {
+				return;
+			} else if(self.__resuming) {
+				self.__retryResume();
+				return;
+			} else if(self.__connecting && self.__fallback()) {
+				// We never got connected, so try the next method instead.
+				doConnect();
+				return;
+			} else if(self.__tryResume()) {
+				return;
+			}
 			self.__handleDisconnect("connection closed");
 		}
 
 		self.__webSocket.onmessage = function(evt) {
+			if(generation != self.__generation) {
+				return;
+			}
 			var response = self.__handleMessage(evt.data);
 			if(response != null) {
-				self.__webSocket.send(response);
+				self.__reply(response);
 			}
 		}
 		self.__webSocket.onerror = function(evt) {
+			if(generation != self.__generation || self.__connecting || self.__resuming || self.Resume) {
+				// An onclose event follows, which falls back to the next method, or resumes.
+				return;
+			}
 			self.__handleDisconnect("disconnected" + evt);
 		}
 	}
 
-	this.Connection.prototype.__doLongPolling = function() {
+	this.Connection.prototype.__connectEventSource = function(doConnect, generation) {
 		var self = this;
 
+		// The session cookie must be sent along, even to an different origin. An EventSource cannot
+		// carry headers, so the codecs we support are offered in the URL.
+		var url = self.__connectURL(self.__HTTP_URL);
+		url += ((/\?/).test(url) ? "&" : "?") + Organics.__qpCodec + "=" + encodeURIComponent(Organics.__offeredCodecs());
+		var eventSource = new EventSource(url, {withCredentials: true});
+		self.__eventSource = eventSource;
+
+		// The first event carries our connection id, which we send back through the
+		// X-Organics-Conn header of our messages, just like long-polling does, and on it's second
+		// line the codec which the server chose.
+		eventSource.addEventListener(Organics.__seConnect, function(evt) {
+			if(generation != self.__generation) {
+				return;
+			}
+			var lines = evt.data.split("\n");
+			self.__connectionId = lines[0];
+			self.__codec = Organics.__codecNamed(lines[1]);
+			self.__handleOpen();
+		}, false);
+
+		// For event streams, we answer an ping with an empty message.
+		eventSource.addEventListener(Organics.__sePing, function(evt) {
+			if(generation != self.__generation) {
+				return;
+			}
+			self.__sendAck();
+			self.__postMessage("");
+		}, false);
+
+		eventSource.onmessage = function(evt) {
+			if(generation != self.__generation) {
+				return;
+			}
+
+			// Event data is text, so binary messages are base64 encoded.
+			var data = evt.data;
+			if(self.__codec == Organics.__msgpack) {
+				try{
+					data = Organics.__base64Decode(data);
+				} catch(parseError) {
+					self.__handleDisconnect("Server sent bad data: " + parseError);
+					self.__closeTransport();
+					return;
+				}
+			}
+			var response = self.__handleMessage(data);
+			if(response != null) {
+				self.__reply(response);
+			}
+		}
+
+		// An EventSource would reconnect by itself, but the server sees that as an new
+		// connection, so we close it instead.
+		eventSource.onerror = function(evt) {
+			eventSource.close();
+			if(generation != self.__generation) {
+				return;
+			} else if(self.__resuming) {
+				self.__retryResume();
+				return;
+			} else if(self.__connecting && self.__fallback()) {
+				// We never got connected, so try the next method instead.
+				doConnect();
+				return;
+			} else if(self.__tryResume()) {
+				return;
+			}
+			self.__handleDisconnect("event stream closed");
+		}
+	}
+
+	// __postMessage sends an message to the server using an POST request, which is how both
+	// long-polling and server-sent events connections send messages.
+	//
+	// Only one POST request is made at an time, messages sent meanwhile are queued and sent
+	// together in the next one, in order.
+	this.Connection.prototype.__postMessage = function(data) {
+		var self = this;
+
+		if(self.__postGeneration === self.__generation) {
+			self.__postQueue.push(data);
+			return;
+		}
+		self.__postQueue = [];
+		self.__doPost([data]);
+	}
+
+	// __doPost makes an POST request carrying the messages, as an JSON array of messages unless
+	// there is just one. For MessagePack they are joined using __joinBatch instead, even if there
+	// is just one.
+	this.Connection.prototype.__doPost = function(messages) {
+		var self = this;
+
+		// An empty message (an ping response) is only sent on it's own, any other message
+		// responds to the ping just the same.
+		var nonEmpty = [];
+		for(var i = 0; i < messages.length; i++) {
+			if(messages[i] !== "") {
+				nonEmpty.push(messages[i]);
+			}
+		}
+		var data = "";
+		var headers = self.__headers({
+			"X-Organics-Req": Organics.__rtMessage,
+			"X-Organics-Conn": self.__connectionId
+		});
+		if(nonEmpty.length > 0 && self.__codec == Organics.__msgpack) {
+			data = Organics.__joinBatch(nonEmpty);
+			headers["Content-Type"] = "application/octet-stream";
+		} else if(nonEmpty.length == 1) {
+			data = nonEmpty[0];
+		} else if(nonEmpty.length > 1) {
+			data = "[" + nonEmpty.join(",") + "]";
+		}
+
+		var generation = self.__generation;
+		self.__postGeneration = generation;
+		Organics.__ajax(self.__HTTP_URL, "POST", {
+			complete: function(xhr) {
+				if(generation != self.__generation) {
+					return;
+				}
+				self.__postGeneration = null;
+				if(self.__postQueue.length > 0) {
+					var queued = self.__postQueue;
+					self.__postQueue = [];
+					self.__doPost(queued);
+				}
+			},
+
+			// If we are unable to POST data to the server; then this means either the server
+			// had an internal error, OR we where disconnected somehow.
+			//
+			// The best thing to do in this situation is to say we where disconnected, because
+			// in either of those two cases, our session state could be invalidated. Resumable
+			// connections still have the data, and send it again once resumed.
+			error: function(xhr, msg) {
+				if(generation != self.__generation) {
+					return;
+				}
+				self.__postGeneration = null;
+				self.__postQueue = [];
+				if(xhr && xhr.status == 413) {
+					// Data too large
+					self.__handleDisconnect("JSON request data exceeded server's MaxBufferSize property.", 0);
+					return;
+				} else if(!self.__tryResume()) {
+					self.__handleDisconnect("POST request failed (" + msg + ")");
+				}
+			}
+		}, data, self.Timeout, headers);
+	}
+
+	this.Connection.prototype.__doLongPolling = function(generation) {
+		var self = this;
+
+		// MessagePack batches are binary.
+		var binary = self.__codec == Organics.__msgpack;
 		Organics.__ajax(self.__HTTP_URL, "POST", {
 			complete: function(xhr) {
-				// Handle the data
-				var response = self.__handleMessage(xhr.responseText);
-				if(response != null) {
-					Organics.__ajax(self.__HTTP_URL, "POST", {
-						complete: function(xhr) {
-						},
-						error: function(xhr, msg) {
-							self.__handleDisconnect("POST request failed (" + msg + ")", 0);
+				if(generation != self.__generation) {
+					return;
+				}
+
+				// An empty response is an ping, anything else is an JSON array of every message the
+				// server had queued for us (or for MessagePack, those messages joined using
+				// __joinBatch).
+				var empty = binary ? xhr.response.byteLength == 0 : xhr.responseText.length == 0;
+				if(empty) {
+					self.__handleMessage("");
+				} else {
+					try{
+						if(binary) {
+							var batch = Organics.__splitBatch(xhr.response);
+							for(var i = 0; i < batch.length; i++) {
+								batch[i] = Organics.__msgpack.decode(batch[i]);
+							}
+						} else {
+							var batch = JSON.parse(xhr.responseText);
 						}
-					}, response, null, {
-						"X-Organics-Req": Organics.__rtMessage,
-						"X-Organics-Conn": self.__connectionId
-					});
+					} catch(parseError) {
+						self.__handleDisconnect("Server sent bad data: " + parseError);
+						return;
+					}
+					for(var i = 0; i < batch.length && generation == self.__generation; i++) {
+						var response = self.__handleFrame(batch[i]);
+						if(response != null) {
+							self.__reply(response);
+						}
+					}
 				}
 
 				// Go back to long-polling again
 				setTimeout(function() {
-					self.__doLongPolling();
+					self.__doLongPolling(generation);
 				}, 0);
 			},
 
 			error: function(xhr, msg) {
+				if(generation != self.__generation || self.__tryResume()) {
+					return;
+				}
 				self.__handleDisconnect("long-polling request failed (" + msg + ")", 0);
 			}
-		}, null, null, {
+		}, null, null, self.__headers({
 			"X-Organics-Req": Organics.__rtLongPoll,
 			"X-Organics-Conn": self.__connectionId
-		});
+		}), binary ? "arraybuffer" : null);
+	}
+
+	// __rekey asks the server for the new session cookie, once our session was regenerated, using
+	// the ticket it sent us.
+	this.Connection.prototype.__rekey = function(ticket) {
+		var self = this;
+
+		self.__logMessage("-> Session regenerated, renewing session cookie");
+		Organics.__ajax(self.__HTTP_URL, "POST", {
+			"complete": function(xhr) {
+				self.__logMessage("-> Session cookie renewed");
+			},
+			"error": function(xhr, error) {
+				self.__logMessage("-> Failed to renew session cookie: " + error);
+			}
+		}, null, self.Timeout, self.__headers({
+			"X-Organics-Req": Organics.__rtRekey,
+			"X-Organics-Ticket": ticket
+		}));
+	}
+
+	// __headers adds the headers which every long-polling request carries to the headers given,
+	// and returns them.
+	this.Connection.prototype.__headers = function(headers) {
+		var self = this;
+
+		if(self.SessionTokens) {
+			// An empty session key asks the server for an new one.
+			headers[Organics.__hdrSession] = self.__sessionToken || "";
+		}
+		if(self.__bearerToken) {
+			headers["Authorization"] = "Bearer " + self.__bearerToken;
+		}
+		return headers;
+	}
+
+	// __connectURL returns the URL to connect to, which for resumable connections tells the server
+	// which connection to resume (if any) and how far we got.
+	this.Connection.prototype.__connectURL = function(url) {
+		var self = this;
+
+		if(!self.Resume) {
+			return url;
+		}
+		var key = self.__resumeKey;
+		if(key == null) {
+			key = "";
+		}
+		var query = "organics-resume=" + encodeURIComponent(key) + "&organics-ack=" + self.__received;
+		return url + ((/\?/).test(url) ? "&" : "?") + query;
+	}
+
+	// __sendEncoded sends an encoded frame to the server, using whichever method is in use.
+	this.Connection.prototype.__sendEncoded = function(encoded) {
+		var self = this;
+
+		if(self.__method == Organics.WebSocket) {
+			self.__webSocket.send(encoded);
+		} else {
+			self.__postMessage(encoded);
+		}
+	}
+
+	// __sendFrame encodes the frame (an array) and sends it to the server, an empty string is an
+	// ping response and is sent as-is.
+	//
+	// An exception is thrown if the frame cannot be encoded.
+	this.Connection.prototype.__sendFrame = function(frame) {
+		var self = this;
+
+		if(frame === "") {
+			self.__sendEncoded(frame);
+			return;
+		}
+		self.__sendEncoded(self.__codec.encode(frame));
+	}
+
+	// __send sends an request or response to the server, resumable connections keep it around
+	// (sending it again after an resume) until the server acknowledges it.
+	//
+	// An exception is thrown if the message cannot be encoded.
+	this.Connection.prototype.__send = function(msg) {
+		var self = this;
+
+		if(!self.Resume) {
+			self.__sendFrame(msg);
+			return;
+		}
+
+		// Encoded first, so that an message which cannot be encoded is not sequenced.
+		var seq = self.__sent + 1;
+		var frame = [Organics.__ftSequenced, seq, msg];
+		var encoded = self.__codec.encode(frame);
+		self.__sent = seq;
+		self.__sentBuffer.push([seq, frame]);
+		if(!self.__resuming) {
+			self.__sendEncoded(encoded);
+		}
+	}
+
+	// __reply sends the response returned by __handleMessage, an empty one answers an ping.
+	this.Connection.prototype.__reply = function(response) {
+		var self = this;
+
+		if(response === "") {
+			self.__sendFrame(response);
+			return;
+		}
+		try{
+			self.__send(response);
+		} catch(e) {
+			Organics.__Log("Error encoding response:\n" + e);
+		}
+	}
+
+	// __sendAck acknowledges the messages received since the last acknowledgement, if any.
+	this.Connection.prototype.__sendAck = function() {
+		var self = this;
+
+		if(!self.Resume || self.__resuming || self.__received == self.__receivedAcked) {
+			return;
+		}
+		self.__receivedAcked = self.__received;
+		self.__sendFrame([Organics.__ftAck, self.__received]);
+	}
+
+	// __tryResume starts resuming the connection after it's transport failed, it returns false if
+	// the connection is not resumable, in which case it should be disconnected instead.
+	this.Connection.prototype.__tryResume = function() {
+		var self = this;
+
+		if(!self.Resume || self.__resumeKey == null || !self.__connected || self.__goingAway || Organics.__isPageBeingRefreshed) {
+			return false;
+		}
+		if(!self.__resuming) {
+			self.__logMessage("-> Resuming");
+			self.__resuming = true;
+			self.__resumeAttempts = 0;
+			self.__retryResume();
+		}
+		return true;
+	}
+
+	// __retryResume makes the next attempt at resuming the connection, or disconnects if there
+	// have been too many attempts already.
+	this.Connection.prototype.__retryResume = function() {
+		var self = this;
+
+		self.__generation++;
+		self.__resumeAttempts++;
+		if(self.__resumeAttempts > Organics.ResumeAttempts) {
+			self.__handleDisconnect("unable to resume connection");
+			return;
+		}
+		var generation = self.__generation;
+		setTimeout(function() {
+			if(generation == self.__generation) {
+				self.__doConnect();
+			}
+		}, Organics.ResumeDelay);
+	}
+
+	// __handleResume handles the resume information which the server sends first on each
+	// transport of an resumable connection; key is the connection's key and received is the
+	// sequence number of the last message the server received from us.
+	this.Connection.prototype.__handleResume = function(key, received) {
+		var self = this;
+
+		if(self.__resuming && key === self.__resumeKey) {
+			// Send again whatever the server did not get.
+			self.__resuming = false;
+			self.__logMessage("-> Resumed");
+			while(self.__sentBuffer.length > 0 && self.__sentBuffer[0][0] <= received) {
+				self.__sentBuffer.shift();
+			}
+			for(var i = 0; i < self.__sentBuffer.length; i++) {
+				self.__sendFrame(self.__sentBuffer[i][1]);
+			}
+			return;
+		}
+
+		var lost = self.__resumeKey != null;
+		self.__resumeKey = key;
+		if(lost) {
+			// The server no longer has our connection, and gave us an new one instead.
+			self.__resuming = false;
+			self.__resetSequence();
+			self.__requestHandlers = {};
+			self.__progressHandlers = {};
+
+			var err = "connection could not be resumed";
+			self.__logMessage("-> Disconnected: \"" + err + "\"");
+			var fn = self.__handlers[Organics.Disconnect];
+			if(fn) {
+				fn(err)
+			}
+			self.__handleConnect();
+		}
 	}
 
 	// Request makes an request to the server, using jsonData, and (optionally) calling the
 	// OnComplete function parameter.
 	//
 	// This function fails if the jsonData parameter is invalid JSON data for the global
-	// JSON.stringify function (provided by json2.js), in which case an exception is thrown.
+	// JSON.stringify function (provided by json2.js), or cannot be encoded using the codec in use,
+	// in which case an exception is thrown.
 	//
 	// This function fails if this Connection is currently not connected; in which case
 	// an Organics.ErrNotConnected exception is thrown.
@@ -1125,6 +2263,15 @@ var Organics = new function() {
 	// This function fails if the (optional) OnComplete parameter is not an function, and an
 	// TypeError exception is thrown.
 	//
+	// If the request fails on the server (the handler returned an error, panicked, or does not
+	// exist) then OnComplete is given an Organics.Error object as it's only argument.
+	//
+	// If the parameter before OnComplete is an function as well, it is the OnProgress function,
+	// which is called with each partial result the server sends before the response (see
+	// organics.Progress), in order.
+	//
+	// If an OnComplete function is given, the request's id is returned, which Cancel() takes.
+	//
 	this.Connection.prototype.Request = function() {
 		var self = this;
 
@@ -1151,6 +2298,11 @@ var Organics = new function() {
 			var sequence = args.slice(1, args.length);
 		}
 
+		var onProgress = null;
+		if(onComplete != null && sequence.length > 0 && typeof sequence[sequence.length - 1] == "function") {
+			onProgress = sequence.pop();
+		}
+
 		if(!self.Connected()) {
 			self.__logMessage("-> Ignoring Request() call (Not connected)");
 			throw Organics.ErrNotConnected;
@@ -1166,37 +2318,118 @@ var Organics = new function() {
 		var id = self.__requestCounter;
 		if(onComplete) {
 			self.__requestHandlers[self.__requestCounter] = onComplete;
+			if(onProgress) {
+				self.__progressHandlers[self.__requestCounter] = onProgress;
+			}
 		} else {
 			id = -1; // Never respond to this request, please.
 		}
-		var encoded = JSON.stringify([id, requestName, sequence])
+		self.__send([id, requestName, sequence]);
+		if(id !== -1) {
+			return id;
+		}
+	}
 
-		if(Organics.WebSocketSupported) {
-			self.__webSocket.send(encoded);
+	// Cancel cancels the request with the id returned by Request(), if it has not completed
+	// yet: it's OnComplete (and OnProgress) function is never called, and the server cancels
+	// it's handler's context. It returns weather the request was cancelled.
+	this.Connection.prototype.Cancel = function(id) {
+		var self = this;
 
-		} else {
-			Organics.__ajax(self.__HTTP_URL, "POST", {
-				complete: function(xhr) {
-				},
+		if(!self.__requestHandlers.hasOwnProperty(id)) {
+			return false;
+		}
+		delete self.__requestHandlers[id];
+		delete self.__progressHandlers[id];
+		if(self.Connected()) {
+			self.__send([Organics.__ftCancel, id]);
+		}
+		return true;
+	}
 
-				// If we are unable to POST data to the server; then this means either the server
-				// had an internal error, OR we where disconnected somehow.
-				//
-				// The best thing to do in this situation is to say we where disconnected, because
-				// in either of those two cases, our session state could be invalidated.
-				error: function(xhr, msg) {
-					if(xhr && xhr.status == 413) {
-						// Data too large
-						self.__handleDisconnect("JSON request data exceeded server's MaxBufferSize property.", 0);
-						return;
-					} else {
-						self.__handleDisconnect("Request failed: " + msg);
-					}
-				}
-			}, encoded, self.Timeout, {
-				"X-Organics-Req": Organics.__rtMessage,
-				"X-Organics-Conn": self.__connectionId
-			});
+	// Watch calls the OnChange function with the value of the store key, as soon as the server
+	// sends it and again each time it changes on the server, for as long as it is watched. The
+	// scope is either Organics.SessionStore or Organics.ConnectionStore.
+	//
+	// OnChange is given the value and weather the store has the key at all, e.g.:
+	//
+	//  connection.Watch(Organics.SessionStore, "cart", function(value, exists) {
+	//      ...
+	//  });
+	//
+	// The server only sends keys it exposes (see Server.SetStoreAccess), others are never sent.
+	//
+	// This function fails if the OnChange parameter is not an function, and an TypeError
+	// exception is thrown.
+	//
+	this.Connection.prototype.Watch = function(scope, key, onChange) {
+		var self = this;
+
+		if(typeof onChange !== "function") {
+			throw TypeError("Watch() parameter \"onChange\" must be function!");
+		}
+		var watchers = self.__watchers[scope];
+		if(!watchers) {
+			throw TypeError("Watch() parameter \"scope\" must be Organics.SessionStore or Organics.ConnectionStore!");
+		}
+
+		if(watchers.hasOwnProperty(key)) {
+			watchers[key].push(onChange);
+			return;
+		}
+		watchers[key] = [onChange];
+		if(self.Connected()) {
+			self.__send([Organics.__ftWatch, scope, key]);
+		}
+	}
+
+	// Unwatch stops calling the OnChange function given to Watch() for the store key, it returns
+	// weather it was watching the key.
+	this.Connection.prototype.Unwatch = function(scope, key, onChange) {
+		var self = this;
+
+		var watchers = self.__watchers[scope];
+		if(!watchers || !watchers.hasOwnProperty(key)) {
+			return false;
+		}
+		var i = watchers[key].indexOf(onChange);
+		if(i == -1) {
+			return false;
+		}
+		watchers[key].splice(i, 1);
+		if(watchers[key].length == 0) {
+			delete watchers[key];
+			if(self.Connected()) {
+				self.__send([Organics.__ftUnwatch, scope, key]);
+			}
+		}
+		return true;
+	}
+
+	// SessionToken returns our session key in token mode (see the SessionTokens parameter of
+	// Connection), or null if the server did not give us one (yet). The page may store it, and
+	// give it to SetSessionToken() later on (e.g. once it is loaded again) to keep the session.
+	this.Connection.prototype.SessionToken = function() {
+		return this.__sessionToken;
+	}
+
+	// SetSessionToken sets our session key in token mode, it is used from the next connection
+	// attempt on.
+	this.Connection.prototype.SetSessionToken = function(token) {
+		this.__sessionToken = token;
+	}
+
+	// SetBearerToken sets the bearer token which we authenticate with, for servers which use an
+	// bearer token authenticator (see organics.BearerToken on the server), it is used from the next
+	// connection attempt on. An null token sends none.
+	//
+	// Server-sent events cannot carry the bearer token, they are never used when there is one.
+	this.Connection.prototype.SetBearerToken = function(token) {
+		var self = this;
+
+		self.__bearerToken = token;
+		if(token && self.__method == Organics.ServerSentEvents) {
+			self.__method = Organics.LongPolling;
 		}
 	}
 
@@ -1,26 +1,213 @@
-"object"!==typeof JSON&&(JSON={});
-(function(){function h(a){return 10>a?"0"+a:a}function a(a){b.lastIndex=0;return b.test(a)?'"'+a.replace(b,function(a){var b=d[a];return"string"===typeof b?b:"\\u"+("0000"+a.charCodeAt(0).toString(16)).slice(-4)})+'"':'"'+a+'"'}function c(b,e){var d,p,n,h,q=f,m,g=e[b];g&&("object"===typeof g&&"function"===typeof g.toJSON)&&(g=g.toJSON(b));"function"===typeof l&&(g=l.call(e,b,g));switch(typeof g){case "string":return a(g);case "number":return isFinite(g)?String(g):"null";case "boolean":case "null":return String(g);
-case "object":if(!g)return"null";f+=k;m=[];if("[object Array]"===Object.prototype.toString.apply(g)){h=g.length;for(d=0;d<h;d+=1)m[d]=c(d,g)||"null";n=0===m.length?"[]":f?"[\n"+f+m.join(",\n"+f)+"\n"+q+"]":"["+m.join(",")+"]";f=q;return n}if(l&&"object"===typeof l)for(h=l.length,d=0;d<h;d+=1)"string"===typeof l[d]&&(p=l[d],(n=c(p,g))&&m.push(a(p)+(f?": ":":")+n));else for(p in g)Object.prototype.hasOwnProperty.call(g,p)&&(n=c(p,g))&&m.push(a(p)+(f?": ":":")+n);n=0===m.length?"{}":f?"{\n"+f+m.join(",\n"+
-f)+"\n"+q+"}":"{"+m.join(",")+"}";f=q;return n}}"function"!==typeof Date.prototype.toJSON&&(Date.prototype.toJSON=function(a){return isFinite(this.valueOf())?this.getUTCFullYear()+"-"+h(this.getUTCMonth()+1)+"-"+h(this.getUTCDate())+"T"+h(this.getUTCHours())+":"+h(this.getUTCMinutes())+":"+h(this.getUTCSeconds())+"Z":null},String.prototype.toJSON=Number.prototype.toJSON=Boolean.prototype.toJSON=function(a){return this.valueOf()});var e=/[\u0000\u00ad\u0600-\u0604\u070f\u17b4\u17b5\u200c-\u200f\u2028-\u202f\u2060-\u206f\ufeff\ufff0-\uffff]/g,
-b=/[\\\"\x00-\x1f\x7f-\x9f\u00ad\u0600-\u0604\u070f\u17b4\u17b5\u200c-\u200f\u2028-\u202f\u2060-\u206f\ufeff\ufff0-\uffff]/g,f,k,d={"\b":"\\b","\t":"\\t","\n":"\\n","\f":"\\f","\r":"\\r",'"':'\\"',"\\":"\\\\"},l;"function"!==typeof JSON.stringify&&(JSON.stringify=function(a,b,d){var e;k=f="";if("number"===typeof d)for(e=0;e<d;e+=1)k+=" ";else"string"===typeof d&&(k=d);if((l=b)&&"function"!==typeof b&&("object"!==typeof b||"number"!==typeof b.length))throw Error("JSON.stringify");return c("",{"":a})});
-"function"!==typeof JSON.parse&&(JSON.parse=function(a,b){function c(a,d){var e,f,g=a[d];if(g&&"object"===typeof g)for(e in g)Object.prototype.hasOwnProperty.call(g,e)&&(f=c(g,e),void 0!==f?g[e]=f:delete g[e]);return b.call(a,d,g)}var d;a=String(a);e.lastIndex=0;e.test(a)&&(a=a.replace(e,function(a){return"\\u"+("0000"+a.charCodeAt(0).toString(16)).slice(-4)}));if(/^[\],:{}\s]*$/.test(a.replace(/\\(?:["\\\/bfnrt]|u[0-9a-fA-F]{4})/g,"@").replace(/"[^"\\\n\r]*"|true|false|null|-?\d+(?:\.\d*)?(?:[eE][+\-]?\d+)?/g,
-"]").replace(/(?:^|:|,)(?:\s*\[)+/g,"")))return d=eval("("+a+")"),"function"===typeof b?c({"":d},""):d;throw new SyntaxError("JSON.parse");})})();
-var Organics=new function(){this.Debug=!1;this.WebSocketSupported="WebSocket"in window||"MozWebSocket"in window;this.Connect="A!B@C#D$E%F^G&H*I(J)";this.Disconnect="a1b2c3d4e5f6g7h8i9j0";this.__rtLongPollEstablishConnection="lpec";this.__rtLongPoll="lp";this.__rtMessage="m";this.ErrNotConnected="not currently connected to server";this.__addEventListener=function(a,c,e){document.addEventListener?a.addEventListener(c,e,!1):a.attachEvent("on"+c,e)};this.__isPageBeingRefreshed=!1;this.__addEventListener(window,
-"beforeunload",function(){Organics.__isPageBeingRefreshed=!0});this.__Log=function(a){Organics.Debug&&window.console&&console.log("Organics: "+a)};if(this.WebSocketSupported){var h=null,h="WebSocket"in window?window.WebSocket:window.MozWebSocket;2>=h.CLOSED&&(this.WebSocketSupported=!1,window.console&&console.log("Organics: Browser only supports < hybi 07 WebSockets; falling back to long polling."))}this.__StackTrace=function(){return Error("StackTrace").stack.replace(/^[^\(]+?[\n$]/gm,"").replace(/^\s+at\s+/gm,
-"").replace(/^Object.<anonymous>\s*\(/gm,"{anonymous}()@").split("\n")};this.__LogStackTrace=function(){if(Organics.Debug){for(var a=Organics.__StackTrace(),c=0;c<a.length;c++)window.console&&console.log(a[c]);window.console&&console.log("\n")}};this.__StringStartsWith=function(a,c){return a.slice(0,c.length)==c};this.__StringEndsWith=function(a,c){return a.slice(-c.length)==c};this.__xhr=function(){if(window.XMLHttpRequest)return new XMLHttpRequest;if(window.createRequest)return window.createRequest();
-if(window.ActiveXObject)for(var a=["Msxml3.XMLHTTP","Msxml2.XMLHTTP.6.0","Msxml2.XMLHTTP.3.0","Msxml2.XMLHTTP","Microsoft.XMLHTTP"],c=0;c<a.length;c++)try{return new ActiveXObject(a[c])}catch(e){}};this.__translateWindowsError=function(a){switch(a){case 12001:return"Internet handle could not be generated at this time.";case 12002:return"Request timed out.";case 12004:return"An internal internet error has occured.";case 12005:return"URL is invalid.";case 12006:return"URL scheme could not be recognized or is not supported.";
-case 12007:return"Server name could not be resolved.";case 12008:return"The requested protocol could not be found.";case 12013:return"Failed to log on to FTP server, user name is incorrect.";case 12014:return"Failed to log on to FTP server, password is incorrect.";case 12015:return"Failed to coonect and log on to FTP server.";case 12023:return"Direct network access cannot be made at this time.";case 12029:return"Unable to connect to server.";case 12030:return"Connection terminated.";case 12031:return"Connection reset.";
-case 12037:return"Date on server's SSL certificate is bad expired.";case 12038:return"Host name on server's SSL certificate is incorrect.";case 12040:return"Moving from non-SSL to SSL due to redirect.";case 12042:return"Attempt to post and change data on server that is not secure.";case 12043:return"Attempt to post data on server that is not secure.";case 12110:return"FTP operation failed, operation is already in progress.";case 12111:return"FTP operation failed, the session was aborted.";case 12150:return"Requested HTTP header could not be found.";
-case 12151:return"Server returned no HTTP headers.";case 12152:return"Server response could not be parsed.";case 12156:return"HTTP redirect failed, scheme changed or all attempts failed."}};this.__ajax=function(a,c,e,b,f,k){var d=new Organics.__xhr;if(d){var l=null;f&&(l=setTimeout(function(){d.abort();e.error(d,"timed out")},f));d.onreadystatechange=function(){if(4==d.readyState)if(l&&clearTimer(l),200==d.status)e.complete(d);else{var a=Organics.__translateWindowsError(d.status);Organics.__isPageBeingRefreshed&&
-0==d.status||(0==d.status?e.error(d,"network error"):null!=a?e.error(d,d.status+" "+a):e.error(d,d.status+" "+d.statusText))}};try{d.open(c,a,!0)}catch(h){e.error(d,"XMLHttpRequest.open failed: "+h);return}null==k&&(k={});null==k["Content-Type"]&&(k["Content-Type"]="text/plain;charset=UTF-8");if(k)for(var r in k)d.setRequestHeader(r,k[r]);try{d.send(b)}catch(t){e.error(d,"XMLHttpRequest.send failed: "+t)}}else e.error(null,"Browser has no support for AJAX")};this.Connection=function(a,c,e){var b=
-this;b.__requestCounter=-1;b.__requestHandlers={};b.__URL=a;if("string"!=typeof b.__URL)throw TypeError("URL parameter must be an string!");b.URL=b.__URL;b.TLS=c;null==b.TLS&&(b.TLS=!1);if("boolean"!=typeof b.TLS)throw TypeError("TLS optional parameter must be bool!");b.Timeout=e;null==b.Timeout&&(b.Timeout=15E3);b.Timeout=e;b.__handlers={};b.__connected=!1;b.__connecting=!1;Organics.__StringStartsWith(b.__URL,"/")&&(b.__URL=document.location.host+b.__URL);Organics.__StringStartsWith(b.__URL,"ws://")?
-b.__URL=b.__URL.slice(5):Organics.__StringStartsWith(b.__URL,"wss://")?b.__URL=b.__URL.slice(6):Organics.__StringStartsWith(b.__URL,"http://")?b.__URL=b.__URL.slice(7):Organics.__StringStartsWith(b.__URL,"https://")&&(b.__URL=b.__URL.slice(8));b.__HTTP_URL=b.TLS?"https://"+b.__URL:"http://"+b.__URL;b.__URL=Organics.WebSocketSupported?b.TLS?"wss://"+b.__URL:"ws://"+b.__URL:b.TLS?"https://"+b.__URL:"http://"+b.__URL;b.__logMessage=function(a){Organics.__Log("("+b.__URL+"): "+a)};b.__handleDisconnect=
-function(a,c){if(!0==b.__connected||!0==b.__connecting){b.__connected=!1;b.__connecting=!1;b.__logMessage('-> Disconnected: "'+a+'"');var d=b.__handlers[Organics.Disconnect];d&&d(a)}b.__connected=!1;b.__connecting=!1};b.__handleConnect=function(){b.__logMessage("-> Connected");var a=b.__handlers[Organics.Connect];a&&a()}};this.Connection.prototype.Connected=function(){return!0==this.__connecting?!1:this.__connected};this.Connection.prototype.Close=function(){!0==this.__connected&&(this.__connected=
-!1,Organics.WebSocketSupported&&this.__webSocket.close())};this.Connection.prototype.Connect=function(){var a=this;Organics.WebSocketSupported?a.__logMessage("WebSocket is supported"):a.__logMessage("No WebSocket support; using long polling");if(!a.__connected&&!a.__connecting){a.__logMessage("-> Connect()");a.__connecting=!0;var c=function(){Organics.WebSocketSupported?a.__connectWebSocket():Organics.__ajax(a.__HTTP_URL,"POST",{complete:function(c){a.__connectionId=c.responseText;a.__logMessage("-> Create session request successful: connected to server");
-a.__connected=!0;a.__connecting=!1;setTimeout(function(){a.__doLongPolling()},0);a.__handleConnect()},error:function(c,b){a.__handleDisconnect("Create session request failed ("+b+")")}},null,a.Timeout,{"X-Organics-Req":Organics.__rtLongPollEstablishConnection})};Organics.__hasAlreadyLoaded?c():((document.readyState=="complete"?c():Organics.__addEventListener(window,"load",c)),Organics.__hasAlreadyLoaded=!0)}};this.Connection.prototype.__handleMessage=function(a){if(0<a.length){try{var c=JSON.parse(a)}catch(e){this.__handleDisconnect("Server sent bad JSON data: "+
-e);Organics.webSocketSupported&&this.__webSocket.close();return}if(3==c.length){a=c[0];var b=c[1],c=c[2],f=null,k=this.__handlers[b];if(k){try{f=k.apply(void 0,c),null==f&&(f=[])}catch(d){Organics.__Log("Request handler exception:\n"+d);return}if(-1!==a)try{return JSON.stringify([a,f])}catch(h){Organics.__Log("Error encoding response:\n"+h)}}else Organics.__Log('Ignoring request "'+b+'", no handler.')}else if(2==c.length||1==c.length)if(a=c[0],c=1==c.length?[]:c[1],a=this.__requestHandlers[a])try{a.apply(void 0,
-c)}catch(s){Organics.__Log("Request handler onComplete exception:\n"+s)}else Organics.__Log("Got invalid response; id is invalid; ignored.");else this.__handleDisconnect("Server sent bad JSON data: Must be array of length 3"),Organics.webSocketSupported&&this.__webSocket.close()}else if(Organics.WebSocketSupported)return""};this.Connection.prototype.__connectWebSocket=function(){var a=this;window.WebSocket?a.__webSocket=new WebSocket(a.__URL):window.MozWebSocket&&(a.__webSocket=new MozWebSocket(a.__URL));
-a.__webSocket.onopen=function(c){a.__connected=!0;a.__connecting=!1;a.__handleConnect()};a.__webSocket.onclose=function(c){a.__handleDisconnect("connection closed")};a.__webSocket.onmessage=function(c){c=a.__handleMessage(c.data);null!=c&&a.__webSocket.send(c)};a.__webSocket.onerror=function(c){a.__handleDisconnect("disconnected"+c)}};this.Connection.prototype.__doLongPolling=function(){var a=this;Organics.__ajax(a.__HTTP_URL,"POST",{complete:function(c){c=a.__handleMessage(c.responseText);null!=
-c&&Organics.__ajax(a.__HTTP_URL,"POST",{complete:function(a){},error:function(c,b){a.__handleDisconnect("POST request failed ("+b+")",0)}},c,null,{"X-Organics-Req":Organics.__rtMessage,"X-Organics-Conn":a.__connectionId});setTimeout(function(){a.__doLongPolling()},0)},error:function(c,e){a.__handleDisconnect("long-polling request failed ("+e+")",0)}},null,null,{"X-Organics-Req":Organics.__rtLongPoll,"X-Organics-Conn":a.__connectionId})};this.Connection.prototype.Request=function(){var a=this,c=Array.prototype.slice.call(arguments);
-if(0!=c.length){var e=c[0];if(1<c.length){var b=c[c.length-1];"function"!=typeof b&&(b=null)}else b=null;c=null!=b?c.slice(1,c.length-1):c.slice(1,c.length);if(!a.Connected())throw a.__logMessage("-> Ignoring Request() call (Not connected)"),Organics.ErrNotConnected;a.__requestCounter++;-1==a.__requestCounter&&a.__requestCounter++;var f=a.__requestCounter;b?a.__requestHandlers[a.__requestCounter]=b:f=-1;e=JSON.stringify([f,e,c]);Organics.WebSocketSupported?a.__webSocket.send(e):Organics.__ajax(a.__HTTP_URL,
-"POST",{complete:function(a){},error:function(b,c){b&&413==b.status?a.__handleDisconnect("JSON request data exceeded server's MaxBufferSize property.",0):a.__handleDisconnect("Request failed: "+c)}},e,a.Timeout,{"X-Organics-Req":Organics.__rtMessage,"X-Organics-Conn":a.__connectionId})}};this.Connection.prototype.Handle=function(a,c){if(null===c)delete this.__handlers[a];else{if("function"!==typeof c)throw TypeError('Handle() parameter "handler" must be function!');this.__handlers[a]=c}}};
\ No newline at end of file
+if(typeof JSON!=='object'){JSON={};}
+(function(){'use strict';function f(n){return n<10?'0'+n:n;}
+if(typeof Date.prototype.toJSON!=='function'){Date.prototype.toJSON=function(key){return isFinite(this.valueOf())?this.getUTCFullYear()+'-'+
+f(this.getUTCMonth()+1)+'-'+
+f(this.getUTCDate())+'T'+
+f(this.getUTCHours())+':'+
+f(this.getUTCMinutes())+':'+
+f(this.getUTCSeconds())+'Z':null;};String.prototype.toJSON=Number.prototype.toJSON=Boolean.prototype.toJSON=function(key){return this.valueOf();};}
+var cx=/[\u0000\u00ad\u0600-\u0604\u070f\u17b4\u17b5\u200c-\u200f\u2028-\u202f\u2060-\u206f\ufeff\ufff0-\uffff]/g,escapable=/[\\\"\x00-\x1f\x7f-\x9f\u00ad\u0600-\u0604\u070f\u17b4\u17b5\u200c-\u200f\u2028-\u202f\u2060-\u206f\ufeff\ufff0-\uffff]/g,gap,indent,meta={'\b':'\\b','\t':'\\t','\n':'\\n','\f':'\\f','\r':'\\r','"':'\\"','\\':'\\\\'},rep;function quote(string){escapable.lastIndex=0;return escapable.test(string)?'"'+string.replace(escapable,function(a){var c=meta[a];return typeof c==='string'?c:'\\u'+('0000'+a.charCodeAt(0).toString(16)).slice(-4);})+'"':'"'+string+'"';}
+function str(key,holder){var i,k,v,length,mind=gap,partial,value=holder[key];if(value&&typeof value==='object'&&typeof value.toJSON==='function'){value=value.toJSON(key);}
+if(typeof rep==='function'){value=rep.call(holder,key,value);}
+switch(typeof value){case'string':return quote(value);case'number':return isFinite(value)?String(value):'null';case'boolean':case'null':return String(value);case'object':if(!value){return'null';}
+gap+=indent;partial=[];if(Object.prototype.toString.apply(value)==='[object Array]'){length=value.length;for(i=0;i<length;i+=1){partial[i]=str(i,value)||'null';}
+v=partial.length===0?'[]':gap?'[\n'+gap+partial.join(',\n'+gap)+'\n'+mind+']':'['+partial.join(',')+']';gap=mind;return v;}
+if(rep&&typeof rep==='object'){length=rep.length;for(i=0;i<length;i+=1){if(typeof rep[i]==='string'){k=rep[i];v=str(k,value);if(v){partial.push(quote(k)+(gap?': ':':')+v);}}}}else{for(k in value){if(Object.prototype.hasOwnProperty.call(value,k)){v=str(k,value);if(v){partial.push(quote(k)+(gap?': ':':')+v);}}}}
+v=partial.length===0?'{}':gap?'{\n'+gap+partial.join(',\n'+gap)+'\n'+mind+'}':'{'+partial.join(',')+'}';gap=mind;return v;}}
+if(typeof JSON.stringify!=='function'){JSON.stringify=function(value,replacer,space){var i;gap='';indent='';if(typeof space==='number'){for(i=0;i<space;i+=1){indent+=' ';}}else if(typeof space==='string'){indent=space;}
+rep=replacer;if(replacer&&typeof replacer!=='function'&&(typeof replacer!=='object'||typeof replacer.length!=='number')){throw new Error('JSON.stringify');}
+return str('',{'':value});};}
+if(typeof JSON.parse!=='function'){JSON.parse=function(text,reviver){var j;function walk(holder,key){var k,v,value=holder[key];if(value&&typeof value==='object'){for(k in value){if(Object.prototype.hasOwnProperty.call(value,k)){v=walk(value,k);if(v!==undefined){value[k]=v;}else{delete value[k];}}}}
+return reviver.call(holder,key,value);}
+text=String(text);cx.lastIndex=0;if(cx.test(text)){text=text.replace(cx,function(a){return'\\u'+
+('0000'+a.charCodeAt(0).toString(16)).slice(-4);});}
+if(/^[\],:{}\s]*$/.test(text.replace(/\\(?:["\\\/bfnrt]|u[0-9a-fA-F]{4})/g,'@').replace(/"[^"\\\n\r]*"|true|false|null|-?\d+(?:\.\d*)?(?:[eE][+\-]?\d+)?/g,']').replace(/(?:^|:|,)(?:\s*\[)+/g,''))){j=eval('('+text+')');return typeof reviver==='function'?walk({'':j},''):j;}
+throw new SyntaxError('JSON.parse');};}}());var Organics=new function(){this.Debug=false;this.WebSocketSupported="WebSocket"in window||"MozWebSocket"in window;this.ServerSentEventsSupported="EventSource"in window;this.MessagePackSupported=typeof ArrayBuffer!="undefined"&&typeof Uint8Array!="undefined"&&typeof DataView!="undefined";this.LongPolling="LongPolling";this.WebSocket="WebSocket";this.ServerSentEvents="ServerSentEvents";this.Connect="A!B@C#D$E%F^G&H*I(J)";this.Disconnect="a1b2c3d4e5f6g7h8i9j0";this.SessionStore="s";this.ConnectionStore="c";this.__rtLongPollEstablishConnection="lpec";this.__rtLongPoll="lp";this.__rtMessage="m";this.__rtRekey="rk";this.__seConnect="c";this.__sePing="p";this.ErrNotConnected="not currently connected to server";this.__ftError="e";this.__ftSequenced="s";this.__ftAck="a";this.__ftResume="r";this.__ftGoingAway="g";this.__ftCancel="c";this.__ftProgress="p";this.__ftWatch="w";this.__ftUnwatch="u";this.__ftValue="v";this.__ftToken="t";this.__ftRekey="k";this.__hdrSession="X-Organics-Session";this.__spSession="organics-session";this.__spBearer="organics-bearer";this.__codecProtocolPrefix="organics.";this.__hdrCodec="X-Organics-Codec";this.__qpCodec="organics-codec";this.__json={Name:"json",encode:function(frame){return JSON.stringify(frame);},decode:function(data){return JSON.parse(data);}};this.__offeredCodecs=function(){if(Organics.MessagePackSupported){return Organics.__msgpack.Name+", "+Organics.__json.Name;}
+return Organics.__json.Name;}
+this.__codecNamed=function(name){if(name==Organics.__msgpack.Name){return Organics.__msgpack;}
+return Organics.__json;}
+this.__ackInterval=16;this.ResumeAttempts=5;this.ResumeDelay=1000;this.CodeHandlerError=1;this.CodeHandlerPanic=2;this.CodeNoHandler=3;this.CodeBadArguments=4;this.CodeShuttingDown=5;this.ReconnectDelay=5000;this.Error=function(Code,Message,Data){this.Code=Code;this.Message=Message;this.Data=Data;}
+this.Error.prototype.toString=function(){return"Organics.Error("+this.Code+"): "+this.Message;}
+this.__addEventListener=function(element,event,handler){if(document.addEventListener){element.addEventListener(event,handler,false);}else{element.attachEvent("on"+event,handler);}}
+this.__isPageBeingRefreshed=false;this.__addEventListener(window,"beforeunload",function(){Organics.__isPageBeingRefreshed=true;})
+this.__Log=function(msg){if(Organics.Debug){if(window.console){console.log("Organics: "+msg);}}}
+if(this.WebSocketSupported){var ws=null;if("WebSocket"in window){ws=window.WebSocket;}else{ws=window.MozWebSocket;}
+if(ws.CLOSED<=2){this.WebSocketSupported=false;if(window.console){console.log("Organics: Browser only supports < hybi 07 WebSockets; falling back to long polling.");}}}
+this.__StackTrace=function(){var e=new Error("StackTrace");var stack=e.stack.replace(/^[^\(]+?[\n$]/gm,'').replace(/^\s+at\s+/gm,'').replace(/^Object.<anonymous>\s*\(/gm,'{anonymous}()@').split('\n');return stack;}
+this.__LogStackTrace=function(){if(Organics.Debug){var lines=Organics.__StackTrace();for(var i=0;i<lines.length;i++){if(window.console){console.log(lines[i]);}}
+if(window.console){console.log("\n");}}}
+this.__StringStartsWith=function(str,w){return str.slice(0,w.length)==w;}
+this.__StringEndsWith=function(str,w){return str.slice(-w.length)==w;}
+this.__hexEncode=function(str){var bytes=unescape(encodeURIComponent(str));var hex="";for(var i=0;i<bytes.length;i++){var c=bytes.charCodeAt(i).toString(16);hex+=(c.length<2)?"0"+c:c;}
+return hex;}
+this.__base64Decode=function(str){var bin=window.atob(str);var bytes=new Uint8Array(bin.length);for(var i=0;i<bin.length;i++){bytes[i]=bin.charCodeAt(i);}
+return bytes.buffer;}
+this.__joinBatch=function(messages){var size=0;for(var i=0;i<messages.length;i++){size+=4+messages[i].length;}
+var batch=new Uint8Array(size);var view=new DataView(batch.buffer);var pos=0;for(var i=0;i<messages.length;i++){view.setUint32(pos,messages[i].length);batch.set(messages[i],pos+4);pos+=4+messages[i].length;}
+return batch;}
+this.__splitBatch=function(buffer){var view=new DataView(buffer);var messages=[];var pos=0;while(pos<buffer.byteLength){if(pos+4>buffer.byteLength){throw"truncated message length";}
+var n=view.getUint32(pos);if(pos+4+n>buffer.byteLength){throw"truncated message";}
+messages.push(buffer.slice(pos+4,pos+4+n));pos+=4+n;}
+return messages;}
+this.__msgpack={Name:"msgpack",encode:function(frame){var bytes=[];var scratch=new DataView(new ArrayBuffer(8));var pushUint=function(v,size){for(var i=size-1;i>=0;i--){bytes.push(Math.floor(v/Math.pow(2,8*i))&0xff);}};var pushLength=function(n,fixed,fixedMax,code8,code16,code32){if(fixed!=null&&n<=fixedMax){bytes.push(fixed|n);}else if(code8!=null&&n<=0xff){bytes.push(code8,n);}else if(n<=0xffff){bytes.push(code16);pushUint(n,2);}else{bytes.push(code32);pushUint(n,4);}};var pushString=function(str){var utf8=[];for(var i=0;i<str.length;i++){var c=str.charCodeAt(i);if(c<0x80){utf8.push(c);}else if(c<0x800){utf8.push(0xc0|(c>>6),0x80|(c&0x3f));}else if((c&0xfc00)==0xd800&&i+1<str.length&&(str.charCodeAt(i+1)&0xfc00)==0xdc00){c=0x10000+((c&0x3ff)<<10)+(str.charCodeAt(++i)&0x3ff);utf8.push(0xf0|(c>>18),0x80|((c>>12)&0x3f),0x80|((c>>6)&0x3f),0x80|(c&0x3f));}else{utf8.push(0xe0|(c>>12),0x80|((c>>6)&0x3f),0x80|(c&0x3f));}}
+pushLength(utf8.length,0xa0,31,0xd9,0xda,0xdb);for(var i=0;i<utf8.length;i++){bytes.push(utf8[i]);}};var pushNumber=function(v){if(Math.floor(v)===v&&Math.abs(v)<=9007199254740991){if(v>=0){if(v<=0x7f){bytes.push(v);}else if(v<=0xff){bytes.push(0xcc,v);}else if(v<=0xffff){bytes.push(0xcd);pushUint(v,2);}else if(v<=0xffffffff){bytes.push(0xce);pushUint(v,4);}else{bytes.push(0xcf);pushUint(v,8);}}else if(v>=-32){bytes.push(v&0xff);}else if(v>=-128){bytes.push(0xd0,v&0xff);}else if(v>=-32768){bytes.push(0xd1);pushUint(v+0x10000,2);}else if(v>=-2147483648){bytes.push(0xd2);pushUint(v+0x100000000,4);}else{var hi=Math.floor(v/0x100000000);bytes.push(0xd3);pushUint(hi+0x100000000,4);pushUint(v-hi*0x100000000,4);}
+return;}
+if(!isFinite(v)){bytes.push(0xc0);return;}
+scratch.setFloat64(0,v);bytes.push(0xcb);for(var i=0;i<8;i++){bytes.push(scratch.getUint8(i));}};var push=function(v){if(v==null||typeof v=="function"){bytes.push(0xc0);}else if(typeof v=="boolean"){bytes.push(v?0xc3:0xc2);}else if(typeof v=="number"){pushNumber(v);}else if(typeof v=="string"){pushString(v);}else if(v instanceof ArrayBuffer||v instanceof Uint8Array){var bin=new Uint8Array(v);pushLength(bin.length,null,0,0xc4,0xc5,0xc6);for(var i=0;i<bin.length;i++){bytes.push(bin[i]);}}else if(typeof v.toJSON=="function"){push(v.toJSON());}else if(v instanceof Array){pushLength(v.length,0x90,15,null,0xdc,0xdd);for(var i=0;i<v.length;i++){push(v[i]);}}else{var keys=[];for(var key in v){if(Object.prototype.hasOwnProperty.call(v,key)&&v[key]!==undefined&&typeof v[key]!="function"){keys.push(key);}}
+pushLength(keys.length,0x80,15,null,0xde,0xdf);for(var i=0;i<keys.length;i++){pushString(keys[i]);push(v[keys[i]]);}}};push(frame);return new Uint8Array(bytes);},decode:function(buffer){var view=new DataView(buffer);var pos=0;var uint=function(size){var v=0;for(var i=0;i<size;i++){v=v*256+view.getUint8(pos++);}
+return v;};var string=function(n){var str="";var end=pos+n;if(end>view.byteLength){throw new Error("msgpack: unexpected end of data");}
+while(pos<end){var c=view.getUint8(pos++);if(c>=0xf0){c=((c&0x07)<<18)|((view.getUint8(pos++)&0x3f)<<12)|((view.getUint8(pos++)&0x3f)<<6)|(view.getUint8(pos++)&0x3f);c-=0x10000;str+=String.fromCharCode(0xd800+(c>>10),0xdc00+(c&0x3ff));continue;}else if(c>=0xe0){c=((c&0x0f)<<12)|((view.getUint8(pos++)&0x3f)<<6)|(view.getUint8(pos++)&0x3f);}else if(c>=0xc0){c=((c&0x1f)<<6)|(view.getUint8(pos++)&0x3f);}
+str+=String.fromCharCode(c);}
+return str;};var array=function(n){var a=[];for(var i=0;i<n;i++){a.push(read());}
+return a;};var map=function(n){var m={};for(var i=0;i<n;i++){var key=read();m[key]=read();}
+return m;};var read=function(){var c=view.getUint8(pos++);if(c<=0x7f){return c;}else if(c>=0xe0){return c-0x100;}else if((c&0xf0)==0x80){return map(c&0x0f);}else if((c&0xf0)==0x90){return array(c&0x0f);}else if((c&0xe0)==0xa0){return string(c&0x1f);}
+var v;switch(c){case 0xc0:return null;case 0xc2:return false;case 0xc3:return true;case 0xc4:case 0xc5:case 0xc6:var n=uint(1<<(c-0xc4));v=new Uint8Array(buffer.slice(pos,pos+n));pos+=n;return v;case 0xca:v=view.getFloat32(pos);pos+=4;return v;case 0xcb:v=view.getFloat64(pos);pos+=8;return v;case 0xcc:case 0xcd:case 0xce:case 0xcf:return uint(1<<(c-0xcc));case 0xd0:v=view.getInt8(pos);pos+=1;return v;case 0xd1:v=view.getInt16(pos);pos+=2;return v;case 0xd2:v=view.getInt32(pos);pos+=4;return v;case 0xd3:v=view.getInt32(pos)*0x100000000+view.getUint32(pos+4);pos+=8;return v;case 0xd9:case 0xda:case 0xdb:return string(uint(1<<(c-0xd9)));case 0xdc:case 0xdd:return array(uint(2<<(c-0xdc)));case 0xde:case 0xdf:return map(uint(2<<(c-0xde)));}
+throw new Error("msgpack: unsupported type "+c);};var frame=read();if(pos!=view.byteLength){throw new Error("msgpack: trailing data after frame");}
+return frame;}};this.__xhr=function(){if(window.XMLHttpRequest){return new XMLHttpRequest();}else if(window.createRequest){return window.createRequest();}else if(window.ActiveXObject){var modes=["Msxml3.XMLHTTP","Msxml2.XMLHTTP.6.0","Msxml2.XMLHTTP.3.0","Msxml2.XMLHTTP","Microsoft.XMLHTTP"];for(var i=0;i<modes.length;i++){try{return new ActiveXObject(modes[i]);}catch(e){}}}}
+this.__translateWindowsError=function(code){switch(code){case 12001:return"Internet handle could not be generated at this time.";case 12002:return"Request timed out.";case 12004:return"An internal internet error has occured.";case 12005:return"URL is invalid.";case 12006:return"URL scheme could not be recognized or is not supported.";case 12007:return"Server name could not be resolved.";case 12008:return"The requested protocol could not be found.";case 12013:return"Failed to log on to FTP server, user name is incorrect.";case 12014:return"Failed to log on to FTP server, password is incorrect.";case 12015:return"Failed to coonect and log on to FTP server.";case 12023:return"Direct network access cannot be made at this time.";case 12029:return"Unable to connect to server.";case 12030:return"Connection terminated.";case 12031:return"Connection reset.";case 12037:return"Date on server's SSL certificate is bad expired.";case 12038:return"Host name on server's SSL certificate is incorrect.";case 12040:return"Moving from non-SSL to SSL due to redirect.";case 12042:return"Attempt to post and change data on server that is not secure.";case 12043:return"Attempt to post data on server that is not secure.";case 12110:return"FTP operation failed, operation is already in progress.";case 12111:return"FTP operation failed, the session was aborted.";case 12150:return"Requested HTTP header could not be found.";case 12151:return"Server returned no HTTP headers.";case 12152:return"Server response could not be parsed.";case 12156:return"HTTP redirect failed, scheme changed or all attempts failed.";}}
+this.__ajax=function(url,method,handlers,data,timeout,headers,responseType){var xhr=new Organics.__xhr();if(!xhr){handlers["error"](null,"Browser has no support for AJAX");return}
+var timer=null;if(timeout){timer=setTimeout(function(){xhr.abort();handlers["error"](xhr,"timed out");},timeout);}
+xhr.onreadystatechange=function(){if(xhr.readyState==4){if(timer){clearTimeout(timer);}
+if(xhr.status==200){handlers["complete"](xhr);}else{var windowsErr=Organics.__translateWindowsError(xhr.status);if(Organics.__isPageBeingRefreshed&&xhr.status==0){return;}else if(xhr.status==0){handlers["error"](xhr,"network error")}else if(windowsErr!=null){handlers["error"](xhr,xhr.status+" "+windowsErr);}else{handlers["error"](xhr,xhr.status+" "+xhr.statusText);}}}}
+try{xhr.open(method,url,true);}catch(actualError){handlers["error"](xhr,"XMLHttpRequest.open failed: "+actualError);return;}
+if(responseType){xhr.responseType=responseType;}
+if(headers==null){headers={};}
+if(headers["Content-Type"]==null){headers["Content-Type"]="text/plain;charset=UTF-8";}
+if(headers){for(var key in headers){xhr.setRequestHeader(key,headers[key]);}}
+try{xhr.send(data);}catch(actualError){handlers["error"](xhr,"XMLHttpRequest.send failed: "+actualError);return;}}
+this.Connection=function(URL,TLS,Timeout,Resume,SessionTokens){var self=this;self.__requestCounter=-1;self.__requestHandlers={};self.__progressHandlers={};self.__watchers={};self.__watchers[Organics.SessionStore]={};self.__watchers[Organics.ConnectionStore]={};self.Resume=Resume;if(self.Resume==null){self.Resume=false;}
+if(typeof self.Resume!="boolean"){throw TypeError("Resume optional parameter must be bool!");}
+self.SessionTokens=SessionTokens;if(self.SessionTokens==null){self.SessionTokens=false;}
+if(typeof self.SessionTokens!="boolean"){throw TypeError("SessionTokens optional parameter must be bool!");}
+self.__sessionToken=null;self.__bearerToken=null;self.__generation=0;self.__goingAway=false;self.__codec=Organics.__json;self.__postQueue=[];self.__postGeneration=null;self.__resumeKey=null;self.__resuming=false;self.__resumeAttempts=0;self.__resetSequence=function(){self.__sent=0;self.__sentBuffer=[];self.__received=0;self.__receivedAcked=0;}
+self.__resetSequence();self.__URL=URL;if(typeof self.__URL!="string"){throw TypeError("URL parameter must be an string!");}
+self.URL=self.__URL;self.TLS=TLS;if(self.TLS==null){self.TLS=false;}
+if(typeof self.TLS!="boolean"){throw TypeError("TLS optional parameter must be bool!");}
+self.Timeout=Timeout;if(self.Timeout==null){self.Timeout=15*1000;}
+self.Timeout=Timeout;self.__handlers={};self.__connected=false;self.__connecting=false;if(Organics.__StringStartsWith(self.__URL,"/")){self.__URL=document.location.host+self.__URL;}
+var methodWs="ws://";var methodWss="wss://";var methodHttp="http://";var methodHttps="https://";if(Organics.__StringStartsWith(self.__URL,methodWs)){self.__URL=self.__URL.slice(methodWs.length)}else if(Organics.__StringStartsWith(self.__URL,methodWss)){self.__URL=self.__URL.slice(methodWss.length)}else if(Organics.__StringStartsWith(self.__URL,methodHttp)){self.__URL=self.__URL.slice(methodHttp.length)}else if(Organics.__StringStartsWith(self.__URL,methodHttps)){self.__URL=self.__URL.slice(methodHttps.length)}
+if(self.TLS){self.__HTTP_URL=methodHttps+self.__URL;self.__WS_URL=methodWss+self.__URL;}else{self.__HTTP_URL=methodHttp+self.__URL;self.__WS_URL=methodWs+self.__URL;}
+if(Organics.WebSocketSupported){self.__method=Organics.WebSocket;}else if(self.__serverSentEventsUsable()){self.__method=Organics.ServerSentEvents;}else{self.__method=Organics.LongPolling;}
+if(Organics.WebSocketSupported){if(self.TLS){self.__URL=methodWss+self.__URL;}else{self.__URL=methodWs+self.__URL;}}else{if(self.TLS){self.__URL=methodHttps+self.__URL;}else{self.__URL=methodHttp+self.__URL;}}
+self.__logMessage=function(msg){Organics.__Log("("+self.__URL+"): "+msg);}
+self.__handleDisconnect=function(err,later){self.__resumeKey=null;self.__resuming=false;self.__resetSequence();if(self.__goingAway){self.__goingAway=false;var delay=Organics.ReconnectDelay+Math.random()*Organics.ReconnectDelay;self.__logMessage("-> Server is going away; reconnecting in "+delay+"ms");setTimeout(function(){self.Connect();},delay);}
+if(self.__connected==true||self.__connecting==true){self.__connected=false;self.__connecting=false;self.__logMessage("-> Disconnected: \""+err+"\"");var fn=self.__handlers[Organics.Disconnect];if(fn){fn(err)}}
+self.__connected=false;self.__connecting=false;}
+self.__handleOpen=function(){if(self.__resuming){return;}
+self.__connected=true;self.__connecting=false;self.__handleConnect();}
+self.__handleConnect=function(){self.__logMessage("-> Connected");for(var scope in self.__watchers){for(var key in self.__watchers[scope]){self.__send([Organics.__ftWatch,scope,key]);}}
+var fn=self.__handlers[Organics.Connect];if(fn){fn()}}}
+this.Connection.prototype.Method=function(){return this.__method;}
+this.Connection.prototype.Connected=function(){var self=this;if(self.__connecting==true){return false;}
+return self.__connected;}
+this.Connection.prototype.Close=function(){var self=this;if(self.__connected==true){self.__connected=false;self.__resuming=false;self.__generation++;if(self.__method==Organics.WebSocket){self.__webSocket.close()}else if(self.__method==Organics.ServerSentEvents){self.__eventSource.close()}}}
+this.Connection.prototype.Connect=function(){var self=this;if(self.__connected||self.__connecting){return;}
+self.__logMessage("-> Connect()");self.__connecting=true;var doConnect=function(){var generation=++self.__generation;self.__codec=Organics.__json;self.__logMessage("Connecting using "+self.__method);if(self.__method==Organics.WebSocket){self.__connectWebSocket(doConnect,generation)
+return}else if(self.__method==Organics.ServerSentEvents){self.__connectEventSource(doConnect,generation)
+return}
+var headers=self.__headers({"X-Organics-Req":Organics.__rtLongPollEstablishConnection});headers[Organics.__hdrCodec]=Organics.__offeredCodecs();Organics.__ajax(self.__connectURL(self.__HTTP_URL),"POST",{complete:function(xhr){if(generation!=self.__generation){return;}
+self.__connectionId=xhr.responseText;self.__codec=Organics.__codecNamed(xhr.getResponseHeader(Organics.__hdrCodec));if(self.SessionTokens){var token=xhr.getResponseHeader(Organics.__hdrSession);if(token){self.__sessionToken=token;}}
+self.__logMessage("-> Create session request successful: connected to server");setTimeout(function(){self.__doLongPolling(generation);},0);self.__handleOpen();},error:function(xhr,msg){if(generation!=self.__generation){return;}else if(self.__resuming){self.__retryResume();return;}
+self.__handleDisconnect("Create session request failed ("+msg+")");}},null,self.Timeout,headers);};self.__doConnect=doConnect;if(!Organics.__hasAlreadyLoaded){if(document.readyState==='complete'){doConnect();}else{Organics.__addEventListener(window,"load",doConnect);}
+Organics.__hasAlreadyLoaded=true;}else{doConnect();}}
+this.Connection.prototype.__closeTransport=function(){var self=this;if(self.__method==Organics.WebSocket){self.__webSocket.close();}else if(self.__method==Organics.ServerSentEvents){self.__eventSource.close();}}
+this.Connection.prototype.__handleMessage=function(msg){var self=this;var binary=typeof msg!="string";if((binary&&msg.byteLength>0)||(!binary&&msg.length>0)){try{if(binary){var json=Organics.__msgpack.decode(msg);}else{var json=JSON.parse(msg);}}catch(parseError){self.__handleDisconnect("Server sent bad data: "+parseError);self.__closeTransport();return;}
+return self.__handleFrame(json);}else{self.__sendAck();if(self.__method==Organics.WebSocket){return"";}else{return;}}}
+this.Connection.prototype.__handleFrame=function(json){var self=this;if(json.length==3&&json[0]===Organics.__ftSequenced){if(json[1]<=self.__received){return;}
+self.__received=json[1];if(self.__received-self.__receivedAcked>=Organics.__ackInterval){self.__sendAck();}
+json=json[2];}
+if(json.length==2&&json[0]===Organics.__ftAck){while(self.__sentBuffer.length>0&&self.__sentBuffer[0][0]<=json[1]){self.__sentBuffer.shift();}
+return;}else if(json.length==3&&json[0]===Organics.__ftResume){self.__handleResume(json[1],json[2]);return;}else if(json.length==2&&json[0]===Organics.__ftToken){self.__sessionToken=json[1];return;}else if(json.length==2&&json[0]===Organics.__ftRekey){self.__rekey(json[1]);return;}else if(json.length==1&&json[0]===Organics.__ftGoingAway){self.__logMessage("-> Server is going away");self.__goingAway=true;return;}else if(json.length==2&&json[0]===Organics.__ftCancel){return;}else if((json.length==3||json.length==4)&&json[0]===Organics.__ftValue){var watchers=self.__watchers[json[1]]&&self.__watchers[json[1]][json[2]];if(watchers){watchers=watchers.slice();for(var i=0;i<watchers.length;i++){try{watchers[i](json[3],json.length==4);}catch(e){Organics.__Log("Store watcher exception:\n"+e);}}}
+return;}else if(json.length==3&&json[0]===Organics.__ftProgress){var onProgress=self.__progressHandlers[json[1]];if(onProgress){try{onProgress.apply(undefined,json[2]);}catch(e){Organics.__Log("Request handler onProgress exception:\n"+e);}}
+return;}
+if(json.length==3&&json[0]===Organics.__ftError){var id=json[1];var err=new Organics.Error(json[2].code,json[2].message,json[2].data);var onComplete=self.__requestHandlers[id];delete self.__requestHandlers[id];delete self.__progressHandlers[id];if(onComplete){try{onComplete(err);}catch(e){Organics.__Log("Request handler onComplete exception:\n"+e);return;}}else{Organics.__Log("Got invalid error response; id is invalid; ignored.")
+return}}else if(json.length==3){var id=json[0];var requestName=json[1];var args=json[2];var responseArgs=null;var responseErr=null;var fn=self.__handlers[requestName];if(fn){try{var responseArgs=fn.apply(undefined,args);if(responseArgs==null){responseArgs=[];}}catch(e){Organics.__Log("Request handler exception:\n"+e);if(e instanceof Organics.Error){responseErr=e;}else{responseErr=new Organics.Error(Organics.CodeHandlerError,""+e);}}}else{Organics.__Log("Ignoring request \""+requestName+"\", no handler.");responseErr=new Organics.Error(Organics.CodeNoHandler,"no handler for request "+requestName);}
+if(id!==-1){if(responseErr!=null){return[Organics.__ftError,id,{code:responseErr.Code,message:responseErr.Message,data:responseErr.Data}];}
+return[id,responseArgs];}}else if(json.length==2||json.length==1){var id=json[0];if(json.length==1){var args=[];}else{var args=json[1];}
+var onComplete=self.__requestHandlers[id];delete self.__requestHandlers[id];delete self.__progressHandlers[id];if(onComplete){try{onComplete.apply(undefined,args);}catch(e){Organics.__Log("Request handler onComplete exception:\n"+e);return;}}else{Organics.__Log("Got invalid response; id is invalid; ignored.")
+return}}else{self.__handleDisconnect("Server sent bad data: Must be array of length 3");self.__closeTransport();return;}}
+this.Connection.prototype.__fallback=function(){var self=this;if(self.__method==Organics.WebSocket&&self.__serverSentEventsUsable()){self.__method=Organics.ServerSentEvents;}else if(self.__method!=Organics.LongPolling){self.__method=Organics.LongPolling;}else{return false;}
+self.__logMessage("Falling back to "+self.__method);return true;}
+this.Connection.prototype.__serverSentEventsUsable=function(){return Organics.ServerSentEventsSupported&&!this.SessionTokens&&!this.__bearerToken;}
+this.Connection.prototype.__connectWebSocket=function(doConnect,generation){var self=this;var protocols=[Organics.__codecProtocolPrefix+Organics.__json.Name];if(Organics.MessagePackSupported){protocols.unshift(Organics.__codecProtocolPrefix+Organics.__msgpack.Name);}
+if(self.SessionTokens){if(self.__sessionToken){protocols.push(Organics.__spSession+"."+Organics.__hexEncode(self.__sessionToken));}else{protocols.push(Organics.__spSession);}}
+if(self.__bearerToken){protocols.push(Organics.__spBearer+"."+Organics.__hexEncode(self.__bearerToken));}
+var url=self.__connectURL(self.__WS_URL);if(window.WebSocket){self.__webSocket=new WebSocket(url,protocols);}else if(window.MozWebSocket){self.__webSocket=new MozWebSocket(url,protocols);}
+self.__webSocket.binaryType="arraybuffer";self.__webSocket.onopen=function(evt){if(generation!=self.__generation){return;}
+if(self.__webSocket.protocol==Organics.__codecProtocolPrefix+Organics.__msgpack.Name){self.__codec=Organics.__msgpack;}
+self.__handleOpen();}
+self.__webSocket.onclose=function(evt){if(generation!=self.__generation){return;}else if(self.__resuming){self.__retryResume();return;}else if(self.__connecting&&self.__fallback()){doConnect();return;}else if(self.__tryResume()){return;}
+self.__handleDisconnect("connection closed");}
+self.__webSocket.onmessage=function(evt){if(generation!=self.__generation){return;}
+var response=self.__handleMessage(evt.data);if(response!=null){self.__reply(response);}}
+self.__webSocket.onerror=function(evt){if(generation!=self.__generation||self.__connecting||self.__resuming||self.Resume){return;}
+self.__handleDisconnect("disconnected"+evt);}}
+this.Connection.prototype.__connectEventSource=function(doConnect,generation){var self=this;var url=self.__connectURL(self.__HTTP_URL);url+=((/\?/).test(url)?"&":"?")+Organics.__qpCodec+"="+encodeURIComponent(Organics.__offeredCodecs());var eventSource=new EventSource(url,{withCredentials:true});self.__eventSource=eventSource;eventSource.addEventListener(Organics.__seConnect,function(evt){if(generation!=self.__generation){return;}
+var lines=evt.data.split("\n");self.__connectionId=lines[0];self.__codec=Organics.__codecNamed(lines[1]);self.__handleOpen();},false);eventSource.addEventListener(Organics.__sePing,function(evt){if(generation!=self.__generation){return;}
+self.__sendAck();self.__postMessage("");},false);eventSource.onmessage=function(evt){if(generation!=self.__generation){return;}
+var data=evt.data;if(self.__codec==Organics.__msgpack){try{data=Organics.__base64Decode(data);}catch(parseError){self.__handleDisconnect("Server sent bad data: "+parseError);self.__closeTransport();return;}}
+var response=self.__handleMessage(data);if(response!=null){self.__reply(response);}}
+eventSource.onerror=function(evt){eventSource.close();if(generation!=self.__generation){return;}else if(self.__resuming){self.__retryResume();return;}else if(self.__connecting&&self.__fallback()){doConnect();return;}else if(self.__tryResume()){return;}
+self.__handleDisconnect("event stream closed");}}
+this.Connection.prototype.__postMessage=function(data){var self=this;if(self.__postGeneration===self.__generation){self.__postQueue.push(data);return;}
+self.__postQueue=[];self.__doPost([data]);}
+this.Connection.prototype.__doPost=function(messages){var self=this;var nonEmpty=[];for(var i=0;i<messages.length;i++){if(messages[i]!==""){nonEmpty.push(messages[i]);}}
+var data="";var headers=self.__headers({"X-Organics-Req":Organics.__rtMessage,"X-Organics-Conn":self.__connectionId});if(nonEmpty.length>0&&self.__codec==Organics.__msgpack){data=Organics.__joinBatch(nonEmpty);headers["Content-Type"]="application/octet-stream";}else if(nonEmpty.length==1){data=nonEmpty[0];}else if(nonEmpty.length>1){data="["+nonEmpty.join(",")+"]";}
+var generation=self.__generation;self.__postGeneration=generation;Organics.__ajax(self.__HTTP_URL,"POST",{complete:function(xhr){if(generation!=self.__generation){return;}
+self.__postGeneration=null;if(self.__postQueue.length>0){var queued=self.__postQueue;self.__postQueue=[];self.__doPost(queued);}},error:function(xhr,msg){if(generation!=self.__generation){return;}
+self.__postGeneration=null;self.__postQueue=[];if(xhr&&xhr.status==413){self.__handleDisconnect("JSON request data exceeded server's MaxBufferSize property.",0);return;}else if(!self.__tryResume()){self.__handleDisconnect("POST request failed ("+msg+")");}}},data,self.Timeout,headers);}
+this.Connection.prototype.__doLongPolling=function(generation){var self=this;var binary=self.__codec==Organics.__msgpack;Organics.__ajax(self.__HTTP_URL,"POST",{complete:function(xhr){if(generation!=self.__generation){return;}
+var empty=binary?xhr.response.byteLength==0:xhr.responseText.length==0;if(empty){self.__handleMessage("");}else{try{if(binary){var batch=Organics.__splitBatch(xhr.response);for(var i=0;i<batch.length;i++){batch[i]=Organics.__msgpack.decode(batch[i]);}}else{var batch=JSON.parse(xhr.responseText);}}catch(parseError){self.__handleDisconnect("Server sent bad data: "+parseError);return;}
+for(var i=0;i<batch.length&&generation==self.__generation;i++){var response=self.__handleFrame(batch[i]);if(response!=null){self.__reply(response);}}}
+setTimeout(function(){self.__doLongPolling(generation);},0);},error:function(xhr,msg){if(generation!=self.__generation||self.__tryResume()){return;}
+self.__handleDisconnect("long-polling request failed ("+msg+")",0);}},null,null,self.__headers({"X-Organics-Req":Organics.__rtLongPoll,"X-Organics-Conn":self.__connectionId}),binary?"arraybuffer":null);}
+this.Connection.prototype.__rekey=function(ticket){var self=this;self.__logMessage("-> Session regenerated, renewing session cookie");Organics.__ajax(self.__HTTP_URL,"POST",{"complete":function(xhr){self.__logMessage("-> Session cookie renewed");},"error":function(xhr,error){self.__logMessage("-> Failed to renew session cookie: "+error);}},null,self.Timeout,self.__headers({"X-Organics-Req":Organics.__rtRekey,"X-Organics-Ticket":ticket}));}
+this.Connection.prototype.__headers=function(headers){var self=this;if(self.SessionTokens){headers[Organics.__hdrSession]=self.__sessionToken||"";}
+if(self.__bearerToken){headers["Authorization"]="Bearer "+self.__bearerToken;}
+return headers;}
+this.Connection.prototype.__connectURL=function(url){var self=this;if(!self.Resume){return url;}
+var key=self.__resumeKey;if(key==null){key="";}
+var query="organics-resume="+encodeURIComponent(key)+"&organics-ack="+self.__received;return url+((/\?/).test(url)?"&":"?")+query;}
+this.Connection.prototype.__sendEncoded=function(encoded){var self=this;if(self.__method==Organics.WebSocket){self.__webSocket.send(encoded);}else{self.__postMessage(encoded);}}
+this.Connection.prototype.__sendFrame=function(frame){var self=this;if(frame===""){self.__sendEncoded(frame);return;}
+self.__sendEncoded(self.__codec.encode(frame));}
+this.Connection.prototype.__send=function(msg){var self=this;if(!self.Resume){self.__sendFrame(msg);return;}
+var seq=self.__sent+1;var frame=[Organics.__ftSequenced,seq,msg];var encoded=self.__codec.encode(frame);self.__sent=seq;self.__sentBuffer.push([seq,frame]);if(!self.__resuming){self.__sendEncoded(encoded);}}
+this.Connection.prototype.__reply=function(response){var self=this;if(response===""){self.__sendFrame(response);return;}
+try{self.__send(response);}catch(e){Organics.__Log("Error encoding response:\n"+e);}}
+this.Connection.prototype.__sendAck=function(){var self=this;if(!self.Resume||self.__resuming||self.__received==self.__receivedAcked){return;}
+self.__receivedAcked=self.__received;self.__sendFrame([Organics.__ftAck,self.__received]);}
+this.Connection.prototype.__tryResume=function(){var self=this;if(!self.Resume||self.__resumeKey==null||!self.__connected||self.__goingAway||Organics.__isPageBeingRefreshed){return false;}
+if(!self.__resuming){self.__logMessage("-> Resuming");self.__resuming=true;self.__resumeAttempts=0;self.__retryResume();}
+return true;}
+this.Connection.prototype.__retryResume=function(){var self=this;self.__generation++;self.__resumeAttempts++;if(self.__resumeAttempts>Organics.ResumeAttempts){self.__handleDisconnect("unable to resume connection");return;}
+var generation=self.__generation;setTimeout(function(){if(generation==self.__generation){self.__doConnect();}},Organics.ResumeDelay);}
+this.Connection.prototype.__handleResume=function(key,received){var self=this;if(self.__resuming&&key===self.__resumeKey){self.__resuming=false;self.__logMessage("-> Resumed");while(self.__sentBuffer.length>0&&self.__sentBuffer[0][0]<=received){self.__sentBuffer.shift();}
+for(var i=0;i<self.__sentBuffer.length;i++){self.__sendFrame(self.__sentBuffer[i][1]);}
+return;}
+var lost=self.__resumeKey!=null;self.__resumeKey=key;if(lost){self.__resuming=false;self.__resetSequence();self.__requestHandlers={};self.__progressHandlers={};var err="connection could not be resumed";self.__logMessage("-> Disconnected: \""+err+"\"");var fn=self.__handlers[Organics.Disconnect];if(fn){fn(err)}
+self.__handleConnect();}}
+this.Connection.prototype.Request=function(){var self=this;var args=Array.prototype.slice.call(arguments);if(args.length==0){return;}
+var requestName=args[0];if(args.length>1){var onComplete=args[args.length-1];if(typeof onComplete!="function"){onComplete=null;}}else{var onComplete=null;}
+if(onComplete!=null){var sequence=args.slice(1,args.length-1);}else{var sequence=args.slice(1,args.length);}
+var onProgress=null;if(onComplete!=null&&sequence.length>0&&typeof sequence[sequence.length-1]=="function"){onProgress=sequence.pop();}
+if(!self.Connected()){self.__logMessage("-> Ignoring Request() call (Not connected)");throw Organics.ErrNotConnected;}
+self.__requestCounter++;if(self.__requestCounter==-1){self.__requestCounter++;}
+var id=self.__requestCounter;if(onComplete){self.__requestHandlers[self.__requestCounter]=onComplete;if(onProgress){self.__progressHandlers[self.__requestCounter]=onProgress;}}else{id=-1;}
+self.__send([id,requestName,sequence]);if(id!==-1){return id;}}
+this.Connection.prototype.Cancel=function(id){var self=this;if(!self.__requestHandlers.hasOwnProperty(id)){return false;}
+delete self.__requestHandlers[id];delete self.__progressHandlers[id];if(self.Connected()){self.__send([Organics.__ftCancel,id]);}
+return true;}
+this.Connection.prototype.Watch=function(scope,key,onChange){var self=this;if(typeof onChange!=="function"){throw TypeError("Watch() parameter \"onChange\" must be function!");}
+var watchers=self.__watchers[scope];if(!watchers){throw TypeError("Watch() parameter \"scope\" must be Organics.SessionStore or Organics.ConnectionStore!");}
+if(watchers.hasOwnProperty(key)){watchers[key].push(onChange);return;}
+watchers[key]=[onChange];if(self.Connected()){self.__send([Organics.__ftWatch,scope,key]);}}
+this.Connection.prototype.Unwatch=function(scope,key,onChange){var self=this;var watchers=self.__watchers[scope];if(!watchers||!watchers.hasOwnProperty(key)){return false;}
+var i=watchers[key].indexOf(onChange);if(i==-1){return false;}
+watchers[key].splice(i,1);if(watchers[key].length==0){delete watchers[key];if(self.Connected()){self.__send([Organics.__ftUnwatch,scope,key]);}}
+return true;}
+this.Connection.prototype.SessionToken=function(){return this.__sessionToken;}
+this.Connection.prototype.SetSessionToken=function(token){this.__sessionToken=token;}
+this.Connection.prototype.SetBearerToken=function(token){var self=this;self.__bearerToken=token;if(token&&self.__method==Organics.ServerSentEvents){self.__method=Organics.LongPolling;}}
+this.Connection.prototype.Handle=function(requestName,handler){var self=this;if(handler===null){delete self.__handlers[requestName];}else{if(typeof handler!=="function"){throw TypeError("Handle() parameter \"handler\" must be function!");}
+self.__handlers[requestName]=handler;}}}
\ No newline at end of file
@@ -0,0 +1,131 @@
+// Copyright 2012 Lightpoke. All rights reserved.
+// This source code is subject to the terms and
+// conditions defined in the "License.txt" file.
+
+package organics
+
+import (
+	"errors"
+	"reflect"
+)
+
+// errBufferOverflow is returned by transports when the other end sends an
+// frame which is larger than the server's maximum buffer size.
+var errBufferOverflow = errors.New("organics: frame exceeds the maximum buffer size")
+
+// DisconnectReason describes why an connection died, it is given to the
+// Disconnect handler, see Server.Handle().
+type DisconnectReason uint8
+
+const (
+	// The other end closed the connection, or it was lost and (for an
+	// resumable connection) not resumed in time.
+	DisconnectClosed DisconnectReason = iota
+
+	// The other end stopped answering pings, see SetPingTimeout.
+	DisconnectPingTimeout
+
+	// The connection (or it's session) was killed using Kill().
+	DisconnectKilled
+
+	// The other end sent more than the maximum buffer size (see
+	// SetMaxBufferSize), or did not keep up with the outgoing queue (see
+	// QueueKill).
+	DisconnectBufferOverflow
+
+	// The server shut down, see Shutdown.
+	DisconnectShutdown
+
+	// The other end sent data which could not be decoded, or the transport
+	// failed.
+	DisconnectError
+
+	// The connection's session expired, see SetSessionMaxAge.
+	DisconnectExpired
+)
+
+// String returns an string formatted version of the specified reason, or an
+// empty string if the reason is invalid (unknown).
+func (r DisconnectReason) String() string {
+	switch r {
+	case DisconnectClosed:
+		return "DisconnectClosed"
+
+	case DisconnectPingTimeout:
+		return "DisconnectPingTimeout"
+
+	case DisconnectKilled:
+		return "DisconnectKilled"
+
+	case DisconnectBufferOverflow:
+		return "DisconnectBufferOverflow"
+
+	case DisconnectShutdown:
+		return "DisconnectShutdown"
+
+	case DisconnectError:
+		return "DisconnectError"
+
+	case DisconnectExpired:
+		return "DisconnectExpired"
+	}
+	return ""
+}
+
+var (
+	disconnectReasonType = reflect.TypeOf(DisconnectReason(0))
+	sessionType          = reflect.TypeOf((*Session)(nil))
+)
+
+// sessionHook tells weather the request name is one of the special session
+// messages, whose handlers are given the *Session only.
+func sessionHook(requestName interface{}) bool {
+	return requestName == SessionCreated || requestName == SessionDied || requestName == SessionRestored
+}
+
+// checkHookType panics if the handler of the special message requestName does
+// not have the type it must have.
+func checkHookType(requestName interface{}, fnType reflect.Type) {
+	switch {
+	case requestName == Disconnect:
+		if fnType.NumIn() != 2 || fnType.In(0) != disconnectReasonType {
+			panic("requestHandler parameter type incorrect! Disconnect handler must be func(organics.DisconnectReason, *organics.Connection)")
+		}
+
+	case sessionHook(requestName):
+		if fnType.NumIn() != 1 || fnType.In(0) != sessionType {
+			panic("requestHandler parameter type incorrect! Session handlers must be func(*organics.Session)")
+		}
+	}
+}
+
+// doDisconnectHandler invokes the Disconnect handler, if any, for the dead
+// connection.
+func (s *Server) doDisconnectHandler(connection *Connection) {
+	reason := connection.disconnectReason()
+	logger().Println("Disconnected |", reason, connection)
+
+	if handler := s.getHandler(Disconnect); handler != nil {
+		callHook(Disconnect, handler, reflect.ValueOf(reason), reflect.ValueOf(connection))
+	}
+}
+
+// doSessionHandler invokes the handler of the special session message, if any,
+// for the session.
+func (s *Server) doSessionHandler(hook interface{}, session *Session) {
+	if handler := s.getHandler(hook); handler != nil {
+		callHook(hook, handler, reflect.ValueOf(session))
+	}
+}
+
+// callHook calls the handler of the special message hook with the arguments.
+// An panic is logged like those of request handlers (see callHandler), rather
+// than bringing the whole server down.
+func callHook(hook, handler interface{}, args ...reflect.Value) {
+	defer func() {
+		if r := recover(); r != nil {
+			logger().Println(handlerPanicMessage(hook, handler, args, r))
+		}
+	}()
+	reflect.ValueOf(handler).Call(args)
+}
@@ -0,0 +1,157 @@
+// Copyright 2012 Lightpoke. All rights reserved.
+// This source code is subject to the terms and
+// conditions defined in the "License.txt" file.
+
+package organics_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/websocket"
+
+	"github.com/sinni800/organics"
+)
+
+func TestDisconnectReasons(t *testing.T) {
+	s := newServer(t)
+	reasons := make(chan organics.DisconnectReason, 10)
+	s.Handle(organics.Disconnect, func(reason organics.DisconnectReason, c *organics.Connection) {
+		if !c.Dead() {
+			t.Error("Disconnect handler called for an live connection")
+		}
+		reasons <- reason
+	})
+	expect := func(want organics.DisconnectReason) {
+		t.Helper()
+		if reason := receive(t, reasons); reason != want {
+			t.Fatalf("disconnected for reason %v, want %v", reason, want)
+		}
+	}
+
+	ws, _ := s.rawWebSocket(t)
+	ws.Close()
+	expect(organics.DisconnectClosed)
+
+	_, c := s.rawWebSocket(t)
+	c.Kill()
+	expect(organics.DisconnectKilled)
+
+	ws, _ = s.rawWebSocket(t)
+	websocket.Message.Send(ws, `not json`)
+	expect(organics.DisconnectError)
+
+	s.SetMaxBufferSize(64)
+	ws, _ = s.rawWebSocket(t)
+	websocket.Message.Send(ws, `[1,"Echo",["`+strings.Repeat("a", 100)+`"]]`)
+	expect(organics.DisconnectBufferOverflow)
+	s.SetMaxBufferSize(1024 * 1024)
+
+	// Which never answers pings.
+	s.SetPingRate(50 * time.Millisecond)
+	s.SetPingTimeout(100 * time.Millisecond)
+	s.rawWebSocket(t)
+	expect(organics.DisconnectPingTimeout)
+	s.SetPingRate(time.Minute)
+	s.SetPingTimeout(time.Minute)
+
+	s.rawWebSocket(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown() = %v", err)
+	}
+	expect(organics.DisconnectShutdown)
+}
+
+func TestSessionHandlers(t *testing.T) {
+	s := newServer(t)
+	events := make(chan string, 10)
+	s.Handle(organics.SessionCreated, func(session *organics.Session) {
+		session.Set("visits", 1)
+		events <- "created"
+	})
+	s.Handle(organics.SessionDied, func(session *organics.Session) {
+		if !session.Dead() {
+			t.Error("SessionDied handler called for an live session")
+		}
+		events <- "died"
+	})
+	s.Handle(organics.SessionRestored, func(session *organics.Session) {
+		if session.Get("visits", 0) != 1 {
+			t.Error("restored session lost it's data")
+		}
+		events <- "restored"
+	})
+	expect := func(want string) {
+		t.Helper()
+		if e := receive(t, events); e != want {
+			t.Fatalf("got session event %q, want %q", e, want)
+		}
+	}
+
+	req := s.establish(t)
+	expect("created")
+	for _, c := range s.Connections() {
+		c.Session().Kill()
+	}
+	expect("died")
+
+	// The same session cookie restores it from the session provider.
+	config, err := websocket.NewConfig(s.wsURL(), s.http.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Header.Set("Cookie", req.Header.Get("Cookie"))
+	ws, err := websocket.DialConfig(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	expect("restored")
+
+	mustPanic := func(name, handler interface{}) {
+		t.Helper()
+		defer func() {
+			if recover() == nil {
+				t.Fatalf("Handle(%v) did not panic for an handler of type %T", name, handler)
+			}
+		}()
+		s.Handle(name, handler)
+	}
+	mustPanic(organics.Disconnect, func(c *organics.Connection) {})
+	mustPanic(organics.SessionDied, func(c *organics.Connection) {})
+	mustPanic(organics.SessionCreated, func(session *organics.Session, c *organics.Connection) {})
+}
+
+func TestHookPanic(t *testing.T) {
+	s := newServer(t)
+	called := make(chan string, 10)
+	s.Handle(organics.SessionCreated, func(session *organics.Session) {
+		called <- "created"
+		panic("SessionCreated hook panic")
+	})
+	s.Handle(organics.SessionDied, func(session *organics.Session) {
+		called <- "died"
+		panic("SessionDied hook panic")
+	})
+	s.Handle(organics.Disconnect, func(reason organics.DisconnectReason, c *organics.Connection) {
+		called <- "disconnect"
+		panic("Disconnect hook panic")
+	})
+
+	// The server outlives panicking hooks, and keeps calling them.
+	for i := 0; i < 2; i++ {
+		_, c := s.rawWebSocket(t)
+		if e := receive(t, called); e != "created" {
+			t.Fatalf("got hook %q, want created", e)
+		}
+		c.Session().Kill()
+		got := map[string]bool{receive(t, called): true, receive(t, called): true}
+		if !got["died"] || !got["disconnect"] {
+			t.Fatalf("got hooks %v, want died and disconnect", got)
+		}
+	}
+}
@@ -6,28 +6,233 @@ package organics
 
 import (
 	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
-	"reflect"
-	"runtime/debug"
 	"strconv"
+	"sync"
 )
 
-func (c *Connection) lpWaitForDeath() {
-	if !c.lpWaitingForDeath {
-		c.lpWaitingForDeath = true
-		go func() {
-			// Wait untill someone wants this connection dead
-			<-c.deathWantedNotify
+// lpTransport implements the Transport interface for the long-polling method.
+//
+// Frames are queued until an rtLongPoll request is waiting (or comes in next),
+// which takes every queued frame (up to the batch size) and sends them all in
+// an single response. Frames sent to us through rtMessage requests are handed
+// over to the Receive method.
+//
+// The server-sent events method uses it as well, in which case the frames are
+// written to the event stream instead (see sse.go).
+//
+// It implements the CodecTransport interface, as the codec is negotiated as the
+// connection is established.
+type lpTransport struct {
+	method    Method
+	codec     Codec
+	batchSize int64
+	incoming  chan []byte
+	closed    chan bool
+	closeOnce sync.Once
+
+	// Frames which are queued, and their total size. The ready channel
+	// receives an value once frames are queued, the space channel once some
+	// are taken, and the sent channel is closed once all are.
+	access       sync.Mutex
+	queue        [][]byte
+	queueSize    int64
+	ready, space chan bool
+	sent         chan bool
+}
+
+func (t *lpTransport) Send(frame []byte) error {
+	for {
+		t.access.Lock()
+		// An single frame larger than the batch size is still sent, on it's own.
+		if len(t.queue) == 0 || t.queueSize+int64(len(frame)) <= t.batchSize {
+			if len(t.queue) == 0 {
+				t.sent = make(chan bool)
+			}
+			t.queue = append(t.queue, frame)
+			t.queueSize += int64(len(frame))
+			t.access.Unlock()
+
+			select {
+			case t.ready <- true:
+			default:
+			}
+			return nil
+		}
+		t.access.Unlock()
+
+		// The queue is full, wait for it to be taken.
+		select {
+		case <-t.space:
+		case <-t.closed:
+			return ErrTransportClosed
+		}
+	}
+}
+
+// take takes the queued frames, up to the batch size. The ready channel should
+// be received from first, as there may be none.
+func (t *lpTransport) take() [][]byte {
+	t.access.Lock()
+	n := 0
+	var size int64
+	for n < len(t.queue) && (n == 0 || size+int64(len(t.queue[n])) <= t.batchSize) {
+		size += int64(len(t.queue[n]))
+		n++
+	}
+	frames := t.queue[:n:n]
+	t.queue = t.queue[n:]
+	t.queueSize -= size
+	if len(t.queue) > 0 {
+		// Left for the next one.
+		select {
+		case t.ready <- true:
+		default:
+		}
+	} else if n > 0 {
+		close(t.sent)
+	}
+	t.access.Unlock()
+
+	if n > 0 {
+		select {
+		case t.space <- true:
+		default:
+		}
+	}
+	return frames
+}
+
+// waitSent blocks until every queued frame has been taken, or until the
+// transport is closed.
+func (t *lpTransport) waitSent() {
+	t.access.Lock()
+	if len(t.queue) == 0 {
+		t.access.Unlock()
+		return
+	}
+	sent := t.sent
+	t.access.Unlock()
+
+	select {
+	case <-sent:
+	case <-t.closed:
+	}
+}
+
+func (t *lpTransport) Receive() ([]byte, error) {
+	select {
+	case frame := <-t.incoming:
+		return frame, nil
+	case <-t.closed:
+		return nil, ErrTransportClosed
+	}
+}
 
-			// Inform everyone it's dead
-			c.deathNotify <- true
+func (t *lpTransport) Close() error {
+	t.closeOnce.Do(func() {
+		close(t.closed)
+	})
+	return nil
+}
+
+func (t *lpTransport) Method() Method {
+	return t.method
+}
+
+func (t *lpTransport) Codec() Codec {
+	return t.codec
+}
+
+func newLpTransport(method Method, codec Codec, batchSize int64) *lpTransport {
+	t := new(lpTransport)
+	t.method = method
+	t.codec = codec
+	t.batchSize = batchSize
+	t.incoming = make(chan []byte)
+	t.closed = make(chan bool)
+	t.ready = make(chan bool, 1)
+	t.space = make(chan bool, 1)
+	return t
+}
+
+// lpEncodeBatch encodes the frames as an single batch: an JSON array whose
+// elements are the frames, or for any other codec the frames one after another,
+// each prefixed by it's length as an 4 byte big-endian integer. Pings (empty
+// frames) are left out, as any response means they should poll again; if there
+// is nothing else the batch is empty, which is an ping.
+func lpEncodeBatch(frames [][]byte, codec Codec) []byte {
+	batch := new(bytes.Buffer)
+	for _, frame := range frames {
+		if len(frame) == 0 {
+			continue
+		}
+		if codec != JSON {
+			binary.Write(batch, binary.BigEndian, uint32(len(frame)))
+		} else if batch.Len() == 0 {
+			batch.WriteByte('[')
+		} else {
+			batch.WriteByte(',')
+		}
+		batch.Write(frame)
+	}
+	if codec == JSON && batch.Len() > 0 {
+		batch.WriteByte(']')
+	}
+	return batch.Bytes()
+}
+
+// lpSplitBatch splits the body of an rtMessage request into the frames it
+// carries. For JSON that is either an single frame, or an JSON array of frames
+// (whose first element is an array, unlike that of any single frame); for any
+// other codec it is an batch encoded like lpEncodeBatch does, or an empty
+// body for an ping.
+func lpSplitBatch(data []byte, codec Codec) ([][]byte, error) {
+	if codec != JSON {
+		return lpSplitLengths(data)
+	}
+
+	trimmed := bytes.TrimLeft(data, " \t\r\n")
+	if len(trimmed) == 0 || trimmed[0] != '[' || !bytes.HasPrefix(bytes.TrimLeft(trimmed[1:], " \t\r\n"), []byte("[")) {
+		return [][]byte{data}, nil
+	}
+
+	var batch []json.RawMessage
+	err := json.Unmarshal(data, &batch)
+	if err != nil {
+		return nil, err
+	}
+	frames := make([][]byte, len(batch))
+	for i, frame := range batch {
+		frames[i] = frame
+	}
+	return frames, nil
+}
 
-			// Wait for above to complete
-			<-c.deathCompletedNotify
-		}()
+// lpSplitLengths splits an batch of length-prefixed frames (see lpEncodeBatch).
+func lpSplitLengths(data []byte) ([][]byte, error) {
+	if len(data) == 0 {
+		return [][]byte{data}, nil
+	}
+	var frames [][]byte
+	for len(data) > 0 {
+		if len(data) < 4 {
+			return nil, errors.New("truncated frame length")
+		}
+		n := binary.BigEndian.Uint32(data)
+		data = data[4:]
+		if n == 0 || uint64(n) > uint64(len(data)) {
+			return nil, fmt.Errorf("invalid frame length %d", n)
+		}
+		frames = append(frames, data[:n])
+		data = data[n:]
 	}
+	return frames, nil
 }
 
 func (s *Server) lpHandleLongPoll(w http.ResponseWriter, req *http.Request, session *Session, connection *Connection) {
@@ -36,65 +241,67 @@ func (s *Server) lpHandleLongPoll(w http.ResponseWriter, req *http.Request, sess
 	//
 	// Additionally, we monitor for an CloseNotify event, in case they navigate away from the page,
 	// thus closing their connection.
+	t, ok := connection.currentTransport().(*lpTransport)
+	if !ok || t.method != LongPolling {
+		// An server-sent events connection receives frames through it's event stream.
+		logger().Println("bad request | rtLongPoll for non long-polling connection attempted")
+		w.WriteHeader(http.StatusBadRequest)
+		req.Close = true
+		return
+	}
 
 	// We know for an fact that this is an valid request, so we should reset their disconnection
 	// timeout timer.
 	connection.resetDisconnectTimer()
 
 	// Enter an select which will determine our next action.
-	select {
-	case <-connection.performPing:
-		// The Connection's disconnect timer says we should ping the client to determine if it
-		// is active.
-		//
-		// We do that simply by responding with no data (an empty response = an ping).
-		w.WriteHeader(http.StatusOK)
-		return
-
-	case <-connection.DeathNotify():
-		// This connection is supposed to be dead, according to /someone/, so kill it.
-		w.WriteHeader(http.StatusServiceUnavailable)
-		req.Close = true
-		return
+	for {
+		select {
+		case <-t.closed:
+			// This connection is supposed to be dead, according to /someone/, so kill it.
+			w.WriteHeader(http.StatusServiceUnavailable)
+			req.Close = true
+			return
 
-	case <-connection.disconnectFromTimeout:
-		// They must've exceeded the timout, so close their connection.
-		connection.Kill()
-		w.WriteHeader(http.StatusRequestTimeout)
-		req.Close = true
-		return
+		case <-w.(http.CloseNotifier).CloseNotify():
+			// They could close the connection at any time (refreshing, losing connectivity...)
+			//
+			// that (in most modern browsers) will trigger this. The connection is killed, unless
+			// it is resumable.
+			t.Close()
+			return
 
-	case <-w.(http.CloseNotifier).CloseNotify():
-		// They could close the connection at any time (refreshing, losing connectivity...)
-		//
-		// that (in most modern browsers) will trigger this.
-		connection.Kill()
-		return
+		case <-t.ready:
+			frames := t.take()
+			if len(frames) == 0 {
+				// Someone else took them.
+				continue
+			}
 
-	case msg := <-connection.messageChan:
-		// Looks like we have something we would like to send to them, so we'll go ahead
-		// and respond now.
-		//
-		// This ID is simply forwarded back to the client, if the ID is >= 0, then this
-		// data is meant to be an response to an previous request from the client, so we
-		// send this >= 0 number back to the client, so they know which request it belonged
-		// to originally.
-		//
-		encoded, err := msg.jsonEncode()
-		if err != nil {
-			logger().Println(err)
-			connection.Kill()
-			w.WriteHeader(http.StatusBadRequest)
-			req.Close = true
+			// Looks like we have something we would like to send to them, so we'll go ahead
+			// and respond now, with everything that is queued.
+			//
+			// An empty response is an ping, the client should respond ASAP by making another
+			// long-polling request.
+			if t.codec != JSON {
+				w.Header().Set("Content-Type", "application/octet-stream")
+			}
+			w.WriteHeader(http.StatusOK)
+			w.Write(lpEncodeBatch(frames, t.codec))
 			return
 		}
-		w.Write(encoded)
-		return
 	}
 }
 
 func (s *Server) lpHandleMessage(w http.ResponseWriter, req *http.Request, session *Session, connection *Connection) {
 	// This is an rtMessage request, it is either an request or response to one of our requests.
+	t, ok := connection.currentTransport().(*lpTransport)
+	if !ok {
+		logger().Println("bad request | rtMessage for WebSocket connection attempted")
+		w.WriteHeader(http.StatusBadRequest)
+		req.Close = true
+		return
+	}
 
 	// We need them to specify and content-length header, we'll check here to make sure they do
 	// give it to us.
@@ -120,15 +327,13 @@ func (s *Server) lpHandleMessage(w http.ResponseWriter, req *http.Request, sessi
 	// If you need to send more JSON data than this; just raise your MaxBufferSize to whatever
 	// it is that you'll be needing at max in an single request or response.
 	if int64(contentLength) > s.MaxBufferSize() {
+		connection.kill(DisconnectBufferOverflow)
 		session.Kill()
 		w.WriteHeader(http.StatusRequestEntityTooLarge)
 		req.Close = true
 		return
 	}
 
-	// This seems to be an valid message thus far, so lets reset their disconnection timeout timer.
-	connection.resetDisconnectTimer()
-
 	// Make an slice to store the data in
 	data := make([]byte, contentLength)
 
@@ -141,136 +346,39 @@ func (s *Server) lpHandleMessage(w http.ResponseWriter, req *http.Request, sessi
 		return
 	}
 
-	decoded := new(message)
-	err = decoded.jsonDecode(data)
+	// They may send several frames at once.
+	frames, err := lpSplitBatch(data, t.codec)
 	if err != nil {
-		logger().Println(err)
+		logger().Println("bad request | rtMessage batch invalid:", err)
 		w.WriteHeader(http.StatusBadRequest)
 		req.Close = true
 		return
 	}
 
-	if !decoded.isRequest {
-		// It's an response to one of our requests
-		onComplete, ok := connection.requestCompleters[decoded.id]
-		if !ok {
-			// Should never happen.
-			logger().Println("Invalid request response, id not valid, ignoring.")
-			return
-		}
-
-		valueArgs := interfaceToValueSlice(decoded.args)
-		fn := reflect.ValueOf(onComplete)
-
-		defer func() {
-			if r := recover(); r != nil {
-				msg, ok := r.(string)
-				if !ok {
-					panic(r)
-				}
-
-				buf := new(bytes.Buffer)
-				fmt.Fprintf(buf, "Request handler onComplete panic:\n\n")
-				fmt.Fprintf(buf, "Expected type:\n")
-				fmt.Fprintf(buf, "\t")
-
-				fmt.Fprintf(buf, "func(")
-				for n := 0; n < len(valueArgs); n++ {
-					fmt.Fprintf(buf, valueArgs[n].Type().String())
-					if n+1 < len(valueArgs) {
-						fmt.Fprintf(buf, ", ")
-					}
-				}
-				fmt.Fprintf(buf, ") ...")
-
-				fmt.Fprintf(buf, "\nFound type:\n\t")
-				fmt.Fprintf(buf, "%s\n\n", fn.Type().String())
-				fmt.Fprintf(buf, "%s\n\n", msg)
-				fmt.Fprintf(buf, "%s", string(debug.Stack()))
-				logger().Println(string(buf.Bytes()))
-			}
-		}()
-		fn.Call(valueArgs)
-
-	} else {
-		// It's an request, so grab the request handler, and try to invoke it.
-		handler := s.getHandler(decoded.requestName)
-		if handler == nil {
-			logger().Printf("No handler for message \"%s\"\n", decoded.requestName)
-			return
-		}
-		fn := reflect.ValueOf(handler)
-
-		valueArgs := interfaceToValueSlice(decoded.args)
-		valueArgs = append(valueArgs, reflect.ValueOf(connection))
-
-		defer func() {
-			if r := recover(); r != nil {
-				msg, ok := r.(string)
-				if !ok {
-					panic(r)
-				}
-
-				buf := new(bytes.Buffer)
-				fmt.Fprintf(buf, "Request handler \"%s\" panic:\n\n", decoded.requestName)
-				fmt.Fprintf(buf, "Expected type:\n")
-				fmt.Fprintf(buf, "\t")
-
-				fmt.Fprintf(buf, "func(")
-				for n := 0; n < len(valueArgs); n++ {
-					fmt.Fprintf(buf, valueArgs[n].Type().String())
-					if n+1 < len(valueArgs) {
-						fmt.Fprintf(buf, ", ")
-					}
-				}
-				fmt.Fprintf(buf, ") ...")
-
-				fmt.Fprintf(buf, "\nFound type:\n\t")
-				fmt.Fprintf(buf, "%s\n\n", fn.Type().String())
-				fmt.Fprintf(buf, "%s\n\n", msg)
-				fmt.Fprintf(buf, "%s", string(debug.Stack()))
-				logger().Println(string(buf.Bytes()))
-			}
-		}()
-		responseValues := fn.Call(valueArgs)
-
-		responseArgs := make([]interface{}, len(responseValues))
-		for i, v := range responseValues {
-			responseArgs[i] = v.Interface()
-		}
-
-		responseMsg := newResponseMessage(decoded.id, responseArgs)
-
-		// Maybe while trying to send this request to the long-polling request, they never actually
-		// perform another long-polling request, so look for an timeout here.
+	// Hand the frames over to the connection, which decodes and dispatches them in order (see
+	// dispatch.go).
+	for _, frame := range frames {
 		select {
-		case <-connection.DeathNotify():
+		case <-t.closed:
 			// This connection is supposed to be dead, kill it.
 			w.WriteHeader(http.StatusServiceUnavailable)
 			req.Close = true
 			return
 
-		case <-connection.disconnectFromTimeout:
-			// They must've exceeded the disconnection timout, so close their connection.
-			connection.Kill()
-			w.WriteHeader(http.StatusRequestTimeout)
-			req.Close = true
-			return
-
 		case <-w.(http.CloseNotifier).CloseNotify():
 			// They could close the connection at any time (refreshing, losing connectivity...)
 			//
-			// Most modern browsers, will do this.
-			connection.Kill()
+			// Most modern browsers, will do this. The connection is killed, unless it is
+			// resumable.
+			t.Close()
 			return
 
-		case connection.messageChan <- responseMsg:
-			// We sent the data over the long-polling response channel, without hitting the
-			// cases above! Success!
+		case t.incoming <- frame:
+			// The connection got the frame, without hitting the cases above! Success!
 		}
 	}
 
-	// Finally, respond now that the request has completed.
+	// Finally, respond now that the message was received.
 	w.WriteHeader(http.StatusOK)
 }
 
@@ -327,8 +435,21 @@ func (s *Server) lpHandleRequest(w http.ResponseWriter, req *http.Request) {
 	//
 	// 3) (LongPoll) Client makes request, when it wants, sending rtMessage, server handles message
 	//    and responds via previous (or future) rtLongPoll request.
+	//
+	// Server-sent events connections are established through their event stream instead (see
+	// sse.go), but they send rtMessage requests, just like Long Polling does.
 
 	if organicsReq == rtLongPollEstablishConnection {
+		if s.refuseWhileShuttingDown(w, req) {
+			return
+		}
+
+		// Defined in auth.go
+		req, ok := s.authenticate(w, req)
+		if !ok {
+			return
+		}
+
 		session, ok := s.ensureSessionExists(req, func(cookie *http.Cookie) {
 			http.SetCookie(w, cookie)
 		})
@@ -353,27 +474,54 @@ func (s *Server) lpHandleRequest(w http.ResponseWriter, req *http.Request) {
 			return
 		}
 
-		// Create their new connection, using connectionId as the key
-		connection := newConnection(req.RemoteAddr, session, connectionId, LongPolling)
+		// Create their new connection, using connectionId as the key, or resume the one they ask
+		// for (in which case it's key is the connection id).
+		//
+		// The codec is negotiated here too, we tell them which one was chosen if they offered any
+		// (see codec.go).
+		offered := req.Header.Get(hdrCodec)
+		t := newLpTransport(LongPolling, s.negotiateCodecNames(offered), s.LongPollBatchSize())
+		connection, resumed := s.connectionFor(req, session, connectionId, t)
+		if len(offered) > 0 {
+			w.Header().Set(hdrCodec, t.codec.Name())
+			w.Header().Add("Access-Control-Expose-Headers", hdrCodec)
+		}
+
+		// In token mode, they store the session key themselves (see
+		// SetSessionTokens).
+		if _, tokenMode := s.sessionToken(req); tokenMode {
+			w.Header().Set(hdrSession, session.sessionKey())
+			w.Header().Add("Access-Control-Expose-Headers", hdrSession)
+		}
 
 		// Send it to them
-		w.Write([]byte(connectionId))
+		w.Write([]byte(connection.key.(string)))
 
-		// We make an timer that will determine if they have been disconnected due to their connection
-		// not responding.
-		connection.disconnectTimer(s.PingTimeout(), s.PingRate())
-		connection.lpWaitForDeath()
+		// Serve the connection from now on, rtLongPoll and rtMessage requests carry it's frames.
+		//
+		// Defined in dispatch.go
+		go s.serve(t, connection, resumed)
+		return
+	}
 
-		// Notify the server's Handler functions that this connection has connected.
-		s.doConnectHandler(connection)
+	// An connection of an regenerated session asks for the new session cookie.
+	//
+	// Defined in regenerate.go
+	if organicsReq == rtRekey {
+		s.lpHandleRekey(w, req)
 		return
 	}
 
 	// At this point, this request is either an rtLongPoll or rtMessage, they should already have
 	// an connection and session object, from an previous rtLongPollEstablishConnection.
 
-	// We'll need to retrieve their session
-	session := s.getSession(req)
+	// We'll need to retrieve their session, which they may still know by it's old key if it was
+	// regenerated (see Session.Regenerate) just now, in which case the connection they claim to
+	// be must be one of it's own (below).
+	session, rekeyed := s.rekeyedSession(req)
+	if !rekeyed {
+		session = s.getSession(req)
+	}
 	if session == nil {
 		// For some reason, they have no session object. Either they got here *magically* by an
 		// mistake, or their Organics client is totally messed up.
@@ -419,6 +567,11 @@ func (s *Server) lpHandleRequest(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	// Their session cookie is replaced right away, without waiting for them to ask for it.
+	if rekeyed && !connection.tokens {
+		http.SetCookie(w, s.SessionCookie().cookie(session.sessionKey()))
+	}
+
 	// We've now got an validated connection and session object, and can continue through with the
 	// rtLongPoll or rtMessage request.
 	if organicsReq == rtLongPoll {
@@ -0,0 +1,119 @@
+// Copyright 2012 Lightpoke. All rights reserved.
+// This source code is subject to the terms and
+// conditions defined in the "License.txt" file.
+
+package organics_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/sinni800/organics"
+)
+
+// lpClient makes raw long-polling requests for an single connection.
+type lpClient struct {
+	t      *testing.T
+	url    string
+	id     string
+	cookie string
+	header http.Header // sent with each request
+	codec  string      // the codec chosen by the server, if any
+}
+
+// post makes an long-polling request of the request type, and returns the
+// response status and body.
+func (lp *lpClient) post(requestType, body string) (int, string) {
+	lp.t.Helper()
+	req, err := http.NewRequest("POST", lp.url, strings.NewReader(body))
+	if err != nil {
+		lp.t.Fatal(err)
+	}
+	for key, values := range lp.header {
+		req.Header[key] = values
+	}
+	req.Header.Set("X-Organics-Req", requestType)
+	if lp.id != "" {
+		req.Header.Set("X-Organics-Conn", lp.id)
+	}
+	if lp.cookie != "" {
+		req.Header.Set("Cookie", lp.cookie)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		lp.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		lp.t.Fatal(err)
+	}
+	if requestType == "lpec" {
+		lp.id = string(data)
+		lp.codec = resp.Header.Get("X-Organics-Codec")
+		for _, cookie := range resp.Cookies() {
+			lp.cookie = cookie.Name + "=" + cookie.Value
+		}
+	}
+	return resp.StatusCode, string(data)
+}
+
+func TestLongPollBatching(t *testing.T) {
+	s := newServer(t)
+	s.SetLongPollBatchSize(200)
+	handled := make(chan float64, 10)
+	s.Handle("Double", func(n float64, c *organics.Connection) float64 {
+		handled <- n
+		return n * 2
+	})
+
+	lp := &lpClient{t: t, url: s.http.URL}
+	lp.post("lpec", "")
+	c := receive(t, s.connected)
+
+	// Queued frames are sent in batches, of at most the batch size (unless
+	// an single frame is larger).
+	for i := 0; i < 10; i++ {
+		c.Request("Hello", i, strings.Repeat("x", 40))
+	}
+	received, batches := 0, 0
+	for received < 10 {
+		_, body := lp.post("lp", "")
+		var batch []json.RawMessage
+		if err := json.Unmarshal([]byte(body), &batch); err != nil {
+			t.Fatalf("poll response %q: %v", body, err)
+		}
+		if len(body) > 200 && len(batch) > 1 {
+			t.Fatalf("batch of %d bytes exceeds the batch size", len(body))
+		}
+		received += len(batch)
+		batches++
+	}
+	if batches < 2 || batches >= 10 {
+		t.Fatalf("10 frames were sent in %d batches", batches)
+	}
+
+	// An batch of frames in an single message POST, handled in order.
+	if status, _ := lp.post("m", ` [ [0,"Double",[1]], [1,"Double",[2]] ]`); status != http.StatusOK {
+		t.Fatalf("batched message POST status = %d", status)
+	}
+	if a, b := receive(t, handled), receive(t, handled); a != 1 || b != 2 {
+		t.Fatalf("handled %v then %v, want 1 then 2", a, b)
+	}
+	responses := 0
+	for responses < 2 {
+		_, body := lp.post("lp", "")
+		var batch []json.RawMessage
+		if err := json.Unmarshal([]byte(body), &batch); err != nil {
+			t.Fatalf("poll response %q: %v", body, err)
+		}
+		responses += len(batch)
+	}
+
+	if status, _ := lp.post("m", `[[0,"Double",[1]`); status != http.StatusBadRequest {
+		t.Fatalf("malformed batch status = %d, want 400", status)
+	}
+}
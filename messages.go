@@ -5,7 +5,6 @@
 package organics
 
 import (
-	"encoding/json"
 	"errors"
 )
 
@@ -17,6 +16,7 @@ const (
 	rtLongPollEstablishConnection requestType = "lpec" // long-poll-establish-connection
 	rtLongPoll                                = "lp"   // long-poll
 	rtMessage                                 = "m"    // message
+	rtRekey                                   = "rk"   // rekey (see Session.Regenerate)
 )
 
 func (r requestType) valid() bool {
@@ -27,17 +27,48 @@ func (r requestType) valid() bool {
 		return true
 	case rtMessage:
 		return true
+	case rtRekey:
+		return true
 	}
 	return false
 }
 
+// Frames which are not an request or response to one begin with an string
+// instead of an numeric id, the string (an acronym) tells what type of frame it
+// is.
+const (
+	ftError     = "e" // error response
+	ftSequenced = "s" // sequenced message (resumable connections only)
+	ftAck       = "a" // acknowledgement of sequenced messages
+	ftResume    = "r" // resume information, sent by the server only
+	ftGoingAway = "g" // the server is shutting down, sent by the server only
+	ftCancel    = "c" // cancels an request which is in flight
+	ftProgress  = "p" // partial result of an request, sent by the server only
+	ftWatch     = "w" // watches an store key, sent by the client only
+	ftUnwatch   = "u" // stops watching an store key, sent by the client only
+	ftValue     = "v" // value of an watched store key, sent by the server only
+	ftToken     = "t" // session key in token mode, sent by the server only
+	ftRekey     = "k" // ticket of an regenerated session, sent by the server only
+)
+
 // Special messages for different server events, only intended to be entirely
 // unique.
 var (
-	connect int
+	connect, disconnect                          int
+	sessionCreated, sessionDied, sessionRestored int
 
 	// Special message for when an client connection is made.
 	Connect = &connect
+
+	// Special message for when an client connection dies, see
+	// DisconnectReason.
+	Disconnect = &disconnect
+
+	// Special messages for when an session is created, dies, or is restored
+	// using the data of the session provider, see Server.Handle().
+	SessionCreated  = &sessionCreated
+	SessionDied     = &sessionDied
+	SessionRestored = &sessionRestored
 )
 
 // Two types of request are sent from Organics
@@ -59,11 +90,87 @@ var (
 //     request to the person whom sent it, and where args is an JSON Array
 //     type, of any number of valid JSON data types.
 //
+//     An response may instead be an error response (when the request failed)
+//     which looks like: ["e", id, {"code": code, "message": message, "data": data}]
+//
+//     Where code is an JSON Number, message is an JSON String, and data is
+//     any (optional) valid JSON data type.
+//
+// On resumable connections both of the above are wrapped, along with an
+// sequence number, like so: ["s", seq, message]
+//
+// Where seq is an JSON Number which is one greater than the sequence number of
+// the previous message sent in the same direction (starting at one). They are
+// acknowledged by the other end using: ["a", seq]
+//
+// Which acknowledges every message up to and including seq.
+//
+// An request which is in flight may be cancelled by whoever sent it, using:
+// ["c", id]
+//
+// Where id is the id of the request, after which no response is sent for it.
+// Like responses, it is sequenced on resumable connections.
+//
+// Before the response to an request from the client, the server may send any
+// number of partial results (see Progress) for it, which look like:
+// ["p", id, args]
+//
+// Where id is the id of the request, and args is an JSON Array type, just like
+// an response's. They are sequenced on resumable connections as well.
+//
+// The client may watch an key of the session's or the connection's store (see
+// Server.SetStoreAccess), and stop watching it, using: ["w", scope, key] and
+// ["u", scope, key]
+//
+// Where scope is "s" for the session's store or "c" for the connection's, and
+// key is an JSON String. The server then sends the key's value right away, and
+// again each time it changes, using: ["v", scope, key, value]
+//
+// Or, if the store does not have the key: ["v", scope, key]
+//
+// All three are sequenced on resumable connections.
+//
+// In token mode (see Server.SetSessionTokens) the first frame sent over an
+// WebSocket is the session key, which looks like: ["t", key]
+//
+// Once an session is regenerated (see Session.Regenerate) it's connections are
+// sent the new key in token mode, just like above, and otherwise an ticket
+// which looks like: ["k", ticket]
+//
+// Which the client sends back in the X-Organics-Ticket header of an rtRekey
+// request, whose response sets the new session cookie.
+//
+// Frames are JSON encoded, unless the connection negotiated another codec (see
+// Codec) in which case they are the same arrays, encoded using that codec.
 type message struct {
 	id          float64
 	requestName interface{}
 	args        []interface{}
 	isRequest   bool
+	err         *Error
+
+	// Sequence number (zero if the message is not sequenced), or the
+	// sequence number which an acknowledgement acknowledges.
+	seq   float64
+	isAck bool
+
+	// Cancels the request with the id.
+	isCancel bool
+
+	// Partial result of the request with the id.
+	isProgress bool
+
+	// Watches, stops watching, or is the value of, the store key. The value
+	// is only present (hasValue) if the store has the key.
+	isWatch, isUnwatch, isValue bool
+	storeKey                    storeKey
+	value                       interface{}
+	hasValue                    bool
+
+	// The new session key in token mode, or the ticket, of an regenerated
+	// session (see regenerate.go).
+	isToken, isRekey bool
+	token            string
 }
 
 func newRequestMessage(id float64, requestName interface{}, args []interface{}) *message {
@@ -83,41 +190,123 @@ func newResponseMessage(id float64, args []interface{}) *message {
 	return m
 }
 
-// JsonEncode encodes this *message, m, into an JSON-encoded []byte, or returns
-// an error if one is encountered.
-func (m *message) jsonEncode() (encoded []byte, err error) {
-	if m.isRequest {
-		encoded, err = json.Marshal([]interface{}{m.id, m.requestName, m.args})
-	} else {
-		if len(m.args) == 0 {
-			encoded, err = json.Marshal([]interface{}{m.id})
-		} else {
-			encoded, err = json.Marshal([]interface{}{m.id, m.args})
+func newErrorMessage(id float64, err *Error) *message {
+	m := &message{}
+	m.id = id
+	m.err = err
+	m.isRequest = false
+	return m
+}
+
+func newCancelMessage(id float64) *message {
+	m := &message{}
+	m.id = id
+	m.isCancel = true
+	return m
+}
+
+func newProgressMessage(id float64, args []interface{}) *message {
+	m := &message{}
+	m.id = id
+	m.args = args
+	m.isProgress = true
+	return m
+}
+
+func newValueMessage(k storeKey, value interface{}, hasValue bool) *message {
+	m := &message{}
+	m.isValue = true
+	m.storeKey = k
+	m.value = value
+	m.hasValue = hasValue
+	return m
+}
+
+func newTokenMessage(sessionKey string) *message {
+	m := &message{}
+	m.isToken = true
+	m.token = sessionKey
+	return m
+}
+
+func newRekeyMessage(ticket string) *message {
+	m := &message{}
+	m.isRekey = true
+	m.token = ticket
+	return m
+}
+
+// array returns this *message, m, in it's array form, which is what is
+// encoded using an codec.
+func (m *message) array() []interface{} {
+	if m.isCancel {
+		return []interface{}{ftCancel, m.id}
+	} else if m.isToken {
+		return []interface{}{ftToken, m.token}
+	} else if m.isRekey {
+		return []interface{}{ftRekey, m.token}
+	} else if m.isValue {
+		if !m.hasValue {
+			return []interface{}{ftValue, m.storeKey.scope.frame(), m.storeKey.key}
+		}
+		return []interface{}{ftValue, m.storeKey.scope.frame(), m.storeKey.key, m.value}
+	} else if m.isProgress {
+		args := m.args
+		if args == nil {
+			args = make([]interface{}, 0)
+		}
+		return []interface{}{ftProgress, m.id, args}
+	} else if m.isRequest {
+		args := m.args
+		if args == nil {
+			// Always an array, never null.
+			args = make([]interface{}, 0)
 		}
+		return []interface{}{m.id, m.requestName, args}
+	} else if m.err != nil {
+		return []interface{}{ftError, m.id, m.err}
+	} else if len(m.args) == 0 {
+		return []interface{}{m.id}
 	}
+	return []interface{}{m.id, m.args}
+}
+
+// encode encodes this *message, m, using the codec, or returns an error if one
+// is encountered.
+func (m *message) encode(codec Codec) (encoded []byte, err error) {
+	encoded, err = codec.Marshal(m.array())
 	if err != nil {
-		err = errors.New("Error encoding JSON; " + err.Error())
+		err = errors.New("Error encoding message; " + err.Error())
 	}
 	return
 }
 
-// JsonDecode decodes the data parameter, an array of JSON-encoded []byte, into
+// decode decodes the data parameter, an frame encoded using the codec, into
 // this *message, m, or returns an error if one is encountered.
-func (m *message) jsonDecode(data []byte) error {
-	var decoded []interface{}
-	err := json.Unmarshal(data, &decoded)
+func (m *message) decode(codec Codec, data []byte) error {
+	decoded, err := codec.Unmarshal(data)
 	if err != nil {
-		return errors.New("Error decoding JSON; " + err.Error())
+		return errors.New("Error decoding message; " + err.Error())
 	}
+	return m.fromArray(decoded)
+}
 
+// fromArray decodes the already decoded array into this *message, m.
+func (m *message) fromArray(decoded []interface{}) error {
 	var ok bool
+	if len(decoded) > 0 {
+		if frame, isFrame := decoded[0].(string); isFrame {
+			return m.decodeFrame(frame, decoded[1:])
+		}
+	}
+
 	if len(decoded) == 1 {
 		// It's an response, in format of [id]
 		m.isRequest = false
 
-		m.id, ok = decoded[0].(float64)
+		m.id, ok = toFloat64(decoded[0])
 		if !ok {
-			return errors.New("Error decoding JSON; id is not an json number!")
+			return errors.New("Error decoding message; id is not an number!")
 		}
 		m.args = make([]interface{}, 0)
 
@@ -125,31 +314,136 @@ func (m *message) jsonDecode(data []byte) error {
 		// It's an response, in format of [id, args]
 		m.isRequest = false
 
-		m.id, ok = decoded[0].(float64)
+		m.id, ok = toFloat64(decoded[0])
 		if !ok {
-			return errors.New("Error decoding JSON; id is not an json number!")
+			return errors.New("Error decoding message; id is not an number!")
 		}
 
 		m.args, ok = decoded[1].([]interface{})
 		if !ok {
-			return errors.New("Error decoding JSON; args list is not an json array!")
+			return errors.New("Error decoding message; args list is not an array!")
 		}
 
 	} else if len(decoded) == 3 {
 		// It's an request, in format of [id, requestName, args]
 		m.isRequest = true
 
-		m.id, ok = decoded[0].(float64)
+		m.id, ok = toFloat64(decoded[0])
 		if !ok {
-			return errors.New("Error decoding JSON; id is not an json number!")
+			return errors.New("Error decoding message; id is not an number!")
 		}
 
 		m.requestName = decoded[1]
 
 		m.args, ok = decoded[2].([]interface{})
 		if !ok {
-			return errors.New("Error decoding JSON; args list is not an json array!")
+			return errors.New("Error decoding message; args list is not an array!")
 		}
 	}
 	return nil
 }
+
+// sequencedFrame tells weather frames of the type given by frame may be
+// sequenced, when the other end sends them.
+func sequencedFrame(frame string) bool {
+	switch frame {
+	case ftError, ftCancel, ftWatch, ftUnwatch:
+		return true
+	}
+	return false
+}
+
+// decodeFrame decodes the non-request, non-response frame whose type is given
+// by frame, and whose remaining elements are given by the decoded parameter.
+func (m *message) decodeFrame(frame string, decoded []interface{}) error {
+	var ok bool
+	switch frame {
+	case ftError:
+		// It's an error response, in format of ["e", id, error]
+		if len(decoded) != 2 {
+			return errors.New("Error decoding message; error response must be array of length 3!")
+		}
+		m.isRequest = false
+
+		m.id, ok = toFloat64(decoded[0])
+		if !ok {
+			return errors.New("Error decoding message; id is not an number!")
+		}
+
+		e, ok := decoded[1].(map[string]interface{})
+		if !ok {
+			return errors.New("Error decoding message; error is not an object!")
+		}
+		m.err = new(Error)
+		code, _ := toFloat64(e["code"])
+		m.err.Code = int(code)
+		m.err.Message, _ = e["message"].(string)
+		m.err.Data = e["data"]
+		return nil
+
+	case ftSequenced:
+		// It's an sequenced message, in format of ["s", seq, message]
+		if len(decoded) != 2 {
+			return errors.New("Error decoding message; sequenced message must be array of length 3!")
+		}
+		m.seq, ok = toFloat64(decoded[0])
+		if !ok || m.seq < 1 {
+			return errors.New("Error decoding message; seq is not an positive number!")
+		}
+		inner, ok := decoded[1].([]interface{})
+		if !ok {
+			return errors.New("Error decoding message; sequenced message is not an array!")
+		}
+		if len(inner) > 0 {
+			if frame, isFrame := inner[0].(string); isFrame && !sequencedFrame(frame) {
+				return errors.New("Error decoding message; sequenced message may not be an " + frame + " frame!")
+			}
+		}
+		return m.fromArray(inner)
+
+	case ftCancel:
+		// It's an cancellation, in format of ["c", id]
+		if len(decoded) != 1 {
+			return errors.New("Error decoding message; cancellation must be array of length 2!")
+		}
+		m.isCancel = true
+		m.id, ok = toFloat64(decoded[0])
+		if !ok {
+			return errors.New("Error decoding message; id is not an number!")
+		}
+		return nil
+
+	case ftWatch, ftUnwatch:
+		// It's an watch, in format of ["w", scope, key], or the opposite, in
+		// format of ["u", scope, key]
+		if len(decoded) != 2 {
+			return errors.New("Error decoding message; watch must be array of length 3!")
+		}
+		m.isWatch = frame == ftWatch
+		m.isUnwatch = frame == ftUnwatch
+
+		scope, _ := decoded[0].(string)
+		m.storeKey.scope, ok = scopeFromFrame(scope)
+		if !ok {
+			return errors.New("Error decoding message; scope is not valid!")
+		}
+		m.storeKey.key, ok = decoded[1].(string)
+		if !ok {
+			return errors.New("Error decoding message; key is not an string!")
+		}
+		return nil
+
+	case ftAck:
+		// It's an acknowledgement, in format of ["a", seq]
+		if len(decoded) != 1 {
+			return errors.New("Error decoding message; acknowledgement must be array of length 2!")
+		}
+		m.isAck = true
+		m.seq, ok = toFloat64(decoded[0])
+		if !ok {
+			return errors.New("Error decoding message; seq is not an number!")
+		}
+		return nil
+	}
+	return errors.New("Error decoding message; unknown frame type " + frame)
+}
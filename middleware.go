@@ -0,0 +1,81 @@
+// Copyright 2012 Lightpoke. All rights reserved.
+// This source code is subject to the terms and
+// conditions defined in the "License.txt" file.
+
+package organics
+
+import (
+	"context"
+)
+
+// Call describes an single request which has come in from the other end of an
+// connection, and is about to be handled.
+type Call struct {
+	// The name of the request.
+	Name interface{}
+
+	// The request arguments, as they where decoded by the connection's codec
+	// (JSON, unless an other codec was negotiated, see Server.SetCodec).
+	Args []interface{}
+
+	// The connection which the request came in on.
+	Connection *Connection
+
+	// The context which the request handler is given, an middleware may
+	// replace it in order to pass request-scoped values on to the request
+	// handler (e.g. using context.WithValue), or to set an deadline.
+	Context context.Context
+}
+
+// Invoker invokes the request described by the call, and returns the response
+// arguments, or an error which is sent back as an error response (see the
+// Error type).
+type Invoker func(call *Call) (results []interface{}, err error)
+
+// Middleware is invoked for every request which comes in, in place of the
+// request handler. The next parameter invokes the next middleware in the
+// chain, or the request handler itself if there are no more.
+//
+// An middleware may inspect or modify the call before passing it on, inspect
+// or modify the results afterwards, or short-circuit the request entirely by
+// returning without calling next at all. For example:
+//
+//  s.Use(func(call *organics.Call, next organics.Invoker) ([]interface{}, error) {
+//      if call.Connection.Session().Get("user", nil) == nil {
+//          return nil, &organics.Error{Code: 401, Message: "not logged in"}
+//      }
+//      return next(call)
+//  })
+//
+// Panics are recovered (whether they happen in the request handler or any
+// middleware) and sent back as an CodeHandlerPanic error response.
+type Middleware func(call *Call, next Invoker) (results []interface{}, err error)
+
+// Use adds the middleware to the end of this server's middleware chain.
+//
+// Middleware is invoked in the order in which it was added; the first one
+// added is the first one to see each request. The special Connect handler is
+// never invoked through middleware.
+func (s *Server) Use(middleware Middleware) {
+	s.access.Lock()
+	defer s.access.Unlock()
+
+	s.middleware = append(s.middleware, middleware)
+}
+
+// invoker returns an Invoker which invokes the request through each
+// middleware, and finally through the innermost Invoker given.
+func (s *Server) invoker(innermost Invoker) Invoker {
+	s.access.RLock()
+	middleware := s.middleware
+	s.access.RUnlock()
+
+	invoke := innermost
+	for i := len(middleware) - 1; i >= 0; i-- {
+		m, next := middleware[i], invoke
+		invoke = func(call *Call) ([]interface{}, error) {
+			return m(call, next)
+		}
+	}
+	return invoke
+}
@@ -0,0 +1,110 @@
+// Copyright 2012 Lightpoke. All rights reserved.
+// This source code is subject to the terms and
+// conditions defined in the "License.txt" file.
+
+package organics_test
+
+import (
+	"strings"
+	"sync"
+	"testing"
+
+	"golang.org/x/net/websocket"
+
+	"github.com/sinni800/organics"
+)
+
+func TestMiddleware(t *testing.T) {
+	s := newServer(t)
+
+	var access sync.Mutex
+	var order []string
+	trace := func(name string) {
+		access.Lock()
+		defer access.Unlock()
+		order = append(order, name)
+	}
+	s.Use(func(call *organics.Call, next organics.Invoker) ([]interface{}, error) {
+		trace("outer")
+		if call.Name == "Denied" {
+			return nil, &organics.Error{Code: 401, Message: "denied"}
+		}
+		results, err := next(call)
+		if err == nil {
+			results = append(results, "wrapped")
+		}
+		return results, err
+	})
+	s.Use(func(call *organics.Call, next organics.Invoker) ([]interface{}, error) {
+		trace("inner")
+		if call.Name == "MiddlewarePanic" {
+			panic("oops")
+		}
+		call.Args = []interface{}{"changed"}
+		return next(call)
+	})
+	s.Handle("Echo", func(v string, c *organics.Connection) string {
+		return v
+	})
+
+	c, _ := s.dial(t, s.wsURL())
+	results := make(chan []interface{}, 1)
+	c.Request("Echo", "original", func(v ...interface{}) {
+		results <- v
+	})
+	if v := receive(t, results); len(v) != 2 || v[0] != "changed" || v[1] != "wrapped" {
+		t.Fatalf("Echo = %v, want [changed wrapped]", v)
+	}
+
+	failed := make(chan *organics.Error, 1)
+	c.Request("Denied", func(err *organics.Error) {
+		failed <- err
+	})
+	if err := receive(t, failed); err.Code != 401 {
+		t.Fatalf("Denied got %v, want code 401", err)
+	}
+	c.Request("MiddlewarePanic", func(err *organics.Error) {
+		failed <- err
+	})
+	if err := receive(t, failed); err.Code != organics.CodeHandlerPanic {
+		t.Fatalf("MiddlewarePanic got %v, want CodeHandlerPanic", err)
+	}
+
+	access.Lock()
+	defer access.Unlock()
+	if got := strings.Join(order, " "); got != "outer inner outer outer inner" {
+		t.Fatalf("middleware order = %q", got)
+	}
+}
+
+func TestInvalidRequestName(t *testing.T) {
+	s := newServer(t)
+	s.Handle("Echo", func(v string, c *organics.Connection) string {
+		return v
+	})
+	s.Use(func(call *organics.Call, next organics.Invoker) ([]interface{}, error) {
+		return next(call)
+	})
+
+	ws, _ := s.rawWebSocket(t)
+	for _, frame := range []string{`[1,[1,2],[]]`, `[2,{"a":1},[]]`, `[3,"Echo",["still alive"]]`} {
+		if err := websocket.Message.Send(ws, frame); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var want = []string{
+		`["e",1,{"code":3,"message":"no handler for request [1 2]"}]`,
+		`["e",2,{"code":3,"message":"no handler for request map[a:1]"}]`,
+		`[3,["still alive"]]`,
+	}
+	for _, w := range want {
+		var frame string
+		if err := websocket.Message.Receive(ws, &frame); err != nil {
+			t.Fatal(err)
+		}
+		if frame != w {
+			t.Fatalf("got frame %s, want %s", frame, w)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+// Copyright 2012 Lightpoke. All rights reserved.
+// This source code is subject to the terms and
+// conditions defined in the "License.txt" file.
+
+package organics
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// ErrNoProgress is returned by Progress when the context is not an request
+// handler's, or when the request wants no response (and as such, no partial
+// results either).
+var ErrNoProgress = errors.New("organics: context is not of an request which wants an response")
+
+// progressKey is the context key which the request's progress is stored under.
+type progressKey struct{}
+
+// requestProgress sends the partial results of an single request from the
+// other end, until it's request handler returns.
+type requestProgress struct {
+	access     sync.Mutex
+	connection *Connection
+	id         float64
+	done       bool
+}
+
+// finish stops any more partial results from being sent, it is called before
+// the response is, so that none arrive after it.
+func (p *requestProgress) finish() {
+	p.access.Lock()
+	defer p.access.Unlock()
+
+	p.done = true
+}
+
+// withProgress returns an context derived from ctx which Progress can send
+// partial results of the request with, and it's progress.
+func withProgress(ctx context.Context, request *message, connection *Connection) (context.Context, *requestProgress) {
+	p := &requestProgress{
+		connection: connection,
+		id:         request.id,
+	}
+	return context.WithValue(ctx, progressKey{}, p), p
+}
+
+// Progress sends an partial result (e.g. an progress percentage, an search
+// hit, an log line) of the request whose handler was given ctx, to the other
+// end of the connection, where it is given to the request's progress function.
+// The values are sent just like the values an request handler returns are.
+//
+// Any number of partial results may be sent while the handler runs, they
+// arrive in order, and before the response does. For example:
+//
+//  s.Handle("Export", func(ctx context.Context, table string, c *organics.Connection) (string, error) {
+//      for i := 0; i < 100; i++ {
+//          ... export the next row ...
+//          if err := organics.Progress(ctx, i); err != nil {
+//              return "", err
+//          }
+//      }
+//      return url, nil
+//  })
+//
+// The other end receives nothing when the request wants no response, in which
+// case ErrNoProgress is returned (as it is when ctx is not an request
+// handler's context). Once the context is done (the request was cancelled,
+// the handler returned, etc) it's error is returned instead.
+func Progress(ctx context.Context, values ...interface{}) error {
+	p, ok := ctx.Value(progressKey{}).(*requestProgress)
+	if !ok || p.id == -1 {
+		return ErrNoProgress
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	p.access.Lock()
+	defer p.access.Unlock()
+
+	if p.done {
+		return context.Canceled
+	}
+	if values == nil {
+		values = make([]interface{}, 0)
+	}
+	p.connection.outgoing.push(newProgressMessage(p.id, values))
+	return nil
+}
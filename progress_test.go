@@ -0,0 +1,82 @@
+// Copyright 2012 Lightpoke. All rights reserved.
+// This source code is subject to the terms and
+// conditions defined in the "License.txt" file.
+
+package organics_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/sinni800/organics"
+)
+
+func TestProgress(t *testing.T) {
+	s := newServer(t)
+	s.Handle("Export", func(ctx context.Context, rows float64, c *organics.Connection) string {
+		for i := 0; i < int(rows); i++ {
+			if err := organics.Progress(ctx, i, "row"); err != nil {
+				return err.Error()
+			}
+		}
+		return "done"
+	})
+	late := make(chan error, 1)
+	s.Handle("Late", func(ctx context.Context, c *organics.Connection) {
+		go func() {
+			time.Sleep(50 * time.Millisecond)
+			late <- organics.Progress(ctx, 1)
+		}()
+	})
+	unwanted := make(chan error, 1)
+	s.Handle("Unwanted", func(ctx context.Context, c *organics.Connection) {
+		unwanted <- organics.Progress(ctx, 1)
+	})
+
+	if err := organics.Progress(context.Background()); err != organics.ErrNoProgress {
+		t.Fatalf("Progress() outside of an handler = %v, want ErrNoProgress", err)
+	}
+
+	for name, url := range s.urls() {
+		t.Run(name, func(t *testing.T) {
+			c, _ := s.dial(t, url)
+
+			results := make(chan string, 100)
+			c.Request("Export", 50, func(i float64, row string) {
+				results <- fmt.Sprint(i, row)
+			}, func(result string) {
+				results <- result
+			})
+			for i := 0; i < 50; i++ {
+				if r := receive(t, results); r != fmt.Sprint(i, "row") {
+					t.Fatalf("partial result %d = %q", i, r)
+				}
+			}
+			if r := receive(t, results); r != "done" {
+				t.Fatalf("result = %q, want done", r)
+			}
+
+			// Without an progress function, partial results are ignored.
+			c.Request("Export", 3, func(result string) {
+				results <- result
+			})
+			if r := receive(t, results); r != "done" {
+				t.Fatalf("result = %q, want done", r)
+			}
+
+			// Once the handler has returned, it's too late.
+			c.Request("Late", func() {})
+			if err := receive(t, late); err != context.Canceled {
+				t.Fatalf("Progress() after returning = %v, want context.Canceled", err)
+			}
+
+			// Requests which want no response want no partial results either.
+			c.Request("Unwanted")
+			if err := receive(t, unwanted); err != organics.ErrNoProgress {
+				t.Fatalf("Progress() without response = %v, want ErrNoProgress", err)
+			}
+		})
+	}
+}
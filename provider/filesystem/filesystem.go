@@ -13,10 +13,16 @@ import (
 	"net/url"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 )
 
+// The expiry of an session is stored next to it's data, in an file with this
+// suffix.
+const expirySuffix = ".expiry"
+
 func stringSections(s string, n int) []string {
 	sections := make([]string, 0)
 	for len(s) > n {
@@ -33,9 +39,20 @@ func sessionKeyToPath(key string) string {
 	return filepath.Join(sections...)
 }
 
+func pathToSessionKey(path string) (string, error) {
+	return url.QueryUnescape(strings.Replace(path, string(os.PathSeparator), "", -1))
+}
+
+// makeDirFor creates the directory which the file at path goes in, unless it
+// exists already.
+func makeDirFor(path string) error {
+	return os.MkdirAll(filepath.Dir(path), 0777)
+}
+
 type provider struct {
 	access           sync.RWMutex
 	stores           map[string]*organics.Store
+	expiries         map[string]organics.Expiry
 	fileWritingLocks map[string]*sync.Mutex
 	directory        string
 }
@@ -78,11 +95,7 @@ func (p *provider) Save(key string, whatChanged string, s *organics.Store) error
 
 	if _, err := os.Stat(keyPath); err != nil {
 		if os.IsNotExist(err) {
-			keyPathDir := strings.Split(keyPath, string(os.PathSeparator))
-			keyPathDir = keyPathDir[:len(keyPathDir)-1]
-			dirPath := filepath.Join(keyPathDir...)
-
-			err = os.MkdirAll(dirPath, os.ModeDir)
+			err = makeDirFor(keyPath)
 			if err != nil {
 				return fmt.Errorf("Error saving session %v", err)
 			}
@@ -105,6 +118,10 @@ func (p *provider) Save(key string, whatChanged string, s *organics.Store) error
 }
 
 func (p *provider) Load(key string) *organics.Store {
+	if p.expiry(key).Expired(time.Now()) {
+		return nil
+	}
+
 	store, ok := p.store(key)
 	if ok {
 		// We have it in memory already
@@ -137,19 +154,165 @@ func (p *provider) Load(key string) *organics.Store {
 		log.Println("Error decoding session file", err)
 		return nil
 	}
-	p.stores[key] = store
+	p.setStore(key, store)
 	return store
 }
 
+// expiry returns the expiry of the session, which is read from it's file
+// unless it is in memory already.
+func (p *provider) expiry(key string) organics.Expiry {
+	p.access.RLock()
+	expiry, ok := p.expiries[key]
+	p.access.RUnlock()
+	if ok {
+		return expiry
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(p.directory, sessionKeyToPath(key)) + expirySuffix)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Println("Error reading session expiry file", err)
+		}
+		return expiry
+	}
+	expiry, err = decodeExpiry(data)
+	if err != nil {
+		log.Println("Error decoding session expiry file", err)
+		return expiry
+	}
+
+	p.access.Lock()
+	p.expiries[key] = expiry
+	p.access.Unlock()
+	return expiry
+}
+
+// encodeExpiry encodes the expiry as two unix times in nanoseconds, zero for
+// an zero time.
+func encodeExpiry(e organics.Expiry) []byte {
+	unix := func(t time.Time) int64 {
+		if t.IsZero() {
+			return 0
+		}
+		return t.UnixNano()
+	}
+	return []byte(fmt.Sprintf("%d %d\n", unix(e.Idle), unix(e.Absolute)))
+}
+
+// decodeExpiry decodes an expiry which was encoded using encodeExpiry.
+func decodeExpiry(data []byte) (organics.Expiry, error) {
+	var e organics.Expiry
+	fields := strings.Fields(string(data))
+	if len(fields) != 2 {
+		return e, fmt.Errorf("Invalid session expiry %q", data)
+	}
+	for i, t := range []*time.Time{&e.Idle, &e.Absolute} {
+		n, err := strconv.ParseInt(fields[i], 10, 64)
+		if err != nil {
+			return e, err
+		}
+		if n != 0 {
+			*t = time.Unix(0, n)
+		}
+	}
+	return e, nil
+}
+
+func (p *provider) Delete(key string) error {
+	lock := p.getWriteLock(key)
+	lock.Lock()
+	defer lock.Unlock()
+
+	p.access.Lock()
+	delete(p.stores, key)
+	delete(p.expiries, key)
+	delete(p.fileWritingLocks, key)
+	p.access.Unlock()
+
+	keyPath := filepath.Join(p.directory, sessionKeyToPath(key))
+	for _, path := range []string{keyPath, keyPath + expirySuffix} {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("Error deleting session %v", err)
+		}
+	}
+
+	// Remove the directories which are left empty, up to (but not including)
+	// our own.
+	for dir := filepath.Dir(keyPath); dir != filepath.Clean(p.directory); dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			break
+		}
+	}
+	return nil
+}
+
+func (p *provider) Expire(key string, idle, absolute time.Time) error {
+	expiry := p.expiry(key).Update(idle, absolute)
+
+	lock := p.getWriteLock(key)
+	lock.Lock()
+	defer lock.Unlock()
+
+	p.access.Lock()
+	p.expiries[key] = expiry
+	p.access.Unlock()
+
+	expiryPath := filepath.Join(p.directory, sessionKeyToPath(key)) + expirySuffix
+	if err := makeDirFor(expiryPath); err != nil {
+		return fmt.Errorf("Error saving session expiry %v", err)
+	}
+	if err := ioutil.WriteFile(expiryPath, encodeExpiry(expiry), 0666); err != nil {
+		return fmt.Errorf("Error saving session expiry %v", err)
+	}
+	return nil
+}
+
+func (p *provider) Expiry(key string) (organics.Expiry, error) {
+	return p.expiry(key), nil
+}
+
+func (p *provider) Sweep(now time.Time) ([]string, error) {
+	var expired []string
+	err := filepath.Walk(p.directory, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || !strings.HasSuffix(path, expirySuffix) {
+			return err
+		}
+		rel, err := filepath.Rel(p.directory, strings.TrimSuffix(path, expirySuffix))
+		if err != nil {
+			return err
+		}
+		key, err := pathToSessionKey(rel)
+		if err != nil {
+			log.Println("Error decoding session expiry file name", err)
+			return nil
+		}
+		if p.expiry(key).Expired(now) {
+			expired = append(expired, key)
+		}
+		return nil
+	})
+
+	var swept []string
+	for _, key := range expired {
+		if err := p.Delete(key); err != nil {
+			log.Println(err)
+			continue
+		}
+		swept = append(swept, key)
+	}
+	return swept, err
+}
+
 func Provider(directory string) (organics.SessionProvider, error) {
 	p := new(provider)
 	p.stores = make(map[string]*organics.Store)
+	p.expiries = make(map[string]organics.Expiry)
 	p.fileWritingLocks = make(map[string]*sync.Mutex)
 	p.directory = directory
 
 	if _, err := os.Stat(directory); err != nil {
 		if os.IsNotExist(err) {
-			err = os.MkdirAll(directory, os.ModeDir)
+			err = os.MkdirAll(directory, 0777)
 			if err != nil {
 				return nil, err
 			}
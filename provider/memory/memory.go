@@ -8,11 +8,13 @@ package memory
 import (
 	"github.com/sinni800/organics"
 	"sync"
+	"time"
 )
 
 type provider struct {
 	access   sync.RWMutex
 	sessions map[string]*organics.Store
+	expiries map[string]organics.Expiry
 }
 
 func (p *provider) Save(key string, whatChanged string, s *organics.Store) error {
@@ -41,14 +43,54 @@ func (p *provider) Load(key string) *organics.Store {
 	defer p.access.RUnlock()
 
 	s, ok := p.sessions[key]
-	if !ok {
+	if !ok || p.expiries[key].Expired(time.Now()) {
 		return nil
 	}
 	return s.Copy()
 }
 
+func (p *provider) Delete(key string) error {
+	p.access.Lock()
+	defer p.access.Unlock()
+
+	delete(p.sessions, key)
+	delete(p.expiries, key)
+	return nil
+}
+
+func (p *provider) Expire(key string, idle, absolute time.Time) error {
+	p.access.Lock()
+	defer p.access.Unlock()
+
+	p.expiries[key] = p.expiries[key].Update(idle, absolute)
+	return nil
+}
+
+func (p *provider) Expiry(key string) (organics.Expiry, error) {
+	p.access.RLock()
+	defer p.access.RUnlock()
+
+	return p.expiries[key], nil
+}
+
+func (p *provider) Sweep(now time.Time) ([]string, error) {
+	p.access.Lock()
+	defer p.access.Unlock()
+
+	var swept []string
+	for key, expiry := range p.expiries {
+		if expiry.Expired(now) {
+			delete(p.sessions, key)
+			delete(p.expiries, key)
+			swept = append(swept, key)
+		}
+	}
+	return swept, nil
+}
+
 func Provider() organics.SessionProvider {
 	p := new(provider)
 	p.sessions = make(map[string]*organics.Store)
+	p.expiries = make(map[string]organics.Expiry)
 	return p
 }
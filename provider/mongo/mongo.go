@@ -10,8 +10,41 @@ import (
 	"labix.org/v2/mgo"
 	"labix.org/v2/mgo/bson"
 	"log"
+	"time"
 )
 
+// The fields which hold the expiry of an session, as unix times in
+// nanoseconds (zero for an zero time), next to it's data.
+const (
+	idleField     = "_idle"
+	absoluteField = "_absolute"
+)
+
+// unixNano returns t as an unix time in nanoseconds, or zero for an zero time.
+func unixNano(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+// fromUnixNano is the reverse of unixNano.
+func fromUnixNano(v interface{}) time.Time {
+	n, _ := v.(int64)
+	if n == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, n)
+}
+
+// expiry returns the expiry of the session whose document is m.
+func expiry(m bson.M) organics.Expiry {
+	return organics.Expiry{
+		Idle:     fromUnixNano(m[idleField]),
+		Absolute: fromUnixNano(m[absoluteField]),
+	}
+}
+
 type provider struct {
 	collection *mgo.Collection
 }
@@ -58,10 +91,14 @@ func (p *provider) Load(key string) *organics.Store {
 		return nil
 	}
 
+	if expiry(m).Expired(time.Now()) {
+		return nil
+	}
+
 	s := organics.NewStore()
 
 	for k, v := range m {
-		if k != "_id" {
+		if k != "_id" && k != idleField && k != absoluteField {
 			s.Set(k, v)
 		}
 	}
@@ -69,6 +106,61 @@ func (p *provider) Load(key string) *organics.Store {
 	return s
 }
 
+func (p *provider) Delete(key string) error {
+	err := p.collection.Remove(bson.M{"session": key})
+	if err == mgo.ErrNotFound {
+		return nil
+	}
+	return err
+}
+
+func (p *provider) Expire(key string, idle, absolute time.Time) error {
+	e, err := p.Expiry(key)
+	if err != nil {
+		return err
+	}
+	e = e.Update(idle, absolute)
+
+	_, err = p.collection.Upsert(bson.M{"session": key}, bson.M{"$set": bson.M{
+		idleField:     unixNano(e.Idle),
+		absoluteField: unixNano(e.Absolute),
+	}})
+	return err
+}
+
+func (p *provider) Expiry(key string) (organics.Expiry, error) {
+	m := make(bson.M)
+	err := p.collection.Find(bson.M{"session": key}).One(&m)
+	if err != nil && err != mgo.ErrNotFound {
+		return organics.Expiry{}, err
+	}
+	return expiry(m), nil
+}
+
+func (p *provider) Sweep(now time.Time) ([]string, error) {
+	n := now.UnixNano()
+	expired := bson.M{"$or": []bson.M{
+		{idleField: bson.M{"$gt": 0, "$lte": n}},
+		{absoluteField: bson.M{"$gt": 0, "$lte": n}},
+	}}
+
+	var docs []bson.M
+	err := p.collection.Find(expired).Select(bson.M{"session": 1}).All(&docs)
+	if err != nil {
+		return nil, err
+	}
+
+	var swept []string
+	for _, doc := range docs {
+		key, _ := doc["session"].(string)
+		if err := p.Delete(key); err != nil {
+			return swept, err
+		}
+		swept = append(swept, key)
+	}
+	return swept, nil
+}
+
 func Provider(c *mgo.Collection) organics.SessionProvider {
 	p := new(provider)
 	p.collection = c
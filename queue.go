@@ -0,0 +1,204 @@
+// Copyright 2012 Lightpoke. All rights reserved.
+// This source code is subject to the terms and
+// conditions defined in the "License.txt" file.
+
+package organics
+
+import (
+	"sync"
+)
+
+// QueuePolicy describes what happens when an connection's outgoing queue is
+// full, see Server.SetQueuePolicy().
+type QueuePolicy uint8
+
+const (
+	// Sending an message (an request, or the response to one) blocks until
+	// there is space in the queue, or until the connection is dead. It is
+	// never the default, see Server.SetQueuePolicy.
+	QueueBlock QueuePolicy = iota
+
+	// The oldest message in the queue is dropped, to make space for the new
+	// one.
+	//
+	// An dropped response to an request from the other end is never seen by
+	// it, so the other end's request completion function is never called
+	// (and leaks, see Server.SetQueuePolicy).
+	QueueDropOldest
+
+	// The new message is dropped.
+	//
+	// An dropped response to an request from the other end is never seen by
+	// it, so the other end's request completion function is never called
+	// (and leaks, see Server.SetQueuePolicy).
+	QueueDropNewest
+
+	// The connection is killed.
+	QueueKill
+)
+
+// String returns an string formatted version of the specified policy, or an
+// empty string if the policy is invalid (unknown).
+func (p QueuePolicy) String() string {
+	switch p {
+	case QueueBlock:
+		return "QueueBlock"
+
+	case QueueDropOldest:
+		return "QueueDropOldest"
+
+	case QueueDropNewest:
+		return "QueueDropNewest"
+
+	case QueueKill:
+		return "QueueKill"
+	}
+	return ""
+}
+
+// messageQueue is an first-in first-out queue of the messages (requests and
+// responses) which are to be sent to the other end of an connection.
+//
+// Messages are pushed without blocking (unless the queue is full, and it's
+// policy is QueueBlock), and the connection's writer (see serveWrite) pops
+// them off in the exact same order, which is the order they arrive in on the
+// other end, no matter the connection method.
+type messageQueue struct {
+	access   sync.Mutex
+	messages []*message
+
+	// The maximum number of messages (zero for no limit), and what happens
+	// once there are that many. The full function is called (without the
+	// lock held) each time the policy is triggered, with the message which
+	// was dropped (if any).
+	limit  int
+	policy QueuePolicy
+	full   func(policy QueuePolicy, dropped *message)
+
+	// ready receives an value once messages are queued, and space once
+	// messages are popped. Blocked pushes give up once done is closed.
+	ready, space chan bool
+	done         <-chan struct{}
+}
+
+// push adds the message to the back of the queue.
+func (q *messageQueue) push(m *message) {
+	triggered := false
+	for {
+		q.access.Lock()
+		if q.limit <= 0 || len(q.messages) < q.limit {
+			q.messages = append(q.messages, m)
+			q.access.Unlock()
+
+			select {
+			case q.ready <- true:
+			default:
+			}
+			return
+		}
+
+		switch q.policy {
+		case QueueDropOldest:
+			dropped := q.messages[0]
+			q.messages[0] = nil
+			q.messages = append(q.messages[1:], m)
+			q.access.Unlock()
+			q.full(q.policy, dropped)
+			return
+
+		case QueueDropNewest, QueueKill:
+			q.access.Unlock()
+			q.full(q.policy, m)
+			return
+		}
+		q.access.Unlock()
+
+		// QueueBlock, which is triggered once per message.
+		if !triggered {
+			triggered = true
+			q.full(q.policy, nil)
+		}
+		select {
+		case <-q.space:
+		case <-q.done:
+			return
+		}
+	}
+}
+
+// pop removes and returns the message at the front of the queue, or nil if the
+// queue is empty.
+func (q *messageQueue) pop() *message {
+	q.access.Lock()
+	defer q.access.Unlock()
+
+	if len(q.messages) == 0 {
+		return nil
+	}
+	m := q.messages[0]
+	q.messages[0] = nil
+	q.messages = q.messages[1:]
+	if len(q.messages) > 0 {
+		// Left for the next one.
+		select {
+		case q.ready <- true:
+		default:
+		}
+	}
+	select {
+	case q.space <- true:
+	default:
+	}
+	return m
+}
+
+// len returns the number of messages in the queue.
+func (q *messageQueue) len() int {
+	q.access.Lock()
+	defer q.access.Unlock()
+
+	return len(q.messages)
+}
+
+func newMessageQueue(done <-chan struct{}) *messageQueue {
+	q := new(messageQueue)
+	q.ready = make(chan bool, 1)
+	q.space = make(chan bool, 1)
+	q.done = done
+	q.full = func(policy QueuePolicy, dropped *message) {}
+	return q
+}
+
+// limitQueue applies the server's queue settings to the outgoing queue of the
+// new connection.
+func (s *Server) limitQueue(c *Connection) {
+	q := c.outgoing
+	q.limit = s.QueueSize()
+	q.policy = s.QueuePolicy()
+	q.full = func(policy QueuePolicy, dropped *message) {
+		s.queueFull(c, policy, dropped)
+	}
+}
+
+// queueFull is called each time the queue policy of the connection's outgoing
+// queue is triggered.
+func (s *Server) queueFull(c *Connection, policy QueuePolicy, dropped *message) {
+	logger().Println("Outgoing queue full |", policy, c)
+	if fn := s.QueueFullHandler(); fn != nil {
+		fn(c, policy)
+	}
+
+	if dropped != nil && dropped.isRequest && dropped.id != -1 {
+		// Whoever made the request is told it failed, just as if it failed on the
+		// other end.
+		go c.complete(newErrorMessage(dropped.id, &Error{
+			Code:    CodeQueueFull,
+			Message: "outgoing queue is full, request dropped",
+		}))
+	}
+	if policy == QueueKill {
+		// Not killed right away, the sender may be holding locks which dying
+		// needs (e.g. an Room's).
+		go c.kill(DisconnectBufferOverflow)
+	}
+}
@@ -0,0 +1,191 @@
+// Copyright 2012 Lightpoke. All rights reserved.
+// This source code is subject to the terms and
+// conditions defined in the "License.txt" file.
+
+package organics_test
+
+import (
+	"encoding/json"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sinni800/organics"
+	"github.com/sinni800/organics/client"
+)
+
+func TestRequestOrder(t *testing.T) {
+	s := newServer(t)
+	for name, url := range s.urls() {
+		t.Run(name, func(t *testing.T) {
+			c, sc := s.dial(t, url)
+			received := make(chan float64, 300)
+			c.Handle("Number", func(n float64, c *client.Connection) {
+				received <- n
+			})
+
+			for i := 0; i < cap(received); i++ {
+				sc.Request("Number", i)
+			}
+			for i := 0; i < cap(received); i++ {
+				if n := receive(t, received); n != float64(i) {
+					t.Fatalf("request %d arrived as number %v", i, n)
+				}
+			}
+		})
+	}
+}
+
+// stalledConnection connects to the server using raw long-polling, which does
+// not poll until the test does.
+func (ts *testServer) stalledConnection(t *testing.T) (*organics.Connection, func() []interface{}) {
+	lp := &lpClient{t: t, url: ts.http.URL}
+	lp.post("lpec", "")
+	poll := func() []interface{} {
+		_, body := lp.post("lp", "")
+		var frames []interface{}
+		if err := json.Unmarshal([]byte(body), &frames); err != nil {
+			t.Fatalf("poll response %q: %v", body, err)
+		}
+		return frames
+	}
+	return receive(t, ts.connected), poll
+}
+
+// requestArgument returns the first argument of the request frame.
+func requestArgument(frame interface{}) float64 {
+	return frame.([]interface{})[2].([]interface{})[0].(float64)
+}
+
+func TestQueueDefault(t *testing.T) {
+	s := newServer(t)
+	c, poll := s.stalledConnection(t)
+
+	// Without an queue size, sending never blocks nor drops anything.
+	sent := make(chan bool)
+	go func() {
+		for i := 0; i < 2000; i++ {
+			c.Request("Number", i)
+		}
+		close(sent)
+	}()
+	receive(t, sent)
+
+	var received []float64
+	for len(received) < 2000 {
+		for _, frame := range poll() {
+			received = append(received, requestArgument(frame))
+		}
+	}
+	for i, n := range received {
+		if n != float64(i) {
+			t.Fatalf("request %d arrived as number %v", i, n)
+		}
+	}
+}
+
+func TestQueuePolicies(t *testing.T) {
+	newQueueServer := func(t *testing.T, policy organics.QueuePolicy) *testServer {
+		s := newServer(t)
+		s.SetLongPollBatchSize(1)
+		s.SetQueueSize(3)
+		s.SetQueuePolicy(policy)
+		return s
+	}
+
+	t.Run("QueueDropNewest", func(t *testing.T) {
+		s := newQueueServer(t, organics.QueueDropNewest)
+		var triggered int64
+		s.SetQueueFullHandler(func(c *organics.Connection, policy organics.QueuePolicy) {
+			if policy != organics.QueueDropNewest {
+				t.Errorf("queue full handler got %v, want QueueDropNewest", policy)
+			}
+			atomic.AddInt64(&triggered, 1)
+		})
+		c, poll := s.stalledConnection(t)
+
+		dropped := make(chan *organics.Error, 10)
+		for i := 0; i < 10; i++ {
+			c.Request("Number", i, func(err *organics.Error) {
+				dropped <- err
+			})
+		}
+		// Three are queued, and maybe one is being written already.
+		n := 0
+		for n < 7 {
+			select {
+			case err := <-dropped:
+				if err.Code != organics.CodeQueueFull {
+					t.Fatalf("dropped request got %v, want CodeQueueFull", err)
+				}
+				n++
+				continue
+			case <-time.After(100 * time.Millisecond):
+			}
+			break
+		}
+		if n < 6 || int64(n) != atomic.LoadInt64(&triggered) {
+			t.Fatalf("%d requests dropped, queue full handler called %d times; want 6 or 7 of each", n, atomic.LoadInt64(&triggered))
+		}
+		if n := requestArgument(poll()[0]); n != 0 {
+			t.Fatalf("first request sent was number %v, want 0", n)
+		}
+	})
+
+	t.Run("QueueDropOldest", func(t *testing.T) {
+		s := newQueueServer(t, organics.QueueDropOldest)
+		c, poll := s.stalledConnection(t)
+		for i := 0; i < 10; i++ {
+			c.Request("Number", i)
+		}
+		var received []float64
+		for len(received) == 0 || received[len(received)-1] != 9 {
+			for _, frame := range poll() {
+				received = append(received, requestArgument(frame))
+			}
+		}
+		last := received[len(received)-3:]
+		if len(received) > 6 || last[0] != 7 || last[1] != 8 {
+			t.Fatalf("received %v, want the newest three last", received)
+		}
+	})
+
+	t.Run("QueueKill", func(t *testing.T) {
+		s := newQueueServer(t, organics.QueueKill)
+		c, _ := s.stalledConnection(t)
+		for i := 0; i < 10; i++ {
+			c.Request("Number", i)
+		}
+		eventually(t, "the connection is dead", c.Dead)
+	})
+
+	t.Run("QueueBlock", func(t *testing.T) {
+		s := newQueueServer(t, organics.QueueBlock)
+		c, poll := s.stalledConnection(t)
+		sent := make(chan bool)
+		go func() {
+			for i := 0; i < 10; i++ {
+				c.Request("Number", i)
+			}
+			close(sent)
+		}()
+		select {
+		case <-sent:
+			t.Fatal("sending to an full queue did not block")
+		case <-time.After(100 * time.Millisecond):
+		}
+
+		var received []float64
+		for len(received) < 10 {
+			for _, frame := range poll() {
+				received = append(received, requestArgument(frame))
+			}
+		}
+		receive(t, sent)
+		for i, n := range received {
+			if n != float64(i) {
+				t.Fatalf("request %d arrived as number %v", i, n)
+			}
+		}
+	})
+}
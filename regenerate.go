@@ -0,0 +1,168 @@
+// Copyright 2012 Lightpoke. All rights reserved.
+// This source code is subject to the terms and
+// conditions defined in the "License.txt" file.
+
+package organics
+
+import (
+	"net/http"
+	"time"
+)
+
+// The header which carries the ticket of an rtRekey request.
+const hdrTicket = "X-Organics-Ticket"
+
+// rekeyWindow is how long the old key of an regenerated session keeps working
+// for the session's own connections, and how long it's ticket may be redeemed
+// for, which gives them the time to learn the new key.
+const rekeyWindow = time.Minute
+
+// rekey is an session whose key was regenerated, see Session.Regenerate().
+type rekey struct {
+	session     *Session
+	old, ticket string
+}
+
+// sessionKey returns the key of this session, which changes when it is
+// regenerated.
+func (s *Session) sessionKey() string {
+	s.access.RLock()
+	defer s.access.RUnlock()
+
+	return s.key
+}
+
+// Regenerate gives this session an new key, e.g. once the user has signed in,
+// so that an key which was planted before (session fixation) is of no use
+// afterwards. The session keeps it's store and connections, only the key
+// changes.
+//
+// The session's data is moved to the new key in the session provider, and
+// every connection of this session is given the new key (their session cookie
+// is replaced, or in token mode they are sent the new token). The old key keeps
+// working for the session's own connections only, for an short while, to give
+// them the time to do so.
+//
+// If this session is dead, ErrDead is returned.
+func (s *Session) Regenerate() error {
+	if s.Dead() {
+		return ErrDead
+	}
+	server := s.server
+	key, err := server.generateSessionKey()
+	if err != nil {
+		return err
+	}
+	ticket, err := server.generateSessionKey()
+	if err != nil {
+		return err
+	}
+
+	s.access.Lock()
+	if s.dead {
+		s.access.Unlock()
+		return ErrDead
+	}
+	old := s.key
+	s.key = key
+	s.access.Unlock()
+
+	server.rekey(&rekey{session: s, old: old, ticket: ticket}, key)
+
+	// Move the data, and delete it from the old key, which must not find it
+	// anymore (session providers which cannot delete data have it erased
+	// instead).
+	sp := server.Provider()
+	se, canDelete := server.expirer()
+	erased := NewStore()
+	for _, k := range s.Store.Keys() {
+		if err := sp.Save(key, k, s.Store); err != nil {
+			logger().Println(err)
+		}
+		if !canDelete {
+			if err := sp.Save(old, k, erased); err != nil {
+				logger().Println(err)
+			}
+		}
+	}
+	if canDelete {
+		// It keeps it's absolute time, see expiry.go
+		server.moveExpiry(s, old)
+
+		if err := se.Delete(old); err != nil {
+			logger().Println(err)
+		}
+	}
+
+	for _, c := range s.Connections() {
+		if c.tokens {
+			c.outgoing.push(newTokenMessage(key))
+		} else {
+			c.outgoing.push(newRekeyMessage(ticket))
+		}
+	}
+	logger().Println("Regenerated", s)
+	return nil
+}
+
+// rekey caches the regenerated session under it's new key instead of it's old
+// one, and remembers it's old key and ticket for rekeyWindow.
+func (s *Server) rekey(r *rekey, key string) {
+	s.access.Lock()
+	defer s.access.Unlock()
+
+	delete(s.sessions, r.old)
+	s.sessions[key] = r.session
+	s.rekeyed[r.old] = r
+	s.tickets[r.ticket] = r
+
+	time.AfterFunc(rekeyWindow, func() {
+		s.access.Lock()
+		defer s.access.Unlock()
+
+		delete(s.rekeyed, r.old)
+		delete(s.tickets, r.ticket)
+	})
+}
+
+// rekeyedSession returns the session which the request's session key was the
+// old key of, if it was regenerated less than rekeyWindow ago.
+func (s *Server) rekeyedSession(req *http.Request) (*Session, bool) {
+	key, ok := s.requestSessionKey(req)
+	if !ok {
+		return nil, false
+	}
+
+	s.access.RLock()
+	defer s.access.RUnlock()
+
+	r, ok := s.rekeyed[key]
+	if !ok {
+		return nil, false
+	}
+	return r.session, true
+}
+
+// lpHandleRekey handles an rtRekey request, which an connection makes once it
+// was sent the ticket of it's regenerated session. It's response sets the
+// session cookie to the new key.
+//
+// The ticket is only known to the session's connections, and the request must
+// carry the session's old (or new) key as well, so neither is of use without
+// the other.
+func (s *Server) lpHandleRekey(w http.ResponseWriter, req *http.Request) {
+	key, _ := s.requestSessionKey(req)
+
+	s.access.RLock()
+	r, ok := s.tickets[req.Header.Get(hdrTicket)]
+	s.access.RUnlock()
+
+	if !ok || (key != r.old && key != r.session.sessionKey()) {
+		logger().Println("bad request | rekey with invalid ticket or session")
+		w.WriteHeader(http.StatusBadRequest)
+		req.Close = true
+		return
+	}
+	http.SetCookie(w, s.SessionCookie().cookie(r.session.sessionKey()))
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,121 @@
+// Copyright 2012 Lightpoke. All rights reserved.
+// This source code is subject to the terms and
+// conditions defined in the "License.txt" file.
+
+package organics_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/websocket"
+
+	"github.com/sinni800/organics"
+	"github.com/sinni800/organics/provider/memory"
+)
+
+func TestRegenerate(t *testing.T) {
+	provider := memory.Provider()
+	s := newProviderServer(t, provider)
+	post := func(requestType string, header http.Header) (*http.Response, string) {
+		t.Helper()
+		req, err := http.NewRequest("POST", s.http.URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for key, values := range header {
+			req.Header[key] = values
+		}
+		req.Header.Set("X-Organics-Req", requestType)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		data, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp, string(data)
+	}
+
+	resp, id := post("lpec", nil)
+	oldKey := resp.Cookies()[0].Value
+	oldCookie := "organics-session=" + oldKey
+	session := receive(t, s.connected).Session()
+	session.Set("secret", "password")
+	eventually(t, "the session is saved", func() bool {
+		store := provider.Load(oldKey)
+		return store != nil && store.Has("secret")
+	})
+	if err := session.Regenerate(); err != nil {
+		t.Fatalf("Regenerate() = %v", err)
+	}
+
+	// The next poll carries the new cookie, and an rekey frame with an ticket
+	// for other tabs still holding the old one.
+	resp, body := post("lp", http.Header{"X-Organics-Conn": {id}, "Cookie": {oldCookie}})
+	if resp.StatusCode != http.StatusOK || len(resp.Cookies()) != 1 || resp.Cookies()[0].Value == oldKey {
+		t.Fatalf("got status %v and cookies %v, want an new session cookie", resp.Status, resp.Cookies())
+	}
+	newKey := resp.Cookies()[0].Value
+	var frames [][]interface{}
+	if err := json.Unmarshal([]byte(body), &frames); err != nil || len(frames) != 1 || frames[0][0] != "k" {
+		t.Fatalf("got frames %s (%v), want an rekey frame", body, err)
+	}
+	ticket := frames[0][1].(string)
+
+	if resp, _ := post("rk", http.Header{"X-Organics-Ticket": {ticket}}); resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("redeemed an ticket without the old cookie, status %v", resp.Status)
+	}
+	if resp, _ := post("rk", http.Header{"X-Organics-Ticket": {"forged"}, "Cookie": {oldCookie}}); resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("redeemed an forged ticket, status %v", resp.Status)
+	}
+	resp, _ = post("rk", http.Header{"X-Organics-Ticket": {ticket}, "Cookie": {oldCookie}})
+	if resp.StatusCode != http.StatusOK || resp.Cookies()[0].Value != newKey {
+		t.Fatalf("got status %v and cookies %v, want the new session cookie", resp.Status, resp.Cookies())
+	}
+
+	// Without an connection, the old key is worthless.
+	if resp, _ := post("m", http.Header{"X-Organics-Conn": {"unknown"}, "Cookie": {oldCookie}}); resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("got status %v for an unknown connection, want 400", resp.Status)
+	}
+	post("lpec", http.Header{"Cookie": {oldCookie}})
+	if other := receive(t, s.connected).Session(); other == session || other.Has("secret") {
+		t.Fatal("the old key still selects the session")
+	}
+	if store := provider.Load(newKey); store == nil || !store.Has("secret") {
+		t.Fatal("the session was not moved to it's new key")
+	}
+	post("lpec", http.Header{"Cookie": {"organics-session=" + newKey}})
+	if receive(t, s.connected).Session() != session {
+		t.Fatal("the new key does not select the session")
+	}
+}
+
+func TestRegenerateTokens(t *testing.T) {
+	s := newServer(t)
+	s.SetSessionTokens(true)
+	ws, c := s.rawWebSocket(t, "organics.json", "organics-session")
+	var first, second string
+	if err := websocket.Message.Receive(ws, &first); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Session().Regenerate(); err != nil {
+		t.Fatalf("Regenerate() = %v", err)
+	}
+	if err := websocket.Message.Receive(ws, &second); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(second, `["t","`) || second == first {
+		t.Fatalf("got frame %s after %s, want an new token", second, first)
+	}
+
+	c.Session().Kill()
+	if err := c.Session().Regenerate(); err != organics.ErrDead {
+		t.Fatalf("Regenerate() of an dead session = %v, want ErrDead", err)
+	}
+}
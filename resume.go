@@ -0,0 +1,272 @@
+// Copyright 2012 Lightpoke. All rights reserved.
+// This source code is subject to the terms and
+// conditions defined in the "License.txt" file.
+
+package organics
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Query parameters which an client uses to ask for an resumable connection.
+//
+// The organics-resume parameter is present (but empty) when an new resumable
+// connection is wanted, or holds the key of the connection to resume. The
+// organics-ack parameter holds the sequence number of the last message the
+// client received on the connection it is resuming.
+const (
+	qpResume = "organics-resume"
+	qpAck    = "organics-ack"
+)
+
+// Acknowledge received messages at least this often, without waiting for an
+// ping.
+const ackInterval = 16
+
+var errOutOfSequence = errors.New("organics: sequenced message received out of sequence")
+
+// resumeState is the state an resumable connection keeps in order to send
+// messages the other end missed again, and to ignore messages sent to us more
+// than once.
+type resumeState struct {
+	access sync.Mutex
+
+	// Sequence number of the last message sent, and of the last one which the
+	// other end acknowledged. Every message in between is in the buffer, not
+	// yet encoded, as an transport which resumes the connection may use an
+	// different codec.
+	sent, acked int64
+	buffer      [][]interface{}
+
+	// Sequence number of the last message received, and of the last one which
+	// we acknowledged.
+	received, receivedAcked int64
+
+	// Receives an value when acknowledgements free up buffer space, or when
+	// an acknowledgement should be sent.
+	space, ackWanted chan bool
+
+	// Closed once the current transport has been detached, or once the
+	// detached connection has been resumed.
+	detached, resumed chan bool
+}
+
+// sequence assigns the next sequence number to the message, stores it until it
+// is acknowledged, and returns the frame to send.
+func (r *resumeState) sequence(m *message) []interface{} {
+	r.access.Lock()
+	defer r.access.Unlock()
+
+	r.sent++
+	frame := []interface{}{ftSequenced, r.sent, m.array()}
+	r.buffer = append(r.buffer, frame)
+	return frame
+}
+
+// full tells weather the buffer cannot hold another message.
+func (r *resumeState) full(size int) bool {
+	r.access.Lock()
+	defer r.access.Unlock()
+
+	return len(r.buffer) >= size
+}
+
+// acknowledge removes every message up to and including seq from the buffer.
+func (r *resumeState) acknowledge(seq int64) {
+	r.access.Lock()
+	defer r.access.Unlock()
+
+	if seq <= r.acked {
+		return
+	}
+	if seq > r.sent {
+		seq = r.sent
+	}
+	r.buffer = r.buffer[seq-r.acked:]
+	r.acked = seq
+
+	select {
+	case r.space <- true:
+	default:
+	}
+}
+
+// receive tells weather the message with the sequence number seq is the next
+// one expected; messages sent before are duplicates, and an error is returned
+// for messages which skip ahead.
+func (r *resumeState) receive(seq int64) (next bool, err error) {
+	r.access.Lock()
+	defer r.access.Unlock()
+
+	if seq <= r.received {
+		return false, nil
+	}
+	if seq != r.received+1 {
+		return false, errOutOfSequence
+	}
+	r.received = seq
+	if r.received-r.receivedAcked >= ackInterval {
+		select {
+		case r.ackWanted <- true:
+		default:
+		}
+	}
+	return true, nil
+}
+
+// ack returns an acknowledgement frame for the messages received since the
+// last one, or nil if there are none.
+func (r *resumeState) ack() []interface{} {
+	r.access.Lock()
+	defer r.access.Unlock()
+
+	if r.received == r.receivedAcked {
+		return nil
+	}
+	r.receivedAcked = r.received
+	return []interface{}{ftAck, r.received}
+}
+
+// replay returns the frames to send when an transport is attached: first the
+// resume information ["r", key, received], telling them which connection they
+// are and how far we got, and then every message they have not acknowledged.
+func (r *resumeState) replay(key interface{}) [][]interface{} {
+	r.access.Lock()
+	defer r.access.Unlock()
+
+	r.receivedAcked = r.received
+
+	frames := make([][]interface{}, 0, len(r.buffer)+1)
+	frames = append(frames, []interface{}{ftResume, key, r.received})
+	return append(frames, r.buffer...)
+}
+
+func newResumeState() *resumeState {
+	r := new(resumeState)
+	r.space = make(chan bool, 1)
+	r.ackWanted = make(chan bool, 1)
+	r.detached = make(chan bool)
+	return r
+}
+
+// resumeRequested tells weather the request asks for an resumable connection,
+// and if so, which one it resumes (key is empty for an new one) and the
+// sequence number of the last message the client received on it.
+func resumeRequested(req *http.Request) (key string, ack int64, ok bool) {
+	query := req.URL.Query()
+	values, ok := query[qpResume]
+	if !ok {
+		return "", 0, false
+	}
+	ack, _ = strconv.ParseInt(query.Get(qpAck), 10, 64)
+	return values[0], ack, true
+}
+
+// connectionFor returns the connection which the transport should carry, that
+// is either an detached connection which the request resumes, or else an new
+// one using the specified key.
+//
+// The connection is resumable if the request asks for it, and resuming is
+// enabled on the server (see SetResumeWindow).
+func (s *Server) connectionFor(req *http.Request, session *Session, key string, t Transport) (c *Connection, resumed bool) {
+	resumeKey, ack, resumable := resumeRequested(req)
+	if !resumable || s.ResumeWindow() <= 0 {
+		c = newConnection(req.RemoteAddr, session, key, t)
+		_, c.tokens = s.sessionToken(req)
+		s.limitQueue(c)
+		return c, false
+	}
+
+	if len(resumeKey) > 0 {
+		c = session.getConnection(resumeKey)
+		if c != nil && c.resume != nil && c.reattach(t, ack) {
+			logger().Println("Resumed", c)
+			return c, true
+		}
+		logger().Println("Unable to resume connection, creating an new one.")
+	}
+
+	c = newConnection(req.RemoteAddr, session, key, t)
+	_, c.tokens = s.sessionToken(req)
+	s.limitQueue(c)
+	c.resume = newResumeState()
+	return c, false
+}
+
+// reattach attaches the transport to this resumable connection, replacing any
+// transport it still has. It returns false if the connection is dead.
+func (c *Connection) reattach(t Transport, ack int64) bool {
+	r := c.resume
+
+	// The client may notice an lost transport before we do, so detach the
+	// current one first.
+	if old := c.currentTransport(); old != nil {
+		old.Close()
+
+		r.access.Lock()
+		detached := r.detached
+		r.access.Unlock()
+
+		select {
+		case <-detached:
+		case <-c.ctx.Done():
+			return false
+		}
+	}
+
+	c.access.Lock()
+	if c.dead || c.transport != nil {
+		// Died meanwhile, or an concurrent resume won.
+		c.access.Unlock()
+		return false
+	}
+	c.transport = t
+	c.method = t.Method()
+	c.access.Unlock()
+
+	r.access.Lock()
+	r.detached = make(chan bool)
+	resumed := r.resumed
+	r.resumed = nil
+	r.access.Unlock()
+	if resumed != nil {
+		close(resumed)
+	}
+
+	r.acknowledge(ack)
+	c.resetDisconnectTimer()
+	return true
+}
+
+// detach detaches the transport from this resumable connection, and kills the
+// connection unless it is resumed within the resume window.
+func (c *Connection) detach(t Transport, window time.Duration) {
+	r := c.resume
+
+	c.access.Lock()
+	if c.transport == t {
+		c.transport = nil
+	}
+	c.access.Unlock()
+
+	r.access.Lock()
+	resumed := make(chan bool)
+	r.resumed = resumed
+	close(r.detached)
+	r.access.Unlock()
+
+	logger().Println("Detached", c)
+	go func() {
+		select {
+		case <-resumed:
+		case <-c.ctx.Done():
+		case <-time.After(window):
+			logger().Println("Resume window expired", c)
+			c.kill(DisconnectClosed)
+		}
+	}()
+}
@@ -0,0 +1,120 @@
+// Copyright 2012 Lightpoke. All rights reserved.
+// This source code is subject to the terms and
+// conditions defined in the "License.txt" file.
+
+package organics_test
+
+import (
+	"encoding/json"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/websocket"
+
+	"github.com/sinni800/organics"
+)
+
+func TestResume(t *testing.T) {
+	s := newServer(t)
+	s.SetResumeWindow(500 * time.Millisecond)
+	s.Handle("Add", func(a, b float64, c *organics.Connection) float64 {
+		return a + b
+	})
+
+	// Every transport belongs to the same session.
+	cookie := s.establish(t).Header.Get("Cookie")
+	dial := func(query string) *websocket.Conn {
+		config, err := websocket.NewConfig(s.wsURL()+"/?"+query, s.http.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		config.Header.Set("Cookie", cookie)
+		ws, err := websocket.DialConfig(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { ws.Close() })
+		return ws
+	}
+	read := func(ws *websocket.Conn) []interface{} {
+		t.Helper()
+		ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+		var frame string
+		if err := websocket.Message.Receive(ws, &frame); err != nil {
+			t.Fatal(err)
+		}
+		var decoded []interface{}
+		if err := json.Unmarshal([]byte(frame), &decoded); err != nil {
+			t.Fatal(err)
+		}
+		return decoded
+	}
+	// expect reads an frame, and checks it's type and sequence number (or the
+	// connection key for ftResume frames).
+	expect := func(ws *websocket.Conn, frameType string, second interface{}) []interface{} {
+		t.Helper()
+		f := read(ws)
+		if len(f) < 2 || f[0] != frameType || f[1] != second {
+			t.Fatalf("got frame %v, want [%s %v ...]", f, frameType, second)
+		}
+		return f
+	}
+
+	ws := dial("organics-resume=")
+	key := read(ws)[1].(string)
+	c := receive(t, s.connected)
+
+	c.Request("Hello", "world")
+	expect(ws, "s", 1.0)
+	websocket.Message.Send(ws, `["s",1,[0,"Add",[1,2]]]`)
+	expect(ws, "s", 2.0)
+
+	// Lose the transport, the connection outlives it, and whatever is sent
+	// meanwhile is replayed once they resume.
+	ws.Close()
+	time.Sleep(50 * time.Millisecond)
+	c.Request("Missed", 1)
+	if c.Dead() {
+		t.Fatal("connection died with it's transport")
+	}
+
+	ws = dial("organics-resume=" + url.QueryEscape(key) + "&organics-ack=1")
+	if f := expect(ws, "r", key); f[2] != 1.0 {
+		t.Fatalf("resumed at %v, want 1", f[2])
+	}
+	expect(ws, "s", 2.0)
+	if f := expect(ws, "s", 3.0); !strings.Contains(marshal(f), "Missed") {
+		t.Fatalf("replayed %v, want the Missed request", f)
+	}
+
+	// Frames they send again are ignored, new ones are handled.
+	websocket.Message.Send(ws, `["s",1,[0,"Add",[1,2]]]`)
+	websocket.Message.Send(ws, `["s",2,[1,"Add",[2,2]]]`)
+	if f := expect(ws, "s", 4.0); !strings.Contains(marshal(f), "[1,[4]]") {
+		t.Fatalf("got %v, want the response to the second Add", f)
+	}
+	websocket.Message.Send(ws, `["a",4]`)
+
+	// Never the Connect handler again.
+	select {
+	case c := <-s.connected:
+		t.Fatal("Connect handler invoked for an resumed connection", c)
+	default:
+	}
+
+	// Not resumed within the window, the connection dies.
+	ws.Close()
+	eventually(t, "the connection is dead", c.Dead)
+
+	ws = dial("organics-resume=" + url.QueryEscape(key))
+	if f := read(ws); f[0] != "r" || f[1] == key {
+		t.Fatalf("resuming an dead connection got %v, want an new connection", f)
+	}
+}
+
+func marshal(v interface{}) string {
+	data, _ := json.Marshal(v)
+	return string(data)
+}
@@ -0,0 +1,258 @@
+// Copyright 2012 Lightpoke. All rights reserved.
+// This source code is subject to the terms and
+// conditions defined in the "License.txt" file.
+
+package organics
+
+import (
+	"context"
+	"sync"
+)
+
+// Room is an named group of connections, which requests can be broadcast to.
+//
+// Rooms are retrieved (and created) using the Server's Room() method, for
+// example an chat server might do:
+//
+//  func doConnect(connection *organics.Connection) {
+//      server.Room("lobby").Join(connection)
+//  }
+//
+//  func doMessage(msg string, connection *organics.Connection) {
+//      server.Room("lobby").Request("DisplayMessage", msg)
+//  }
+//
+// Connections leave the room automatically once they die.
+type Room struct {
+	access sync.RWMutex
+
+	name    string
+	server  *Server
+	closed  bool
+	members map[*Connection]func() bool // stops watching for the member's death
+
+	joinHandlers, leaveHandlers []func(*Connection)
+	closeHandlers               []func()
+}
+
+// Name returns the name of this room, as it was passed into the Server's
+// Room() method.
+func (r *Room) Name() string {
+	// Note: no locking needed, never written to past creation time.
+	return r.name
+}
+
+// Join adds the connection to this room, such that it will receive the
+// requests broadcast to this room, until it leaves the room or dies.
+//
+// If the connection is already an member of this room, is dead, or this room
+// is closed, this function is no-op.
+func (r *Room) Join(c *Connection) {
+	if c.Dead() {
+		return
+	}
+
+	r.access.Lock()
+	if _, ok := r.members[c]; ok || r.closed {
+		r.access.Unlock()
+		return
+	}
+	r.members[c] = context.AfterFunc(c.Context(), func() {
+		r.Leave(c)
+	})
+	handlers := r.joinHandlers
+	r.access.Unlock()
+
+	logger().Printf("%v joined room %q\n", c, r.name)
+
+	for _, fn := range handlers {
+		fn(c)
+	}
+}
+
+// Leave removes the connection from this room.
+//
+// If the connection is not an member of this room, this function is no-op.
+func (r *Room) Leave(c *Connection) {
+	r.access.Lock()
+	stop, ok := r.members[c]
+	if !ok {
+		r.access.Unlock()
+		return
+	}
+	delete(r.members, c)
+	stop()
+	handlers := r.leaveHandlers
+	r.access.Unlock()
+
+	logger().Printf("%v left room %q\n", c, r.name)
+
+	for _, fn := range handlers {
+		fn(c)
+	}
+}
+
+// Has tells weather the connection is an member of this room.
+func (r *Room) Has(c *Connection) bool {
+	r.access.RLock()
+	defer r.access.RUnlock()
+
+	_, ok := r.members[c]
+	return ok
+}
+
+// Members returns all connections which are currently members of this room.
+func (r *Room) Members() []*Connection {
+	r.access.RLock()
+	defer r.access.RUnlock()
+
+	members := make([]*Connection, 0, len(r.members))
+	for c := range r.members {
+		members = append(members, c)
+	}
+	return members
+}
+
+// Len returns the number of connections which are currently members of this
+// room.
+func (r *Room) Len() int {
+	r.access.RLock()
+	defer r.access.RUnlock()
+
+	return len(r.members)
+}
+
+// Request makes an request to each member of this room, see the Connection's
+// Request() method for the meaning of the parameters.
+//
+// Note that if an function is given to be called when the request has
+// completed, then it is called once for each member which completes it.
+func (r *Room) Request(requestName interface{}, sequence ...interface{}) {
+	for _, c := range r.Members() {
+		c.Request(requestName, sequence...)
+	}
+}
+
+// Except returns the members of this room, excluding the specified
+// connection, it is useful for broadcasting to everyone but the sender:
+//
+//  for _, c := range room.Except(connection) {
+//      c.Request("DisplayMessage", msg)
+//  }
+//
+func (r *Room) Except(c *Connection) []*Connection {
+	members := r.Members()
+	for i, member := range members {
+		if member == c {
+			return append(members[:i], members[i+1:]...)
+		}
+	}
+	return members
+}
+
+// OnJoin adds an function which is called each time an connection joins this
+// room, after it has joined.
+func (r *Room) OnJoin(fn func(c *Connection)) {
+	r.access.Lock()
+	defer r.access.Unlock()
+
+	r.joinHandlers = append(r.joinHandlers, fn)
+}
+
+// OnLeave adds an function which is called each time an connection leaves
+// this room (including when it dies, or when the room is closed), after it
+// has left.
+func (r *Room) OnLeave(fn func(c *Connection)) {
+	r.access.Lock()
+	defer r.access.Unlock()
+
+	r.leaveHandlers = append(r.leaveHandlers, fn)
+}
+
+// OnClose adds an function which is called once this room is closed, after
+// every member has left it.
+func (r *Room) OnClose(fn func()) {
+	r.access.Lock()
+	defer r.access.Unlock()
+
+	r.closeHandlers = append(r.closeHandlers, fn)
+}
+
+// Closed tells weather this room has been closed.
+func (r *Room) Closed() bool {
+	r.access.RLock()
+	defer r.access.RUnlock()
+
+	return r.closed
+}
+
+// Close removes every member from this room, and removes this room from the
+// server; an later call to the Server's Room() method using the same name
+// creates an new room.
+//
+// If this room is already closed, this function is no-op.
+func (r *Room) Close() {
+	r.access.Lock()
+	if r.closed {
+		r.access.Unlock()
+		return
+	}
+	r.closed = true
+	r.access.Unlock()
+
+	r.server.removeRoom(r)
+
+	for _, c := range r.Members() {
+		r.Leave(c)
+	}
+
+	r.access.RLock()
+	handlers := r.closeHandlers
+	r.access.RUnlock()
+
+	logger().Printf("Closed room %q\n", r.name)
+	for _, fn := range handlers {
+		fn()
+	}
+}
+
+// Room returns the room with the specified name, creating it if it does not
+// exist yet.
+//
+// Rooms exist until they are closed, see the Room's Close() method.
+func (s *Server) Room(name string) *Room {
+	s.access.Lock()
+	defer s.access.Unlock()
+
+	r, ok := s.rooms[name]
+	if !ok {
+		r = &Room{
+			name:    name,
+			server:  s,
+			members: make(map[*Connection]func() bool),
+		}
+		s.rooms[name] = r
+	}
+	return r
+}
+
+// Rooms returns all rooms this Server currently has.
+func (s *Server) Rooms() []*Room {
+	s.access.RLock()
+	defer s.access.RUnlock()
+
+	rooms := make([]*Room, 0, len(s.rooms))
+	for _, r := range s.rooms {
+		rooms = append(rooms, r)
+	}
+	return rooms
+}
+
+func (s *Server) removeRoom(r *Room) {
+	s.access.Lock()
+	defer s.access.Unlock()
+
+	if s.rooms[r.name] == r {
+		delete(s.rooms, r.name)
+	}
+}
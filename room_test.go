@@ -0,0 +1,68 @@
+// Copyright 2012 Lightpoke. All rights reserved.
+// This source code is subject to the terms and
+// conditions defined in the "License.txt" file.
+
+package organics_test
+
+import (
+	"testing"
+
+	"github.com/sinni800/organics"
+	"github.com/sinni800/organics/client"
+)
+
+func TestRoom(t *testing.T) {
+	s := newServer(t)
+	room := s.Room("lobby")
+	if s.Room("lobby") != room {
+		t.Fatal("Room() returned an different room for the same name")
+	}
+	joined := make(chan *organics.Connection, 4)
+	left := make(chan *organics.Connection, 4)
+	closed := make(chan bool, 1)
+	room.OnJoin(func(c *organics.Connection) { joined <- c })
+	room.OnLeave(func(c *organics.Connection) { left <- c })
+	room.OnClose(func() { closed <- true })
+
+	messages := make(chan string, 4)
+	var members []*organics.Connection
+	for _, url := range s.urls() {
+		c, sc := s.dial(t, url)
+		c.Handle("Message", func(m string, c *client.Connection) {
+			messages <- m
+		})
+		room.Join(sc)
+		if receive(t, joined) != sc || !room.Has(sc) {
+			t.Fatal("joined connection is not in the room")
+		}
+		members = append(members, sc)
+	}
+	if room.Len() != 2 || len(room.Except(members[0])) != 1 || len(s.Rooms()) != 1 {
+		t.Fatalf("room has %d members, server has %d rooms; want 2, 1", room.Len(), len(s.Rooms()))
+	}
+
+	room.Request("Message", "hello")
+	for range members {
+		if m := receive(t, messages); m != "hello" {
+			t.Fatalf("got message %q, want hello", m)
+		}
+	}
+
+	// Dead connections leave by themselves.
+	members[0].Kill()
+	if receive(t, left) != members[0] {
+		t.Fatal("wrong connection left the room")
+	}
+	if room.Has(members[0]) || room.Len() != 1 {
+		t.Fatal("dead connection is still in the room")
+	}
+
+	room.Close()
+	receive(t, closed)
+	if !room.Closed() || room.Len() != 0 || len(s.Rooms()) != 0 {
+		t.Fatal("room is not empty, or still known to the server after Close()")
+	}
+	if s.Room("lobby") == room {
+		t.Fatal("Room() returned the closed room")
+	}
+}
@@ -7,6 +7,7 @@ package organics
 
 import (
 	"bytes"
+	"context"
 	"golang.org/x/net/websocket"
 	"crypto/rand"
 	"crypto/sha256"
@@ -30,18 +31,57 @@ type Server struct {
 	sessionProvider SessionProvider
 
 	sessions                      map[interface{}]*Session
+	rekeyed, tickets              map[string]*rekey
 	origins                       map[string]bool
+	exposedKeys                   map[storeKey]bool
 	requestHandlers               map[interface{}]interface{}
+	middleware                    []Middleware
 	maxBufferSize, sessionKeySize int64
+	longPollBatchSize             int64
+	queueSize                     int
+	queuePolicy                   QueuePolicy
+	queueFullHandler              func(c *Connection, policy QueuePolicy)
+	dispatchMode                  DispatchMode
+	handlerTimeout                time.Duration
+	maxWorkers                    int
+	workers                       *workerPool
 	pingRate, pingTimeout         time.Duration
 	sessionTimeout                time.Duration
+	sessionIdleTimeout            time.Duration
+	sessionMaxAge, sweepInterval  time.Duration
+	sweepReset                    chan bool
+	sweeping                      bool
+	sessionCookie                 SessionCookie
+	sessionTokens                 bool
+	authenticator                 Authenticator
+	resumeWindow                  time.Duration
+	resumeBufferSize              int
+	codec                         Codec
 	connections                   []*Connection
+	rooms                         map[string]*Room
+	users                         map[interface{}]map[*Session]bool
+
+	// See shutdown.go
+	shuttingDown bool
+	requests     int
+	requestsDone *sync.Cond
+
+	// Cancelled once the server has shut down, see Shutdown.
+	ctx       context.Context
+	ctxCancel context.CancelFunc
 }
 
+var emptyInterfaceType = reflect.TypeOf((*interface{})(nil)).Elem()
+
 // Utility function to convert []interface{} into []reflect.Value
 func interfaceToValueSlice(s []interface{}) []reflect.Value {
 	valueArgs := make([]reflect.Value, len(s))
 	for i, value := range s {
+		if value == nil {
+			// JSON null; reflect.ValueOf(nil) would be an invalid value.
+			valueArgs[i] = reflect.Zero(emptyInterfaceType)
+			continue
+		}
 		valueArgs[i] = reflect.ValueOf(value)
 	}
 	return valueArgs
@@ -78,7 +118,7 @@ func (s *Server) doConnectHandler(connection *Connection) {
 	s.connections = append(s.connections, connection)
 	s.access.Unlock()
 	go func() {
-		<-connection.DeathNotify()
+		<-connection.Context().Done()
 		s.access.Lock()
 		for i, c := range s.connections {
 			if c == connection {
@@ -86,6 +126,9 @@ func (s *Server) doConnectHandler(connection *Connection) {
 			}
 		}
 		s.access.Unlock()
+
+		// Defined in lifecycle.go
+		s.doDisconnectHandler(connection)
 	}()
 
 	handler := s.getHandler(Connect)
@@ -114,7 +157,11 @@ func (s *Server) doConnectHandler(connection *Connection) {
 			panic(string(buf.Bytes()))
 		}
 	}()
-	fn.Call([]reflect.Value{reflect.ValueOf(connection)})
+	args := []reflect.Value{reflect.ValueOf(connection)}
+	if acceptsContext(fn.Type()) {
+		args = append([]reflect.Value{reflect.ValueOf(connection.Context())}, args...)
+	}
+	fn.Call(args)
 }
 
 func (s *Server) cachedSession(key string) (session *Session, ok bool) {
@@ -149,29 +196,35 @@ func (s *Server) getSession(req *http.Request) *Session {
 	// We'll store the session in here if we find the cookie properly
 	var session *Session
 
-	// Did they send us their existing session key?
-	cookie, err := req.Cookie("organics-session")
-	if err != http.ErrNoCookie {
+	// Did they send us their existing session key? (in the session cookie, or
+	// in token mode)
+	sessionKey, ok := s.requestSessionKey(req)
+	if ok {
 		// The client thinks they have an existing session. It could be in one
 		// of two places then. In the memory map session cache (I.e. existing
 		// session pointer), or the session provider might be aware of it's
 		// data (in which case we need to make a new session object using the
 		// data).
-		var ok bool
-		session, ok = s.cachedSession(cookie.Value)
+		session, ok = s.cachedSession(sessionKey)
 		if !ok {
 			// It's not in the cache of already-created session objects. See if
 			// the session provider is aware of it's data, then.
-			sessionStore := sp.Load(cookie.Value)
+			sessionStore := sp.Load(sessionKey)
 			if sessionStore != nil {
 				// The session provider has the session data; now create an new
 				// session object for it.
-				session = newSession(cookie.Value, s)
+				session = newSession(sessionKey, s)
 				session.Store = sessionStore
 				session.start()
 
 				// Cache this new object for later.
-				s.cacheSession(cookie.Value, session)
+				s.cacheSession(sessionKey, session)
+
+				// It is in use again, see expiry.go
+				s.expireSession(session, false)
+
+				// Defined in lifecycle.go
+				s.doSessionHandler(SessionRestored, session)
 			}
 		}
 	}
@@ -203,6 +256,11 @@ func (s *Server) ensureSessionExists(req *http.Request, setCookie func(*http.Coo
 	// their session object, assuming it is there, we can add another
 	// connection object to the session based off the fact that the request
 	// type is rtEstablishConnection.
+	//
+	// Clients which cannot store cookies use token mode instead (see
+	// SetSessionTokens), in which case they store the session key themselves,
+	// and send it back through the X-Organics-Session header (or the WebSocket
+	// subprotocol) instead of the cookie.
 
 	// Get the session provider, panic if there is none yet.
 	sp := s.Provider()
@@ -212,6 +270,13 @@ func (s *Server) ensureSessionExists(req *http.Request, setCookie func(*http.Coo
 
 	session := s.getSession(req)
 
+	// An authenticated client never joins an session which belongs to someone
+	// else, they are given an new one instead.
+	identity, authenticated := requestIdentity(req)
+	if authenticated && session != nil && !session.authenticate(identity) {
+		session = nil
+	}
+
 	// If we need to give them an new session, let's do that.
 	if session == nil || session.Dead() {
 		// Generate an random session key
@@ -223,21 +288,29 @@ func (s *Server) ensureSessionExists(req *http.Request, setCookie func(*http.Coo
 			return nil, false
 		}
 
-		// Create the cookie
-		cookie := new(http.Cookie)
-		cookie.HttpOnly = true
-		cookie.Name = "organics-session"
-		cookie.Value = sessionKey
-
 		// Create an session
 		session = newSession(sessionKey, s)
+		session.identity = identity
 		session.start()
 
 		// Cache it now
 		s.cacheSession(sessionKey, session)
 
-		// Give it to their browser
-		setCookie(cookie)
+		// Defined in expiry.go
+		s.expireSession(session, true)
+
+		if _, tokenMode := s.sessionToken(req); tokenMode {
+			// The establishing request gives it to them (see lpec and
+			// WebSocket), from now on the request carries it, just like it
+			// carries the cookie below.
+			req.Header.Set(hdrSession, sessionKey)
+		} else {
+			// Give it to their browser
+			setCookie(s.SessionCookie().cookie(sessionKey))
+		}
+
+		// Defined in lifecycle.go
+		s.doSessionHandler(SessionCreated, session)
 	}
 	return session, true
 }
@@ -267,6 +340,18 @@ func (s *Server) handlePreflightRequest(w http.ResponseWriter, req *http.Request
 	w.WriteHeader(http.StatusOK)
 }
 
+// refuseWhileShuttingDown responds with an 503 Service Unavailable status, and
+// returns true, if the server is shutting down. It is used for requests which
+// would establish an new connection.
+func (s *Server) refuseWhileShuttingDown(w http.ResponseWriter, req *http.Request) bool {
+	if !s.ShuttingDown() {
+		return false
+	}
+	w.WriteHeader(http.StatusServiceUnavailable)
+	req.Close = true
+	return true
+}
+
 // ServeHTTP fulfills the Handler interface define in the http package.
 func (s *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	// Respond to browser pre-flight requests, following the origin rules we
@@ -283,14 +368,38 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 			// Safari sends "WebSocket" instead of "websocket" (all other
 			// browsers), so make sure to check against the lower case word.
 			if strings.ToLower(v) == "websocket" {
+				if s.refuseWhileShuttingDown(w, req) {
+					return
+				}
+
+				// The handshake (see handleWebSocketHandshake) cannot choose
+				// the status it is rejected with, so they are authenticated
+				// before it.
+				//
+				// Defined in auth.go
+				req, ok := s.authenticate(w, req)
+				if !ok {
+					return
+				}
 				s.webSocketServer.ServeHTTP(w, req)
 				return
 			}
 		}
 	}
 
-	// At this point we know they have no support for WebSocket, which means we
-	// should fall back to long-polling.
+	// Open an event stream if they asked for one.
+	//
+	// Defined in sse.go
+	if req.Method == "GET" && sseAccepted(req) {
+		if s.refuseWhileShuttingDown(w, req) {
+			return
+		}
+		s.sseHandleRequest(w, req)
+		return
+	}
+
+	// At this point we know they have no support for WebSocket or server-sent
+	// events, which means we should fall back to long-polling.
 	//
 	// Defined in longpoll.go
 	s.lpHandleRequest(w, req)
@@ -312,13 +421,41 @@ func (s *Server) Connections() []*Connection {
 // that the requestHandler function will be invoked in order to handle the
 // request.
 //
-// The requestName parameter may be of any valid json.Marshal() type.
+// The requestName parameter may be of any valid json.Marshal() type which is
+// comparable (i.e. not an slice or map), Handle panics otherwise.
 //
 // The requestHandler parameter must be an function, with the type specified
 // below, where T is any valid json.Marshal() type.
 //
-// func(T, T, ..., *Session) (T, T, ...)
+// func(T, T, ..., *Connection) (T, T, ...)
+//
+// The request handler may also take an leading context.Context parameter:
+//
+// func(ctx context.Context, T, T, ..., *Connection) (T, T, ...)
+//
+// In which case it is given the request's context, which is cancelled once the
+// connection (or it's session) dies, once the other end cancels the request,
+// once the server has shut down (see Shutdown), or once the handler timeout
+// elapses (see SetHandlerTimeout), and which carries any values set by
+// middleware (see Call). Such handlers may send partial results before
+// returning, see Progress. The Connect handler is given the connection's
+// context instead (see Connection.Context).
+//
+// The special messages below are handled by handlers of the specified type
+// instead, they are invoked on the server's own accord:
+//
+//  Disconnect      func(reason DisconnectReason, c *Connection)
+//  SessionCreated  func(s *Session)
+//  SessionDied     func(s *Session)
+//  SessionRestored func(s *Session)
+//
+// The Disconnect handler is invoked once the connection is dead, with the
+// reason it died for. The SessionRestored handler is invoked when an session
+// which is not in memory is created using the data the session provider has
+// for it (see SessionProvider.Load), rather than the SessionCreated handler.
 func (s *Server) Handle(requestName, requestHandler interface{}) {
+	checkRequestName(requestName)
+
 	s.access.Lock()
 	defer s.access.Unlock()
 
@@ -328,10 +465,13 @@ func (s *Server) Handle(requestName, requestHandler interface{}) {
 	}
 
 	fnType := fn.Type()
-	connectionParam := fnType.In(fnType.NumIn() - 1)
-	var connectionType *Connection
-	if connectionParam != reflect.TypeOf(connectionType) {
-		panic("requestHandler parameter type incorrect! Last parameter must be *organics.Connection")
+	checkHookType(requestName, fnType)
+	if !sessionHook(requestName) {
+		connectionParam := fnType.In(fnType.NumIn() - 1)
+		var connectionType *Connection
+		if connectionParam != reflect.TypeOf(connectionType) {
+			panic("requestHandler parameter type incorrect! Last parameter must be *organics.Connection")
+		}
 	}
 
 	if requestHandler == nil {
@@ -354,7 +494,7 @@ func (s *Server) Provider() SessionProvider {
 // an single request may be.
 //
 // If an single JSON request exceeds this size, then the message will be
-// refused, and the session killed.
+// refused, and the connection killed (for long-polling, it's session as well).
 //
 // Default (1MB): 1 * 1024 * 1024
 func (s *Server) SetMaxBufferSize(size int64) {
@@ -374,6 +514,208 @@ func (s *Server) MaxBufferSize() int64 {
 	return s.maxBufferSize
 }
 
+// SetQueueSize sets the maximum number of messages (requests, and responses to
+// requests) which may be queued for sending to the other end of an single
+// connection, zero means there is no limit.
+//
+// An stalled or slow client which does not keep up causes messages to queue
+// up, once there are this many the queue policy decides what happens (see
+// SetQueuePolicy). Without an limit they queue up in memory for as long as the
+// connection lives.
+//
+// The size is that of new connections, existing connections are not affected.
+//
+// Default (no limit): 0
+func (s *Server) SetQueueSize(size int) {
+	s.access.Lock()
+	defer s.access.Unlock()
+
+	s.queueSize = size
+}
+
+// QueueSize returns the outgoing queue size of this Server.
+//
+// See SetQueueSize() for more information.
+func (s *Server) QueueSize() int {
+	s.access.RLock()
+	defer s.access.RUnlock()
+
+	return s.queueSize
+}
+
+// SetQueuePolicy sets what happens when an message is sent to an connection
+// whose outgoing queue is full (see SetQueueSize), one of:
+//
+//  QueueKill       - the connection is killed.
+//  QueueDropOldest - the oldest queued message is dropped.
+//  QueueDropNewest - the new message is dropped.
+//  QueueBlock      - the sender blocks until there is space.
+//
+// The request completion function of an dropped request is given an *Error
+// with the CodeQueueFull code, as long as it can hold one (see
+// Connection.Request), and RequestContext returns it. Responses to requests
+// from the other end are dropped silently, so the other end never sees them:
+// it's request completion function is never called, and stays around for as
+// long as the connection lives (it leaks), unless it cancels the request.
+//
+// QueueBlock means that an single slow client stalls whoever sends to it, be
+// it an request handler, or an loop which sends to every member of an Room or
+// every connection of an User, so it is never the default.
+//
+// The policy only applies once an queue size is set (see SetQueueSize), and is
+// that of new connections, existing connections are not affected.
+//
+// Default (QueueKill): QueueKill
+func (s *Server) SetQueuePolicy(policy QueuePolicy) {
+	s.access.Lock()
+	defer s.access.Unlock()
+
+	s.queuePolicy = policy
+}
+
+// QueuePolicy returns the outgoing queue policy of this Server.
+//
+// See SetQueuePolicy() for more information.
+func (s *Server) QueuePolicy() QueuePolicy {
+	s.access.RLock()
+	defer s.access.RUnlock()
+
+	return s.queuePolicy
+}
+
+// SetQueueFullHandler sets an function which is called each time the queue
+// policy of an connection's outgoing queue is triggered, it is given the
+// connection and the policy, for instance to count slow clients:
+//
+//  server.SetQueueFullHandler(func(c *organics.Connection, policy organics.QueuePolicy) {
+//      atomic.AddInt64(&slowClients, 1)
+//  })
+//
+// It is called by the goroutine which sent the message (for QueueBlock, once
+// per message, before it blocks), so it should not block itself. If fn is nil
+// then no function is called.
+func (s *Server) SetQueueFullHandler(fn func(c *Connection, policy QueuePolicy)) {
+	s.access.Lock()
+	defer s.access.Unlock()
+
+	s.queueFullHandler = fn
+}
+
+// QueueFullHandler returns the function set by SetQueueFullHandler(), or nil.
+func (s *Server) QueueFullHandler() func(c *Connection, policy QueuePolicy) {
+	s.access.RLock()
+	defer s.access.RUnlock()
+
+	return s.queueFullHandler
+}
+
+// SetDispatchMode sets how the requests which come in over an single
+// connection are handed to their request handlers, one of:
+//
+//  DispatchSequential - one at an time, in the order they came in.
+//  DispatchConcurrent - concurrently, using the worker pool.
+//  DispatchKeyed      - concurrently using the worker pool, except that those
+//                       with the same request name are handled one at an
+//                       time, in the order they came in.
+//
+// In either mode an request handler may wait for an response from the other
+// end (see Connection.RequestContext) without blocking the connection.
+//
+// Default (DispatchSequential): DispatchSequential
+func (s *Server) SetDispatchMode(mode DispatchMode) {
+	s.access.Lock()
+	defer s.access.Unlock()
+
+	s.dispatchMode = mode
+}
+
+// DispatchMode returns the dispatch mode of this Server.
+//
+// See SetDispatchMode() for more information.
+func (s *Server) DispatchMode() DispatchMode {
+	s.access.RLock()
+	defer s.access.RUnlock()
+
+	return s.dispatchMode
+}
+
+// SetHandlerTimeout sets the deadline of each request handler, relative to
+// when it is invoked, the request's context (see Handle) is cancelled once it
+// elapses. Zero means there is no deadline.
+//
+// The request handler is not stopped, it should return once it's context is
+// cancelled.
+//
+// Default (none): 0
+func (s *Server) SetHandlerTimeout(timeout time.Duration) {
+	s.access.Lock()
+	defer s.access.Unlock()
+
+	s.handlerTimeout = timeout
+}
+
+// HandlerTimeout returns the request handler timeout of this Server.
+//
+// See SetHandlerTimeout() for more information.
+func (s *Server) HandlerTimeout() time.Duration {
+	s.access.RLock()
+	defer s.access.RUnlock()
+
+	return s.handlerTimeout
+}
+
+// SetWorkers sets the size of the worker pool, that is the maximum number of
+// request handlers which are run at once (across every connection) by the
+// DispatchConcurrent and DispatchKeyed dispatch modes, zero means there is no
+// limit.
+//
+// Once every worker is busy, no more requests are taken from an connection
+// until one is free.
+//
+// Default (256): 256
+func (s *Server) SetWorkers(n int) {
+	s.access.Lock()
+	defer s.access.Unlock()
+
+	s.maxWorkers = n
+}
+
+// Workers returns the worker pool size of this Server.
+//
+// See SetWorkers() for more information.
+func (s *Server) Workers() int {
+	s.access.RLock()
+	defer s.access.RUnlock()
+
+	return s.maxWorkers
+}
+
+// SetLongPollBatchSize sets the maximum size in bytes of the frames which are
+// sent at once, in an single long-polling response (or written at once to an
+// event stream).
+//
+// Every frame which is queued for an long-polling connection is sent in the
+// next response, as an JSON array of frames, up to this size. Sending more
+// frames blocks until they poll again, once this many bytes are queued.
+//
+// Default (64KB): 64 * 1024
+func (s *Server) SetLongPollBatchSize(size int64) {
+	s.access.Lock()
+	defer s.access.Unlock()
+
+	s.longPollBatchSize = size
+}
+
+// LongPollBatchSize returns the long-polling batch size of this Server.
+//
+// See SetLongPollBatchSize() for more information.
+func (s *Server) LongPollBatchSize() int64 {
+	s.access.RLock()
+	defer s.access.RUnlock()
+
+	return s.longPollBatchSize
+}
+
 // SetSessionKeySize specifies the number of cryptographically random bytes
 // which will be used before sha256 hash and base64 encoding, as the per-user
 // random session key identifier.
@@ -481,6 +823,281 @@ func (s *Server) SessionTimeout() time.Duration {
 	return s.sessionTimeout
 }
 
+// SetSessionIdleTimeout specifies the duration after which an session's data
+// expires, once it is no longer used: once the session has no connections,
+// and none are made using it's key.
+//
+// Expired data is deleted by the session provider, and an expired session is
+// not restored, it's client is given an new session instead. This requires an
+// session provider which implements the SessionExpirer interface (which each
+// of the providers which come with Organics do), without it session data never
+// expires.
+//
+// An duration of zero means session data never expires when idle.
+//
+// Default: 0
+func (s *Server) SetSessionIdleTimeout(t time.Duration) {
+	s.access.Lock()
+	defer s.access.Unlock()
+
+	s.sessionIdleTimeout = t
+	s.startSweeping()
+}
+
+// SessionIdleTimeout returns the session idle timeout of this server.
+//
+// See SetSessionIdleTimeout() for more information about this value.
+func (s *Server) SessionIdleTimeout() time.Duration {
+	s.access.RLock()
+	defer s.access.RUnlock()
+
+	return s.sessionIdleTimeout
+}
+
+// SetSessionMaxAge specifies the duration after which an session's data
+// expires, once the session was created, no matter weather it is used or not.
+// An live session whose data expires is killed, and it's connections die with
+// the DisconnectExpired reason.
+//
+// It applies to sessions which are created from now on, an regenerated
+// session (see Session.Regenerate) keeps the age it had. See
+// SetSessionIdleTimeout() for what becomes of expired sessions.
+//
+// An duration of zero means session data never expires due to it's age.
+//
+// Default: 0
+func (s *Server) SetSessionMaxAge(t time.Duration) {
+	s.access.Lock()
+	defer s.access.Unlock()
+
+	s.sessionMaxAge = t
+	s.startSweeping()
+}
+
+// SessionMaxAge returns the session max age of this server.
+//
+// See SetSessionMaxAge() for more information about this value.
+func (s *Server) SessionMaxAge() time.Duration {
+	s.access.RLock()
+	defer s.access.RUnlock()
+
+	return s.sessionMaxAge
+}
+
+// SetSweepInterval specifies how often the session provider is asked to delete
+// the data of expired sessions, see SetSessionIdleTimeout().
+//
+// It is also how often the idle time of sessions which are in use is moved
+// forward, and as such the precision of the session idle timeout.
+//
+// Nothing is swept unless an session idle timeout or max age is set, and the
+// session provider implements SessionExpirer.
+//
+// Default (1 minute): time.Minute
+func (s *Server) SetSweepInterval(t time.Duration) {
+	s.access.Lock()
+	defer s.access.Unlock()
+
+	s.sweepInterval = t
+
+	// Wake the sweeper up, it waits for the previous interval otherwise.
+	select {
+	case s.sweepReset <- true:
+	default:
+	}
+}
+
+// SweepInterval returns the sweep interval of this server.
+//
+// See SetSweepInterval() for more information about this value.
+func (s *Server) SweepInterval() time.Duration {
+	s.access.RLock()
+	defer s.access.RUnlock()
+
+	return s.sweepInterval
+}
+
+// SetSessionCookie specifies the name and attributes of the cookie which
+// carries the session key, it applies to cookies which are given out from now
+// on. For example, an server which is only served over HTTPS would use:
+//
+//  s.SetSessionCookie(organics.SessionCookie{
+//      Name:     "organics-session",
+//      Path:     "/",
+//      Secure:   true,
+//      HttpOnly: true,
+//      SameSite: http.SameSiteStrictMode,
+//  })
+//
+// Default: SessionCookie{Name: "organics-session", HttpOnly: true}
+func (s *Server) SetSessionCookie(cookie SessionCookie) {
+	s.access.Lock()
+	defer s.access.Unlock()
+
+	s.sessionCookie = cookie
+}
+
+// SessionCookie returns the name and attributes of the cookie which carries the
+// session key.
+//
+// See SetSessionCookie() for more information about this value.
+func (s *Server) SessionCookie() SessionCookie {
+	s.access.RLock()
+	defer s.access.RUnlock()
+
+	return s.sessionCookie
+}
+
+// SetSessionTokens specifies weather clients may use token mode instead of
+// the session cookie, for clients which cannot store cookies (e.g. embedded
+// web views, or third-party iframes).
+//
+// In token mode the client stores the session key itself, and sends it
+// through the X-Organics-Session header (or, for WebSocket, an subprotocol)
+// instead of the cookie. The server gives it to them in the X-Organics-Session
+// header of the long-polling establish response, or as the first frame sent
+// over an WebSocket, which looks like: ["t", key]
+//
+// Unlike the cookie (see SessionCookie.HttpOnly) the session key is readable
+// by the page's scripts in token mode, which is why it must be enabled here.
+// Server-sent events cannot carry the session key in token mode, the client
+// falls back to long-polling instead.
+//
+// Default: false
+func (s *Server) SetSessionTokens(enabled bool) {
+	s.access.Lock()
+	defer s.access.Unlock()
+
+	s.sessionTokens = enabled
+}
+
+// SessionTokens tells weather clients may use token mode instead of the session
+// cookie.
+//
+// See SetSessionTokens() for more information about this value.
+func (s *Server) SessionTokens() bool {
+	s.access.RLock()
+	defer s.access.RUnlock()
+
+	return s.sessionTokens
+}
+
+// SetAuthenticator specifies the authenticator which is invoked for each
+// request which establishes an connection (the long-polling establish request,
+// the WebSocket handshake, or the event stream request), before any session or
+// connection exists for it.
+//
+// If the authenticator returns an error the request is rejected (see
+// Authenticator), otherwise the identity it returns is given to the client's
+// session, and read using Session.Identity() or Connection.Identity(). An
+// client whose session belongs to an different identity (e.g. after they
+// signed in as someone else) is given an new session.
+//
+// BearerToken and SignedCookie are ready-made authenticators.
+//
+// An nil authenticator lets anyone connect.
+//
+// Default: nil
+func (s *Server) SetAuthenticator(authenticator Authenticator) {
+	s.access.Lock()
+	defer s.access.Unlock()
+
+	s.authenticator = authenticator
+}
+
+// Authenticator returns the authenticator which is invoked for each request
+// which establishes an connection.
+//
+// See SetAuthenticator() for more information about this value.
+func (s *Server) Authenticator() Authenticator {
+	s.access.RLock()
+	defer s.access.RUnlock()
+
+	return s.authenticator
+}
+
+// SetResumeWindow specifies the duration for which an connection is kept
+// around after it's transport (e.g. it's WebSocket) was lost, waiting for the
+// client to reconnect and resume it.
+//
+// An resumed connection is the same *Connection as before (the Connect
+// handler is not invoked again), and any messages which the other end did not
+// receive are sent again, in order. If the client does not resume it in time,
+// the connection is killed.
+//
+// Only clients which ask to resume their connections (see the Resume
+// parameter of Organics.Connection in organics.js) are affected.
+//
+// Default (zero, resuming is disabled): 0
+func (s *Server) SetResumeWindow(t time.Duration) {
+	s.access.Lock()
+	defer s.access.Unlock()
+
+	s.resumeWindow = t
+}
+
+// ResumeWindow returns the resume window of this server.
+//
+// See SetResumeWindow() for more information about this value.
+func (s *Server) ResumeWindow() time.Duration {
+	s.access.RLock()
+	defer s.access.RUnlock()
+
+	return s.resumeWindow
+}
+
+// SetResumeBufferSize specifies the maximum number of messages which an
+// resumable connection keeps around until the other end acknowledges that it
+// has received them (so that they may be sent again after an resume).
+//
+// Once the buffer is full no more messages are sent to the other end until it
+// acknowledges some of them.
+//
+// Default (256 messages): 256
+func (s *Server) SetResumeBufferSize(size int) {
+	s.access.Lock()
+	defer s.access.Unlock()
+
+	s.resumeBufferSize = size
+}
+
+// ResumeBufferSize returns the resume buffer size of this server.
+//
+// See SetResumeBufferSize() for more information about this value.
+func (s *Server) ResumeBufferSize() int {
+	s.access.RLock()
+	defer s.access.RUnlock()
+
+	return s.resumeBufferSize
+}
+
+// SetCodec specifies the codec which frames are encoded using, for instance
+// the compact binary MessagePack codec:
+//
+//  server.SetCodec(organics.MessagePack)
+//
+// The codec is negotiated with each client as it connects, no matter the
+// connection method, and is only used if the client supports it (organics.js
+// supports MessagePack), otherwise JSON is used.
+//
+// Default (JSON): JSON
+func (s *Server) SetCodec(codec Codec) {
+	s.access.Lock()
+	defer s.access.Unlock()
+
+	s.codec = codec
+}
+
+// Codec returns the codec of this server.
+//
+// See SetCodec() for more information about this value.
+func (s *Server) Codec() Codec {
+	s.access.RLock()
+	defer s.access.RUnlock()
+
+	return s.codec
+}
+
 // SetOriginAccess specifies an origin string to allow access to or deny access
 // to.
 //
@@ -538,6 +1155,43 @@ func (s *Server) Origins() map[string]bool {
 	return originsCopy
 }
 
+// SetStoreAccess specifies an store key which the other end may watch, or may
+// no longer watch, the scope tells weather it is an key of the session's store
+// or of the connection's store.
+//
+// The other end receives the value of an key which it watches right away, and
+// again each time it changes, for example in JavaScript:
+//
+//  connection.Watch(Organics.SessionStore, "cart", function(value, exists) {
+//      ...
+//  });
+//
+// No key is exposed unless it is set to be here, as stores often hold data
+// which the other end must not see.
+//
+// Values are sent just like request arguments are, so they must be accepted by
+// the codec in use (e.g. json.Marshal()).
+func (s *Server) SetStoreAccess(scope StoreScope, key string, exposed bool) {
+	s.access.Lock()
+	defer s.access.Unlock()
+
+	if !exposed {
+		delete(s.exposedKeys, storeKey{scope, key})
+	} else {
+		s.exposedKeys[storeKey{scope, key}] = true
+	}
+}
+
+// StoreAccess tells weather the store key is currently exposed to the other
+// end, based on an previous call to SetStoreAccess(), if there was no previous
+// call for this key, false is returned.
+func (s *Server) StoreAccess(scope StoreScope, key string) bool {
+	s.access.RLock()
+	defer s.access.RUnlock()
+
+	return s.exposedKeys[storeKey{scope, key}]
+}
+
 // Kill kills each connection currently known to the server. It is short-hand
 // for the following code:
 //
@@ -555,10 +1209,17 @@ func (s *Server) Kill() {
 func NewServer(sessionProvider SessionProvider) *Server {
 	s := new(Server)
 	s.sessions = make(map[interface{}]*Session)
+	s.rekeyed = make(map[string]*rekey)
+	s.tickets = make(map[string]*rekey)
 	s.origins = make(map[string]bool)
+	s.exposedKeys = make(map[storeKey]bool)
 	s.requestHandlers = make(map[interface{}]interface{})
+	s.rooms = make(map[string]*Room)
+	s.users = make(map[interface{}]map[*Session]bool)
 	s.sessionProvider = sessionProvider
 	s.webSocketServer = s.makeWebSocketServer()
+	s.ctx, s.ctxCancel = context.WithCancel(context.Background())
+	s.requestsDone = sync.NewCond(&s.access)
 
 	// Max message size: 1MB
 	s.maxBufferSize = 1 * 1024 * 1024
@@ -566,6 +1227,19 @@ func NewServer(sessionProvider SessionProvider) *Server {
 	// Size in bytes
 	s.sessionKeySize = 64
 
+	// Long-polling responses of up to 64KB
+	s.longPollBatchSize = 64 * 1024
+
+	// Messages queued per connection without limit, unless one is set.
+	s.queueSize = 0
+	s.queuePolicy = QueueKill
+
+	// Requests are handled one at an time per connection, up to 256 at once
+	// for the other dispatch modes.
+	s.dispatchMode = DispatchSequential
+	s.maxWorkers = 256
+	s.workers = newWorkerPool()
+
 	// Ping response every 5 minutes
 	s.pingRate = 5 * time.Minute
 
@@ -574,5 +1248,20 @@ func NewServer(sessionProvider SessionProvider) *Server {
 
 	// Stop saving session data 30 seconds after it's death.
 	s.sessionTimeout = 30 * time.Second
+
+	// Session data never expires, once it does expired data is swept every
+	// minute (see startSweeping).
+	s.sweepInterval = time.Minute
+	s.sweepReset = make(chan bool, 1)
+
+	// An cookie which scripts cannot read, which lasts until the browser is
+	// closed.
+	s.sessionCookie = SessionCookie{Name: "organics-session", HttpOnly: true}
+
+	// Keep up to 256 unacknowledged messages for resumable connections.
+	s.resumeBufferSize = 256
+
+	// Frames are JSON encoded, unless another codec is negotiated.
+	s.codec = JSON
 	return s
 }
@@ -0,0 +1,117 @@
+// Copyright 2012 Lightpoke. All rights reserved.
+// This source code is subject to the terms and
+// conditions defined in the "License.txt" file.
+
+package organics_test
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/websocket"
+
+	"github.com/sinni800/organics"
+	"github.com/sinni800/organics/client"
+	"github.com/sinni800/organics/provider/memory"
+)
+
+// testServer is an Organics server running for the duration of an test.
+type testServer struct {
+	*organics.Server
+	http *httptest.Server
+
+	// Each connection as it connects.
+	connected chan *organics.Connection
+}
+
+// newServer starts an Organics server for the test, using the memory session
+// provider.
+func newServer(t *testing.T) *testServer {
+	return newProviderServer(t, memory.Provider())
+}
+
+// newProviderServer starts an Organics server for the test, using the session
+// provider.
+func newProviderServer(t *testing.T, provider organics.SessionProvider) *testServer {
+	ts := &testServer{
+		Server:    organics.NewServer(provider),
+		connected: make(chan *organics.Connection, 16),
+	}
+	ts.SetOriginAccess("*", true)
+	ts.Handle(organics.Connect, func(c *organics.Connection) {
+		ts.connected <- c
+	})
+	ts.http = httptest.NewServer(ts.Server)
+	t.Cleanup(ts.http.Close)
+	return ts
+}
+
+// urls returns the URLs which connect to the server using each connection
+// method the Go client supports, by the method's name.
+func (ts *testServer) urls() map[string]string {
+	return map[string]string{
+		"WebSocket":   ts.wsURL(),
+		"LongPolling": ts.http.URL,
+	}
+}
+
+func (ts *testServer) wsURL() string {
+	return "ws" + strings.TrimPrefix(ts.http.URL, "http")
+}
+
+// dial connects to the server using the URL, and returns both ends of the
+// connection.
+func (ts *testServer) dial(t *testing.T, url string) (*client.Connection, *organics.Connection) {
+	t.Helper()
+	c, err := client.Dial(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(c.Kill)
+	return c, receive(t, ts.connected)
+}
+
+// rawWebSocket connects to the server using an plain WebSocket, which sends
+// and receives raw frames, offering the specified subprotocols. It returns both
+// ends of the connection.
+func (ts *testServer) rawWebSocket(t *testing.T, protocols ...string) (*websocket.Conn, *organics.Connection) {
+	t.Helper()
+	config, err := websocket.NewConfig(ts.wsURL(), ts.http.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Protocol = protocols
+	ws, err := websocket.DialConfig(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ws.Close() })
+	return ws, receive(t, ts.connected)
+}
+
+// receive returns the next value sent on the channel, or fails the test if
+// there is none within a few seconds.
+func receive[T any](t *testing.T, ch <-chan T) T {
+	t.Helper()
+	select {
+	case v := <-ch:
+		return v
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out")
+	}
+	panic("unreachable")
+}
+
+// eventually fails the test unless cond becomes true within a few seconds.
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting until", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
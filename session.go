@@ -22,12 +22,25 @@ type Session struct {
 
 	key                               string
 	server                            *Server
-	dead                              bool
+	dead, dying                       bool
 	connections                       map[interface{}]*Connection
-	deathNotify, deathCompletedNotify chan bool
+	deathNotify, died                 chan bool
 	deathNotifications                []chan bool
 	hasDataChangedRoutine             bool
 	stopSaving                        chan bool
+
+	// Who this session belongs to, set by the server's authenticator.
+	identity Identity
+
+	// The id of the user this session belongs to (see user.go).
+	user interface{}
+
+	// Weather this session was killed because it's data expired (see
+	// expiry.go), in which case it is not saved anymore.
+	expired bool
+
+	// The idle time last given to the session provider, see expiry.go
+	idleTime time.Time
 }
 
 // String returns an string representation of this Session.
@@ -48,6 +61,15 @@ func (s *Session) Dead() bool {
 	return s.dead
 }
 
+// Identity returns the identity which the server's authenticator gave this
+// session's client (see Server.SetAuthenticator), or nil if there is none.
+func (s *Session) Identity() Identity {
+	s.access.RLock()
+	defer s.access.RUnlock()
+
+	return s.identity
+}
+
 // DeathNotify returns an channel on which true will be sent once this session
 // is killed via it's Kill() method.
 //
@@ -76,13 +98,14 @@ func (s *Session) DeathNotify() chan bool {
 //
 // If this session is already dead, this function is no-op.
 func (s *Session) Kill() {
-	if !s.Dead() {
-		// Signal death
-		s.deathNotify <- true
-
-		// Wait for completion
-		<-s.deathCompletedNotify
+	// Signal death, unless it is already dead.
+	select {
+	case s.deathNotify <- true:
+	case <-s.died:
 	}
+
+	// Wait for completion
+	<-s.died
 }
 
 // Connections returns an list of all the underlying connections which
@@ -133,7 +156,10 @@ func (s *Session) waitToSave() {
 		// Wait for session data to change
 		select {
 		case whatChanged := <-w:
-			err := sp.Save(s.key, whatChanged, s.Store)
+			if s.isExpired() {
+				continue
+			}
+			err := sp.Save(s.sessionKey(), whatChanged, s.Store)
 			if err != nil {
 				logger().Println(err)
 			}
@@ -147,7 +173,10 @@ func (s *Session) waitToSave() {
 			for {
 				select {
 				case whatChanged := <-w:
-					err := sp.Save(s.key, whatChanged, s.Store)
+					if s.isExpired() {
+						continue
+					}
+					err := sp.Save(s.sessionKey(), whatChanged, s.Store)
 					if err != nil {
 						logger().Println(err)
 					}
@@ -166,6 +195,11 @@ func (s *Session) waitToSave() {
 func (s *Session) waitForDeath() {
 	<-s.deathNotify
 
+	// Connections removing themselves must not try to kill us again.
+	s.access.Lock()
+	s.dying = true
+	s.access.Unlock()
+
 	// Request each connection's death first
 	for _, conn := range s.Connections() {
 		conn.Kill()
@@ -181,6 +215,11 @@ func (s *Session) waitForDeath() {
 
 	s.dead = true
 
+	// Leave our user, see user.go
+	if s.user != nil {
+		s.server.setUser(s, s.user, nil)
+	}
+
 	deathNotifications := make([]chan bool, len(s.deathNotifications))
 	for i, ch := range s.deathNotifications {
 		deathNotifications[i] = ch
@@ -194,26 +233,54 @@ func (s *Session) waitForDeath() {
 		close(ch)
 	}
 
-	for _, key := range s.Store.Keys() {
-		err := sp.Save(s.key, key, s.Store)
-		if err != nil {
-			logger().Println(err)
+	if s.isExpired() {
+		// It's data must not come back, see expiry.go
+		if se, ok := sp.(SessionExpirer); ok {
+			if err := se.Delete(s.sessionKey()); err != nil {
+				logger().Println(err)
+			}
+		}
+	} else {
+		for _, key := range s.Store.Keys() {
+			err := sp.Save(s.sessionKey(), key, s.Store)
+			if err != nil {
+				logger().Println(err)
+			}
 		}
+
+		// It is idle from now on, see expiry.go
+		s.server.expireSession(s, false)
 	}
 
-	s.server.uncache(s.key)
+	s.server.uncache(s.sessionKey())
 
 	logger().Println("DeathNotify():", s)
-	s.deathCompletedNotify <- true
+	close(s.died)
+
+	// Defined in lifecycle.go
+	s.server.doSessionHandler(SessionDied, s)
 	s.stopSaving <- true
 }
 
+// authenticate gives this session the identity, unless it already has one. It
+// returns false if this session belongs to an different identity.
+func (s *Session) authenticate(identity Identity) bool {
+	s.access.Lock()
+	defer s.access.Unlock()
+
+	if s.identity == nil {
+		s.identity = identity
+		return true
+	}
+	return sameIdentity(s.identity, identity)
+}
+
 func (s *Session) removeConnection(key interface{}) {
 	s.access.Lock()
 
 	delete(s.connections, key)
 
-	if len(s.connections) == 0 {
+	if len(s.connections) == 0 && !s.dying {
 		s.access.Unlock()
 
 		// No more connections to represent this session, kill it.
@@ -253,7 +320,7 @@ func newSession(key string, server *Server) *Session {
 	s.dead = false
 	s.connections = make(map[interface{}]*Connection)
 	s.deathNotify = make(chan bool)
-	s.deathCompletedNotify = make(chan bool)
+	s.died = make(chan bool)
 	s.deathNotifications = make([]chan bool, 0)
 	s.stopSaving = make(chan bool)
 	return s
@@ -0,0 +1,96 @@
+// Copyright 2012 Lightpoke. All rights reserved.
+// This source code is subject to the terms and
+// conditions defined in the "License.txt" file.
+
+package organics
+
+import (
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+// The header, and the WebSocket subprotocol, which carry the session key in
+// token mode (see Server.SetSessionTokens).
+//
+// The subprotocol is "organics-session" when the client has no session key
+// yet, or "organics-session." followed by the hex encoded session key (as
+// subprotocols may not contain all of the characters an session key may).
+const (
+	hdrSession = "X-Organics-Session"
+	spSession  = "organics-session"
+)
+
+// SessionCookie describes the cookie which carries the session key, see
+// Server.SetSessionCookie().
+//
+// Each field is an attribute of the cookie, as described by the net/http
+// package's Cookie type.
+type SessionCookie struct {
+	Name     string
+	Path     string
+	Domain   string
+	MaxAge   int
+	Secure   bool
+	HttpOnly bool
+	SameSite http.SameSite
+}
+
+// cookie returns an new cookie, with the attributes of c, which carries the
+// session key.
+func (c SessionCookie) cookie(sessionKey string) *http.Cookie {
+	return &http.Cookie{
+		Name:     c.Name,
+		Value:    sessionKey,
+		Path:     c.Path,
+		Domain:   c.Domain,
+		MaxAge:   c.MaxAge,
+		Secure:   c.Secure,
+		HttpOnly: c.HttpOnly,
+		SameSite: c.SameSite,
+	}
+}
+
+// sessionToken returns the session key which the request carries in token
+// mode (empty if they have none yet), or false if the request is not in token
+// mode (or the server does not allow it).
+func (s *Server) sessionToken(req *http.Request) (string, bool) {
+	if !s.SessionTokens() {
+		return "", false
+	}
+	if values, ok := req.Header[http.CanonicalHeaderKey(hdrSession)]; ok && len(values) > 0 {
+		return values[0], true
+	}
+
+	// WebSocket handshakes cannot carry custom headers, so the session key is
+	// offered as an subprotocol instead.
+	for _, value := range req.Header["Sec-Websocket-Protocol"] {
+		for _, protocol := range strings.Split(value, ",") {
+			protocol = strings.TrimSpace(protocol)
+			if protocol == spSession {
+				return "", true
+			}
+			if strings.HasPrefix(protocol, spSession+".") {
+				key, err := hex.DecodeString(strings.TrimPrefix(protocol, spSession+"."))
+				if err != nil {
+					return "", true
+				}
+				return string(key), true
+			}
+		}
+	}
+	return "", false
+}
+
+// requestSessionKey returns the session key which the request carries, either
+// in token mode or in the session cookie, or false if it carries none.
+func (s *Server) requestSessionKey(req *http.Request) (string, bool) {
+	if key, ok := s.sessionToken(req); ok {
+		return key, len(key) > 0
+	}
+	cookie, err := req.Cookie(s.SessionCookie().Name)
+	if err != nil {
+		return "", false
+	}
+	return cookie.Value, true
+}
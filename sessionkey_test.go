@@ -0,0 +1,96 @@
+// Copyright 2012 Lightpoke. All rights reserved.
+// This source code is subject to the terms and
+// conditions defined in the "License.txt" file.
+
+package organics_test
+
+import (
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/websocket"
+
+	"github.com/sinni800/organics"
+)
+
+// establishWith makes an long-polling establish request carrying the headers,
+// and returns the response.
+func (ts *testServer) establishWith(t *testing.T, header http.Header) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest("POST", ts.http.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	req.Header.Set("X-Organics-Req", "lpec")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp
+}
+
+func TestSessionCookie(t *testing.T) {
+	s := newServer(t)
+	s.SetSessionCookie(organics.SessionCookie{
+		Name:     "sid",
+		Path:     "/app",
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+
+	// Tokens are not handed out unless token mode is enabled.
+	resp := s.establishWith(t, http.Header{"X-Organics-Session": {""}})
+	if token := resp.Header.Get("X-Organics-Session"); token != "" {
+		t.Fatalf("got session token %q without token mode", token)
+	}
+	cookie := resp.Header.Get("Set-Cookie")
+	for _, want := range []string{"sid=", "Path=/app", "Secure", "HttpOnly", "SameSite=Strict"} {
+		if !strings.Contains(cookie, want) {
+			t.Fatalf("session cookie %q lacks %q", cookie, want)
+		}
+	}
+}
+
+func TestSessionTokens(t *testing.T) {
+	s := newServer(t)
+	s.SetSessionTokens(true)
+
+	// Long-polling: the token is sent back in the header, never as an cookie.
+	resp := s.establishWith(t, http.Header{"X-Organics-Session": {""}})
+	token := resp.Header.Get("X-Organics-Session")
+	if token == "" || len(resp.Cookies()) != 0 {
+		t.Fatalf("got token %q and cookies %v, want only an token", token, resp.Cookies())
+	}
+	session := receive(t, s.connected).Session()
+
+	resp = s.establishWith(t, http.Header{"X-Organics-Session": {token}})
+	if resp.Header.Get("X-Organics-Session") != token {
+		t.Fatal("token changed for the same session")
+	}
+	if receive(t, s.connected).Session() != session {
+		t.Fatal("token did not select it's session")
+	}
+
+	// WebSocket: the token is carried by an subprotocol, an new session sends
+	// it's token first.
+	ws, c := s.rawWebSocket(t, "organics.json", "organics-session")
+	var frame string
+	if err := websocket.Message.Receive(ws, &frame); err != nil || !strings.HasPrefix(frame, `["t","`) {
+		t.Fatalf("got first frame %s (%v), want the session token", frame, err)
+	}
+	if c.Session() == session {
+		t.Fatal("no token selected an existing session")
+	}
+
+	_, c = s.rawWebSocket(t, "organics.json", "organics-session."+hex.EncodeToString([]byte(token)))
+	if c.Session() != session {
+		t.Fatal("token subprotocol did not select it's session")
+	}
+}
@@ -12,6 +12,9 @@ package organics
 //
 // Session providers Set() and Get() methods must be safe to call from multiple
 // goroutines.
+//
+// Session providers may also implement the SessionExpirer interface, in order
+// for the data of sessions which are no longer used to expire.
 type SessionProvider interface {
 	// Save should save the store's underlying data however the provider deems
 	// proper. The key is guaranteed to be unique and will be the same key that
@@ -0,0 +1,173 @@
+// Copyright 2012 Lightpoke. All rights reserved.
+// This source code is subject to the terms and
+// conditions defined in the "License.txt" file.
+
+package organics
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// ErrShuttingDown is returned by ServeTransport once Shutdown has been called.
+var ErrShuttingDown = errors.New("organics: server is shutting down")
+
+// Shutdown gracefully shuts down the server:
+//
+//  1. New connections are refused (including resumed ones).
+//  2. Every connected client is told that the server is going away, so that it
+//     may reconnect later on (e.g. to another server, once this one is gone).
+//  3. In-flight request handlers are waited for; requests which come in from
+//     now on are refused using the CodeShuttingDown error code.
+//  4. Every connection and session is killed, which saves each session's data
+//     through the session provider.
+//
+// Shutdown returns once all of the above is done, or returns ctx.Err() once
+// the context is cancelled or it's deadline is exceeded, whichever happens
+// first. Either way, the context of every request handler still running is
+// cancelled once it returns.
+//
+// Shutdown does not close the listener the server is being served on, that is
+// up to the http.Server (which should be shut down afterwards).
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.access.Lock()
+	s.shuttingDown = true
+	s.access.Unlock()
+	defer s.ctxCancel()
+
+	// Tell everyone we're going away.
+	s.eachConnection(func(c *Connection) {
+		c.goAway(ctx)
+	})
+
+	// Wait for the request handlers to finish. Should ctx be done first, the
+	// handlers' contexts are cancelled as we return, so the waiting goroutine
+	// does not outlive them.
+	idle := make(chan bool)
+	go func() {
+		s.access.Lock()
+		for s.requests > 0 {
+			s.requestsDone.Wait()
+		}
+		s.access.Unlock()
+		close(idle)
+	}()
+	select {
+	case <-idle:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	// Make sure their responses have been sent.
+	s.eachConnection(func(c *Connection) {
+		c.flush(ctx)
+	})
+
+	// Kill every connection, and every session which has none, each session
+	// saves it's data as it dies.
+	done := make(chan bool)
+	go func() {
+		for _, c := range s.Connections() {
+			c.kill(DisconnectShutdown)
+		}
+		for _, session := range s.cachedSessions() {
+			session.Kill()
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// ShuttingDown tells weather Shutdown has been called on this server.
+func (s *Server) ShuttingDown() bool {
+	s.access.RLock()
+	defer s.access.RUnlock()
+
+	return s.shuttingDown
+}
+
+// requestStarted and requestDone count the requests which have been taken from
+// their connection (see serveRequests) but not yet handled, requestsDone is
+// signalled once there are none left.
+func (s *Server) requestStarted() {
+	s.access.Lock()
+	defer s.access.Unlock()
+
+	s.requests++
+}
+
+func (s *Server) requestDone() {
+	s.access.Lock()
+	defer s.access.Unlock()
+
+	s.requests--
+	if s.requests == 0 {
+		s.requestsDone.Broadcast()
+	}
+}
+
+// eachConnection invokes fn for each connection concurrently, and waits for
+// each of them to return.
+func (s *Server) eachConnection(fn func(c *Connection)) {
+	var wg sync.WaitGroup
+	for _, c := range s.Connections() {
+		wg.Add(1)
+		go func(c *Connection) {
+			fn(c)
+			wg.Done()
+		}(c)
+	}
+	wg.Wait()
+}
+
+func (s *Server) cachedSessions() []*Session {
+	s.access.RLock()
+	defer s.access.RUnlock()
+
+	sessions := make([]*Session, 0, len(s.sessions))
+	for _, session := range s.sessions {
+		sessions = append(sessions, session)
+	}
+	return sessions
+}
+
+// goAway tells the other end of this connection that the server is going
+// away, it blocks until the going away frame has been sent (or could not be).
+func (c *Connection) goAway(ctx context.Context) {
+	c.tellWriter(ctx, c.goingAway)
+}
+
+// flush blocks until everything queued for the writer so far has been sent (or
+// could not be).
+func (c *Connection) flush(ctx context.Context) {
+	c.tellWriter(ctx, c.flushNotify)
+}
+
+// tellWriter hands an channel to the connection's writer using notify, and
+// waits for the writer to close it.
+func (c *Connection) tellWriter(ctx context.Context, notify chan chan bool) {
+	if c.currentTransport() == nil {
+		// An resumable connection without an transport, there is no writer.
+		return
+	}
+
+	sent := make(chan bool)
+	select {
+	case notify <- sent:
+		select {
+		case <-sent:
+		case <-c.ctx.Done():
+		case <-ctx.Done():
+		}
+
+	case <-c.ctx.Done():
+	case <-ctx.Done():
+	}
+}
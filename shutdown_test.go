@@ -0,0 +1,162 @@
+// Copyright 2012 Lightpoke. All rights reserved.
+// This source code is subject to the terms and
+// conditions defined in the "License.txt" file.
+
+package organics_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sinni800/organics"
+	"github.com/sinni800/organics/client"
+	"github.com/sinni800/organics/provider/memory"
+)
+
+// savingProvider is an session provider which remembers the last value saved
+// for each store key, of any session.
+type savingProvider struct {
+	organics.SessionProvider
+
+	access sync.Mutex
+	saved  map[string]interface{}
+}
+
+func newSavingProvider() *savingProvider {
+	return &savingProvider{
+		SessionProvider: memory.Provider(),
+		saved:           make(map[string]interface{}),
+	}
+}
+
+func (p *savingProvider) Save(sessionKey, key string, store *organics.Store) error {
+	p.access.Lock()
+	p.saved[key] = store.Get(key, nil)
+	p.access.Unlock()
+	return p.SessionProvider.Save(sessionKey, key, store)
+}
+
+func (p *savingProvider) get(key string) interface{} {
+	p.access.Lock()
+	defer p.access.Unlock()
+
+	return p.saved[key]
+}
+
+func TestShutdown(t *testing.T) {
+	provider := newSavingProvider()
+	s := newProviderServer(t, provider)
+
+	started := make(chan bool, 2)
+	s.Handle("Slow", func(name string, c *organics.Connection) string {
+		started <- true
+		time.Sleep(200 * time.Millisecond)
+		c.Session().Set(name, "done")
+		return name
+	})
+
+	var clients []*client.Connection
+	responses := make(chan string, 2)
+	for name, url := range s.urls() {
+		c, _ := s.dial(t, url)
+		c.Request("Slow", name, func(v string) {
+			responses <- v
+		})
+		clients = append(clients, c)
+	}
+	receive(t, started)
+	receive(t, started)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- s.Shutdown(ctx)
+	}()
+
+	// New connections are refused meanwhile.
+	time.Sleep(50 * time.Millisecond)
+	for name, url := range s.urls() {
+		if _, err := client.Dial(url); err == nil {
+			t.Fatalf("%s Dial() during Shutdown() succeeded", name)
+		}
+	}
+
+	if err := receive(t, done); err != nil {
+		t.Fatalf("Shutdown() = %v", err)
+	}
+
+	// The in-flight requests completed, and their sessions were saved.
+	receive(t, responses)
+	receive(t, responses)
+	for name := range s.urls() {
+		if v := provider.get(name); v != "done" {
+			t.Fatalf("saved %s = %v, want done", name, v)
+		}
+	}
+	for _, c := range clients {
+		if !c.GoingAway() {
+			t.Fatal("client was not told that the server is going away")
+		}
+		eventually(t, "the client is dead", c.Dead)
+	}
+	if n := len(s.Connections()); n != 0 {
+		t.Fatalf("%d connections left after Shutdown()", n)
+	}
+}
+
+func TestShutdownAfterDisconnect(t *testing.T) {
+	provider := newSavingProvider()
+	s := newProviderServer(t, provider)
+
+	started := make(chan bool, 5)
+	s.Handle("Slow", func(c *organics.Connection) {
+		started <- true
+		c.Session().Set("slow", "done")
+		time.Sleep(100 * time.Millisecond)
+	})
+
+	// Requests still waiting to be handled when the connection dies are
+	// never handled, Shutdown must not wait for them.
+	c, sc := s.dial(t, s.wsURL())
+	for i := 0; i < 5; i++ {
+		c.Request("Slow")
+	}
+	receive(t, started)
+	c.Kill()
+	eventually(t, "the connection is dead", sc.Dead)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown() = %v", err)
+	}
+	if v := provider.get("slow"); v != "done" {
+		t.Fatalf("saved slow = %v, want done", v)
+	}
+}
+
+func TestShutdownDeadline(t *testing.T) {
+	s := newServer(t)
+	started := make(chan bool, 1)
+	cancelled := make(chan bool, 1)
+	s.Handle("Stuck", func(ctx context.Context, c *organics.Connection) {
+		started <- true
+		<-ctx.Done()
+		cancelled <- true
+	})
+	c, _ := s.dial(t, s.wsURL())
+	c.Request("Stuck", func() {})
+	receive(t, started)
+
+	// Shutdown gives up on handlers which outlast it's context, and cancels
+	// theirs.
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("Shutdown() = %v, want context.DeadlineExceeded", err)
+	}
+	receive(t, cancelled)
+}
@@ -0,0 +1,184 @@
+// Copyright 2012 Lightpoke. All rights reserved.
+// This source code is subject to the terms and
+// conditions defined in the "License.txt" file.
+
+package organics
+
+import (
+	"bytes"
+	"encoding/base64"
+	"net/http"
+	"strings"
+)
+
+// Event names used in the event stream, anything else is sent as an unnamed
+// ("message") event.
+const (
+	seConnect = "c" // carries the connection id (and codec), it is the first event sent
+	sePing    = "p" // an ping, the browser answers with an empty rtMessage
+)
+
+// sseAccepted tells weather the request is one for an event stream, that is
+// the browser's EventSource.
+func sseAccepted(req *http.Request) bool {
+	for _, accept := range req.Header["Accept"] {
+		for _, v := range strings.Split(accept, ",") {
+			if strings.HasPrefix(strings.TrimSpace(v), "text/event-stream") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// sseEncodeEvent encodes an single event of the event stream into buf.
+func sseEncodeEvent(buf *bytes.Buffer, event string, data []byte) {
+	if len(event) > 0 {
+		buf.WriteString("event: " + event + "\n")
+	}
+
+	// Each line of the data must be prefixed, the browser joins them back
+	// together using newlines.
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		buf.WriteString("data: ")
+		buf.Write(line)
+		buf.WriteString("\n")
+	}
+	buf.WriteString("\n")
+}
+
+// sseWriteEvents writes the encoded events to the event stream, all at once.
+func sseWriteEvents(w http.ResponseWriter, buf *bytes.Buffer) error {
+	_, err := w.Write(buf.Bytes())
+	if err != nil {
+		return err
+	}
+	w.(http.Flusher).Flush()
+	return nil
+}
+
+func (s *Server) sseHandleRequest(w http.ResponseWriter, req *http.Request) {
+	// The browser only sends an Origin header for cross-origin event streams,
+	// in which case it is checked just like WebSocket's is.
+	origin := req.Header.Get("Origin")
+	if len(origin) > 0 {
+		if !s.OriginAccess(origin) {
+			logger().Println("Event stream from non-allowed origin, dropped.")
+			if len(origin) <= 256 {
+				logger().Printf("^ %q\n", origin)
+			}
+			w.WriteHeader(http.StatusForbidden)
+			req.Close = true
+			return
+		}
+
+		// The session cookie is only sent cross-origin when the EventSource
+		// is created using withCredentials, which requires these headers.
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+	}
+
+	if _, ok := w.(http.Flusher); !ok {
+		logger().Println("Event stream requires an http.Flusher ResponseWriter, dropped.")
+		w.WriteHeader(http.StatusInternalServerError)
+		req.Close = true
+		return
+	}
+
+	// Defined in auth.go
+	req, ok := s.authenticate(w, req)
+	if !ok {
+		return
+	}
+
+	session, ok := s.ensureSessionExists(req, func(cookie *http.Cookie) {
+		http.SetCookie(w, cookie)
+	})
+	if !ok {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// Just like long-polling, the connection id is sent to them and they send
+	// it back through the X-Organics-Conn header of their rtMessage requests,
+	// so it is the CSRF token as well.
+	connectionId, err := s.generateSessionKey()
+	if err != nil {
+		// This should really never happen
+		logger().Println("Failed to generate connection key identifier:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		req.Close = true
+		return
+	}
+
+	// Create their new connection, or resume the one they ask for (in which case it's key is the
+	// connection id).
+	//
+	// The codec is negotiated here too, as the browser cannot set headers on an event stream
+	// they offer codecs through an query parameter instead (see codec.go).
+	offered := req.URL.Query().Get(qpCodec)
+	t := newLpTransport(ServerSentEvents, s.negotiateCodecNames(offered), s.LongPollBatchSize())
+	connection, resumed := s.connectionFor(req, session, connectionId, t)
+
+	// Serve the connection from now on, frames sent to them are written below,
+	// only after the connection id has been.
+	//
+	// Defined in dispatch.go
+	go s.serve(t, connection, resumed)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	// If they offered any codecs, the chosen one follows the connection id on
+	// an line of it's own.
+	connect := connection.key.(string)
+	if len(offered) > 0 {
+		connect += "\n" + t.codec.Name()
+	}
+	buf := new(bytes.Buffer)
+	sseEncodeEvent(buf, seConnect, []byte(connect))
+	if sseWriteEvents(w, buf) != nil {
+		t.Close()
+		return
+	}
+
+	// The event stream lives as long as the connection does.
+	for {
+		select {
+		case <-t.closed:
+			return
+
+		case <-w.(http.CloseNotifier).CloseNotify():
+			// They closed the event stream (refreshing, losing connectivity...), the connection is
+			// killed, unless it is resumable.
+			t.Close()
+			return
+
+		case <-t.ready:
+			// Everything which is queued is written at once, an event per
+			// frame. Event data is text, so binary frames are base64
+			// encoded.
+			buf.Reset()
+			for _, frame := range t.take() {
+				// An empty frame is an ping, but an event with no data is
+				// never dispatched by the browser, so it is sent as an named
+				// event.
+				event := ""
+				if len(frame) == 0 {
+					event = sePing
+					frame = []byte(sePing)
+				} else if t.codec.Binary() {
+					frame = []byte(base64.StdEncoding.EncodeToString(frame))
+				}
+				sseEncodeEvent(buf, event, frame)
+			}
+			if buf.Len() == 0 {
+				continue
+			}
+			if sseWriteEvents(w, buf) != nil {
+				t.Close()
+				return
+			}
+		}
+	}
+}
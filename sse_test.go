@@ -0,0 +1,139 @@
+// Copyright 2012 Lightpoke. All rights reserved.
+// This source code is subject to the terms and
+// conditions defined in the "License.txt" file.
+
+package organics_test
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/cookiejar"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sinni800/organics"
+)
+
+// event is an single server-sent event.
+type event struct {
+	name, data string
+}
+
+// eventStream connects to the server at the URL using Server-Sent Events, it
+// returns the HTTP client (which carries the session cookie), and the events as
+// they arrive, which is closed once the stream ends.
+func (ts *testServer) eventStream(t *testing.T, url string) (*http.Client, chan event) {
+	t.Helper()
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hc := &http.Client{Jar: jar}
+
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	resp, err := hc.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Content-Type = %q, want text/event-stream", ct)
+	}
+
+	events := make(chan event, 16)
+	go func() {
+		defer close(events)
+		r := bufio.NewReader(resp.Body)
+		var e event
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			line = strings.TrimSuffix(line, "\n")
+			switch {
+			case line == "":
+				// Like the browser, data lines are joined using newlines.
+				e.data = strings.TrimSuffix(e.data, "\n")
+				events <- e
+				e = event{}
+			case strings.HasPrefix(line, "event: "):
+				e.name = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				e.data += strings.TrimPrefix(line, "data: ") + "\n"
+			}
+		}
+	}()
+	return hc, events
+}
+
+func TestServerSentEvents(t *testing.T) {
+	s := newServer(t)
+	s.SetPingRate(100 * time.Millisecond)
+	s.Handle("Add", func(a, b float64, c *organics.Connection) float64 {
+		return a + b
+	})
+
+	hc, events := s.eventStream(t, s.http.URL)
+	first := receive(t, events)
+	if first.name != "c" || first.data == "" {
+		t.Fatalf("first event = %+v, want the connection id", first)
+	}
+	c := receive(t, s.connected)
+	if c.Method() != organics.ServerSentEvents {
+		t.Fatalf("Method() = %v, want ServerSentEvents", c.Method())
+	}
+
+	// Frames are sent to the server by message POSTs.
+	post := func(body string) {
+		req, err := http.NewRequest("POST", s.http.URL, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("X-Organics-Req", "m")
+		req.Header.Set("X-Organics-Conn", first.data)
+		resp, err := hc.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("message POST status = %s", resp.Status)
+		}
+	}
+	post(`[1,"Add",[1,2]]`)
+
+	gotResponse, gotPing := false, false
+	for !gotResponse || !gotPing {
+		e := receive(t, events)
+		switch {
+		case e.name == "p":
+			gotPing = true
+			post("")
+		case e.data == "[1,[3]]":
+			gotResponse = true
+		}
+	}
+	if c.Dead() {
+		t.Fatal("connection died while answering pings")
+	}
+
+	// Once killed, the stream ends.
+	c.Kill()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case _, ok := <-events:
+			if !ok {
+				return
+			}
+		case <-timeout:
+			t.Fatal("event stream did not end after Kill")
+		}
+	}
+}
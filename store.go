@@ -24,6 +24,7 @@ type Store struct {
 	data                map[string]interface{}
 	dataChangeNotifiers []chan bool
 	dataWatchers        map[chan string]bool
+	keyWatchers         map[*keyWatcher]bool
 }
 
 func (s *Store) sendDataChanged() {
@@ -35,6 +36,9 @@ func (s *Store) sendDataChanged() {
 }
 
 func (s *Store) doKeyChanged(key string) {
+	for w := range s.keyWatchers {
+		w.changed(key)
+	}
 	for ch, active := range s.dataWatchers {
 		if active {
 			if len(ch) == cap(ch) {
@@ -85,6 +89,33 @@ func (s *Store) ChangeWatcher() chan string {
 	return ch
 }
 
+// addKeyWatcher adds an key watcher, which is told about the keys of data
+// inside this store as they are added, removed, or changed, until it is
+// removed using removeKeyWatcher().
+//
+// Unlike ChangeWatcher(), telling it never blocks nor starts any goroutines.
+func (s *Store) addKeyWatcher() *keyWatcher {
+	s.access.Lock()
+	defer s.access.Unlock()
+
+	if s.keyWatchers == nil {
+		// An store decoded by gob.
+		s.keyWatchers = make(map[*keyWatcher]bool)
+	}
+	w := newKeyWatcher()
+	s.keyWatchers[w] = true
+	return w
+}
+
+// removeKeyWatcher removes the key watcher, which is not told about any
+// change from now on.
+func (s *Store) removeKeyWatcher(w *keyWatcher) {
+	s.access.Lock()
+	defer s.access.Unlock()
+
+	delete(s.keyWatchers, w)
+}
+
 // ChangeNotify returns an channel over which true will be sent once the data
 // inside this store has changed.
 //
@@ -229,6 +260,16 @@ func (s *Store) Has(key string) bool {
 	return ok
 }
 
+// lookup returns the specified key from this stores data, and weather there is
+// such key, without setting it.
+func (s *Store) lookup(key string) (interface{}, bool) {
+	s.access.RLock()
+	defer s.access.RUnlock()
+
+	value, ok := s.data[key]
+	return value, ok
+}
+
 // Set sets the specified key to the specified value
 func (s *Store) Set(key string, value interface{}) {
 	s.access.Lock()
@@ -307,5 +348,6 @@ func NewStore() *Store {
 	s.data = make(map[string]interface{})
 	s.dataChangeNotifiers = make([]chan bool, 0)
 	s.dataWatchers = make(map[chan string]bool)
+	s.keyWatchers = make(map[*keyWatcher]bool)
 	return s
 }
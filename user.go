@@ -0,0 +1,164 @@
+// Copyright 2012 Lightpoke. All rights reserved.
+// This source code is subject to the terms and
+// conditions defined in the "License.txt" file.
+
+package organics
+
+// User is the group of sessions which belong to an single user, i.e. which
+// were given the same user id using the Session's SetUser() method.
+//
+// Users are retrieved using the Server's User() method, for example an chat
+// server might notify an user on all of their devices at once:
+//
+//  func doLogin(name, password string, connection *organics.Connection) bool {
+//      ... check the password ...
+//      connection.Session().SetUser(name)
+//      return true
+//  }
+//
+//  func doPrivateMessage(to, msg string, connection *organics.Connection) {
+//      server.User(to).Request("DisplayMessage", msg)
+//  }
+//
+// Sessions leave their user automatically once they die.
+type User struct {
+	id     interface{}
+	server *Server
+}
+
+// ID returns the id of this user, as it was passed into the Server's User()
+// method.
+func (u *User) ID() interface{} {
+	return u.id
+}
+
+// Sessions returns all sessions which currently belong to this user.
+func (u *User) Sessions() []*Session {
+	u.server.access.RLock()
+	defer u.server.access.RUnlock()
+
+	sessions := make([]*Session, 0, len(u.server.users[u.id]))
+	for session := range u.server.users[u.id] {
+		sessions = append(sessions, session)
+	}
+	return sessions
+}
+
+// Connections returns all connections of every session which currently
+// belongs to this user.
+func (u *User) Connections() []*Connection {
+	var connections []*Connection
+	for _, session := range u.Sessions() {
+		connections = append(connections, session.Connections()...)
+	}
+	return connections
+}
+
+// Online tells weather this user currently has any session.
+func (u *User) Online() bool {
+	u.server.access.RLock()
+	defer u.server.access.RUnlock()
+
+	return len(u.server.users[u.id]) > 0
+}
+
+// Request makes an request to each connection of this user, see the
+// Connection's Request() method for the meaning of the parameters.
+//
+// Note that if an function is given to be called when the request has
+// completed, then it is called once for each connection which completes it.
+func (u *User) Request(requestName interface{}, sequence ...interface{}) {
+	for _, c := range u.Connections() {
+		c.Request(requestName, sequence...)
+	}
+}
+
+// Kill kills every session of this user (and as such, each of their
+// connections), e.g. to sign them out everywhere.
+func (u *User) Kill() {
+	for _, session := range u.Sessions() {
+		session.Kill()
+	}
+}
+
+// User returns the user with the specified id, see the Session's SetUser()
+// method.
+//
+// An user which has no sessions (e.g. one which is not signed in) is still
+// returned, it simply has no sessions or connections.
+func (s *Server) User(id interface{}) *User {
+	return &User{id: id, server: s}
+}
+
+// Users returns all users which currently have an session.
+func (s *Server) Users() []*User {
+	s.access.RLock()
+	defer s.access.RUnlock()
+
+	users := make([]*User, 0, len(s.users))
+	for id := range s.users {
+		users = append(users, &User{id: id, server: s})
+	}
+	return users
+}
+
+// setUser moves the session from the user with the old id to the user with the
+// new id, an nil id being no user.
+func (s *Server) setUser(session *Session, old, id interface{}) {
+	s.access.Lock()
+	defer s.access.Unlock()
+
+	if old != nil {
+		delete(s.users[old], session)
+		if len(s.users[old]) == 0 {
+			delete(s.users, old)
+		}
+	}
+	if id != nil {
+		sessions, ok := s.users[id]
+		if !ok {
+			sessions = make(map[*Session]bool)
+			s.users[id] = sessions
+		}
+		sessions[session] = true
+	}
+}
+
+// SetUser specifies the id of the user which this session belongs to, such
+// that it can be found using the Server's User() method, e.g. once they have
+// signed in. An nil id means the session belongs to no user, e.g. once they
+// have signed out.
+//
+// The id may be of any type which can be used as an map key, usually an
+// string or an integer. Using the identity which the server's authenticator
+// gave this session is common:
+//
+//  connection.Session().SetUser(connection.Identity())
+//
+// The user id is not stored by the session provider, an session which is
+// restored from it (see SessionRestored) belongs to no user.
+//
+// If this session is dead, this function is no-op.
+func (s *Session) SetUser(id interface{}) {
+	// The session stays locked while the server's index is updated, so that
+	// it is never left in it once dead (see waitForDeath).
+	s.access.Lock()
+	defer s.access.Unlock()
+
+	if s.dead || s.user == id {
+		return
+	}
+	s.server.setUser(s, s.user, id)
+	s.user = id
+}
+
+// User returns the id of the user which this session belongs to, or nil if
+// it belongs to no user.
+//
+// See SetUser() for more information about this value.
+func (s *Session) User() interface{} {
+	s.access.RLock()
+	defer s.access.RUnlock()
+
+	return s.user
+}
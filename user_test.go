@@ -0,0 +1,69 @@
+// Copyright 2012 Lightpoke. All rights reserved.
+// This source code is subject to the terms and
+// conditions defined in the "License.txt" file.
+
+package organics_test
+
+import (
+	"testing"
+
+	"github.com/sinni800/organics"
+	"github.com/sinni800/organics/client"
+)
+
+func TestUsers(t *testing.T) {
+	s := newServer(t)
+	s.Handle("Login", func(name string, c *organics.Connection) {
+		c.Session().SetUser(name)
+	})
+	notified := make(chan string, 4)
+	login := func() (*client.Connection, *organics.Connection) {
+		c, sc := s.dial(t, s.wsURL())
+		c.Handle("Notify", func(message string, c *client.Connection) {
+			notified <- message
+		})
+		done := make(chan bool)
+		c.Request("Login", "alice", func() {
+			done <- true
+		})
+		receive(t, done)
+		return c, sc
+	}
+	_, first := login()
+	login()
+
+	u := s.User("alice")
+	if u.ID() != "alice" || !u.Online() || len(u.Sessions()) != 2 || len(u.Connections()) != 2 || len(s.Users()) != 1 {
+		t.Fatalf("alice has %d sessions and %d connections, want 2 of each", len(u.Sessions()), len(u.Connections()))
+	}
+	if s.User("bob").Online() {
+		t.Fatal("bob is online but never logged in")
+	}
+	u.Request("Notify", "hello")
+	for i := 0; i < 2; i++ {
+		if m := receive(t, notified); m != "hello" {
+			t.Fatalf("notified %q, want hello", m)
+		}
+	}
+
+	// An dead session leaves the index.
+	first.Session().Kill()
+	eventually(t, "alice has one session", func() bool {
+		return len(u.Sessions()) == 1
+	})
+
+	session := u.Sessions()[0]
+	session.SetUser(nil)
+	if u.Online() || len(s.Users()) != 0 {
+		t.Fatal("alice is still online after SetUser(nil)")
+	}
+	session.SetUser("alice")
+	u.Kill()
+	if u.Online() || !session.Dead() {
+		t.Fatal("alice is still online after Kill()")
+	}
+	session.SetUser("alice")
+	if u.Online() {
+		t.Fatal("an dead session joined the index")
+	}
+}
@@ -0,0 +1,183 @@
+// Copyright 2012 Lightpoke. All rights reserved.
+// This source code is subject to the terms and
+// conditions defined in the "License.txt" file.
+
+package organics
+
+import (
+	"sync"
+)
+
+// StoreScope describes which store an key belongs to, see
+// Server.SetStoreAccess().
+type StoreScope uint8
+
+const (
+	// The store of the connection's session, shared by all of it's
+	// connections.
+	SessionStore StoreScope = iota
+
+	// The store of the connection itself.
+	ConnectionStore
+)
+
+// String returns an string formatted version of the specified scope, or an
+// empty string if the scope is invalid (unknown).
+func (s StoreScope) String() string {
+	switch s {
+	case SessionStore:
+		return "SessionStore"
+
+	case ConnectionStore:
+		return "ConnectionStore"
+	}
+	return ""
+}
+
+// frame returns the acronym which stands for the scope in frames.
+func (s StoreScope) frame() string {
+	if s == ConnectionStore {
+		return "c"
+	}
+	return "s"
+}
+
+// scopeFromFrame returns the scope which the acronym stands for in frames, or
+// false if there is no such scope.
+func scopeFromFrame(acronym string) (StoreScope, bool) {
+	switch acronym {
+	case "s":
+		return SessionStore, true
+
+	case "c":
+		return ConnectionStore, true
+	}
+	return 0, false
+}
+
+// storeKey identifies an single key of either an session's store, or an
+// connection's store.
+type storeKey struct {
+	scope StoreScope
+	key   string
+}
+
+// store returns the store of this connection which the scope describes.
+func (c *Connection) store(scope StoreScope) *Store {
+	if scope == ConnectionStore {
+		return c.Store
+	}
+	return c.Session().Store
+}
+
+// watch starts sending the value of the store key to the other end, right away
+// and each time it changes, as long as the server exposes it.
+func (s *Server) watch(c *Connection, k storeKey) {
+	if !s.StoreAccess(k.scope, k.key) {
+		logger().Printf("Watch of non-exposed store key %q (%s), ignoring.\n", k.key, k.scope)
+		return
+	}
+
+	c.access.Lock()
+	keys, watching := c.watching[k.scope]
+	if !watching {
+		keys = make(map[string]bool)
+		c.watching[k.scope] = keys
+	}
+	keys[k.key] = true
+	c.access.Unlock()
+
+	if !watching {
+		// The store is watched from now on, before the value is sent, so that
+		// no change is missed.
+		store := c.store(k.scope)
+		go c.watchStore(k.scope, store, store.addKeyWatcher())
+	}
+	c.sendValue(k)
+}
+
+// unwatch stops sending the value of the store key to the other end.
+func (c *Connection) unwatch(k storeKey) {
+	c.access.Lock()
+	defer c.access.Unlock()
+
+	delete(c.watching[k.scope], k.key)
+}
+
+// watchStore sends the value of each watched key of the store to the other end
+// as it changes, until this connection is dead.
+//
+// An key which changes several times before it's value is sent is only sent
+// once, with it's latest value.
+func (c *Connection) watchStore(scope StoreScope, store *Store, w *keyWatcher) {
+	defer store.removeKeyWatcher(w)
+
+	for {
+		select {
+		case <-w.ready:
+			for _, key := range w.take() {
+				c.access.RLock()
+				watched := c.watching[scope][key]
+				c.access.RUnlock()
+				if watched {
+					c.sendValue(storeKey{scope, key})
+				}
+			}
+
+		case <-c.ctx.Done():
+			return
+		}
+	}
+}
+
+// sendValue sends the current value of the store key to the other end.
+func (c *Connection) sendValue(k storeKey) {
+	value, ok := c.store(k.scope).lookup(k.key)
+	c.outgoing.push(newValueMessage(k, value, ok))
+}
+
+// keyWatcher collects the keys of an store which changed, see
+// Store.addKeyWatcher().
+//
+// Each key is held only once until they are taken, no matter how often it
+// changed meanwhile, so the store never waits for (nor piles up goroutines for)
+// whoever is watching.
+type keyWatcher struct {
+	access  sync.Mutex
+	changes map[string]bool
+
+	// ready receives an value once there are changes to take.
+	ready chan bool
+}
+
+// changed adds the key to the changes, it never blocks.
+func (w *keyWatcher) changed(key string) {
+	w.access.Lock()
+	w.changes[key] = true
+	w.access.Unlock()
+
+	select {
+	case w.ready <- true:
+	default:
+	}
+}
+
+// take removes and returns the keys which changed.
+func (w *keyWatcher) take() []string {
+	w.access.Lock()
+	defer w.access.Unlock()
+
+	keys := make([]string, 0, len(w.changes))
+	for key := range w.changes {
+		keys = append(keys, key)
+	}
+	w.changes = make(map[string]bool)
+	return keys
+}
+
+func newKeyWatcher() *keyWatcher {
+	w := new(keyWatcher)
+	w.changes = make(map[string]bool)
+	w.ready = make(chan bool, 1)
+	return w
+}
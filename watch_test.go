@@ -0,0 +1,93 @@
+// Copyright 2012 Lightpoke. All rights reserved.
+// This source code is subject to the terms and
+// conditions defined in the "License.txt" file.
+
+package organics_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"golang.org/x/net/websocket"
+
+	"github.com/sinni800/organics"
+)
+
+func TestWatch(t *testing.T) {
+	s := newServer(t)
+	s.SetStoreAccess(organics.SessionStore, "cart", true)
+	s.SetStoreAccess(organics.ConnectionStore, "room", true)
+	s.SetStoreAccess(organics.SessionStore, "revoked", true)
+	s.SetStoreAccess(organics.SessionStore, "revoked", false)
+	if !s.StoreAccess(organics.SessionStore, "cart") || s.StoreAccess(organics.ConnectionStore, "cart") || s.StoreAccess(organics.SessionStore, "revoked") {
+		t.Fatal("StoreAccess() does not match SetStoreAccess()")
+	}
+
+	ws, c := s.rawWebSocket(t)
+	expect := func(want string) {
+		t.Helper()
+		if f := fmt.Sprint(readFrame(t, ws)); f != want {
+			t.Fatalf("got frame %s, want %s", f, want)
+		}
+	}
+	c.Session().Set("cart", 3)
+	c.Session().Set("secret", "password")
+
+	// Keys which are not exposed are never sent.
+	websocket.Message.Send(ws, `["w","s","secret"]`)
+	websocket.Message.Send(ws, `["w","s","cart"]`)
+	expect("[v s cart 3]")
+	websocket.Message.Send(ws, `["w","c","room"]`)
+	expect("[v c room]")
+
+	c.Set("room", "lobby")
+	expect("[v c room lobby]")
+	c.Session().Set("secret", "other password")
+	c.Session().Set("cart", 4)
+	expect("[v s cart 4]")
+	c.Session().Delete("cart")
+	expect("[v s cart]")
+
+	websocket.Message.Send(ws, `["u","s","cart"]`)
+	time.Sleep(50 * time.Millisecond)
+	c.Session().Set("cart", 5)
+	c.Set("room", "hall")
+	expect("[v c room hall]")
+
+	// An unknown scope is an protocol error.
+	websocket.Message.Send(ws, `["w","x","cart"]`)
+	eventually(t, "the connection is dead", c.Dead)
+}
+
+func TestWatchManyChanges(t *testing.T) {
+	s := newServer(t)
+	s.SetStoreAccess(organics.SessionStore, "counter", true)
+	ws, c := s.rawWebSocket(t)
+	websocket.Message.Send(ws, `["w","s","counter"]`)
+	if f := fmt.Sprint(readFrame(t, ws)); f != "[v s counter]" {
+		t.Fatalf("got frame %s, want [v s counter]", f)
+	}
+
+	// Changes faster than they are sent are merged, and the store never waits
+	// for them to be sent.
+	for i := 1; i <= 20000; i++ {
+		c.Session().Set("counter", i)
+	}
+	for {
+		f := fmt.Sprint(readFrame(t, ws))
+		if f == "[v s counter 20000]" {
+			break
+		}
+	}
+
+	// Nor does anything go wrong once the connection dies meanwhile.
+	for i := 0; i < 20000; i++ {
+		c.Session().Set("counter", i)
+	}
+	c.Session().Kill()
+	eventually(t, "the connection is dead", c.Dead)
+	for i := 0; i < 100; i++ {
+		c.Session().Set("counter", i)
+	}
+}
@@ -5,216 +5,81 @@
 package organics
 
 import (
-	"bytes"
 	"golang.org/x/net/websocket"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
-	"reflect"
-	"runtime/debug"
+	"sync"
 )
 
+// wsTransport implements the CodecTransport interface for the WebSocket
+// method.
+type wsTransport struct {
+	ws          *websocket.Conn
+	codec       Codec
+	limit       int64
+	writeAccess sync.Mutex
+}
+
 // See https://code.google.com/p/organics/issues/detail?id=4 (issue 4)
-func sendMessage(ws *websocket.Conn, msg string) error {
-	w, err := ws.NewFrameWriter(websocket.TextFrame)
+func (t *wsTransport) Send(frame []byte) error {
+	t.writeAccess.Lock()
+	defer t.writeAccess.Unlock()
+
+	frameType := byte(websocket.TextFrame)
+	if t.codec.Binary() {
+		frameType = websocket.BinaryFrame
+	}
+	w, err := t.ws.NewFrameWriter(frameType)
 	if err != nil {
 		return err
 	}
-	_, err = w.Write([]byte(msg))
+	_, err = w.Write(frame)
 	w.Close()
 	return err
 }
 
-func receiveMessage(ws *websocket.Conn, limit int64) (string, error) {
+func (t *wsTransport) Receive() ([]byte, error) {
 again:
-	frame, err := ws.NewFrameReader()
-	if err != nil {
-		return "", err
+	frame, err := t.ws.NewFrameReader()
+	if err == nil {
+		frame, err = t.ws.HandleFrame(frame)
 	}
-	frame, err = ws.HandleFrame(frame)
 	if err != nil {
-		return "", err
+		if err == io.EOF {
+			// They closed the connection.
+			return nil, ErrTransportClosed
+		}
+		return nil, err
 	}
 	if frame == nil {
 		goto again
 	}
-	data, err := ioutil.ReadAll(&io.LimitedReader{frame, limit})
-	if err != nil {
-		return "", err
-	}
-	return string(data), nil
-}
-
-func (s *Server) webSocketWrite(ws *websocket.Conn, connection *Connection) {
-	deathNotify := connection.DeathNotify()
-	for {
-		select {
-		case <-deathNotify:
-			return
-
-		case <-connection.performPing:
-			err := sendMessage(ws, "")
-			if err != nil {
-				logger().Println("Error writing", err, connection)
-				connection.Kill()
-				return
-			}
 
-		case msg := <-connection.messageChan:
-			encoded, err := msg.jsonEncode()
-			if err != nil {
-				logger().Println(err)
-				connection.Kill()
-				return
-			}
-			err = sendMessage(ws, string(encoded))
-			if err != nil {
-				logger().Println("Error writing", err, connection)
-				connection.Kill()
-				return
-			}
-		}
+	// One more byte than the limit is read, to tell if the frame exceeds it.
+	data, err := ioutil.ReadAll(&io.LimitedReader{R: frame, N: t.limit + 1})
+	if err == nil && int64(len(data)) > t.limit {
+		return nil, errBufferOverflow
 	}
+	return data, err
 }
 
-func (s *Server) webSocketHandleMessage(msg string, ws *websocket.Conn, connection *Connection) {
-	// Any message means they're active, sense they sent it to us.
-	connection.resetDisconnectTimer()
-
-	// Messages of length zero, are ping responses (A.K.A. Pong), nothing more than that.
-	if len(msg) == 0 {
-		return
-	}
-
-	decoded := new(message)
-	err := decoded.jsonDecode([]byte(msg))
-	if err != nil {
-		logger().Println(err)
-		connection.Kill()
-		return
-	}
-
-	if decoded.isRequest == false {
-		// It's an response to one of our requests
-		onComplete, ok := connection.requestCompleters[decoded.id]
-		if !ok {
-			// Should never happen.
-			logger().Println("Invalid request response, id not valid, ignoring.")
-			return
-		}
-
-		valueArgs := interfaceToValueSlice(decoded.args)
-		fn := reflect.ValueOf(onComplete)
-
-		defer func() {
-			if r := recover(); r != nil {
-				msg, ok := r.(string)
-				if !ok {
-					panic(r)
-				}
-
-				buf := new(bytes.Buffer)
-				fmt.Fprintf(buf, "Request handler onComplete panic:\n\n")
-				fmt.Fprintf(buf, "Expected type:\n")
-				fmt.Fprintf(buf, "\t")
-
-				fmt.Fprintf(buf, "func(")
-				for n := 0; n < len(valueArgs); n++ {
-					fmt.Fprintf(buf, valueArgs[n].Type().String())
-					if n+1 < len(valueArgs) {
-						fmt.Fprintf(buf, ", ")
-					}
-				}
-				fmt.Fprintf(buf, ") ...")
-
-				fmt.Fprintf(buf, "\nFound type:\n\t")
-				fmt.Fprintf(buf, "%s\n\n", fn.Type().String())
-				fmt.Fprintf(buf, "%s\n\n", msg)
-				fmt.Fprintf(buf, "%s", string(debug.Stack()))
-				panic(string(buf.Bytes()))
-			}
-		}()
-		fn.Call(valueArgs)
-
-	} else {
-		// It's an request, so grab the request handler, and try to invoke it.
-		handler := s.getHandler(decoded.requestName)
-		if handler == nil {
-			logger().Printf("No handler for message \"%s\"\n", decoded.requestName)
-			return
-		}
-		fn := reflect.ValueOf(handler)
-
-		valueArgs := interfaceToValueSlice(decoded.args)
-		valueArgs = append(valueArgs, reflect.ValueOf(connection))
-
-		defer func() {
-			if r := recover(); r != nil {
-				msg, ok := r.(string)
-				if !ok {
-					panic(r)
-				}
-
-				buf := new(bytes.Buffer)
-				fmt.Fprintf(buf, "Request handler \"%s\" panic:\n\n", decoded.requestName)
-				fmt.Fprintf(buf, "Expected type:\n")
-				fmt.Fprintf(buf, "\t")
-
-				fmt.Fprintf(buf, "func(")
-				for n := 0; n < len(valueArgs); n++ {
-					fmt.Fprintf(buf, valueArgs[n].Type().String())
-					if n+1 < len(valueArgs) {
-						fmt.Fprintf(buf, ", ")
-					}
-				}
-				fmt.Fprintf(buf, ") ...")
-
-				fmt.Fprintf(buf, "\nFound type:\n\t")
-				fmt.Fprintf(buf, "%s\n\n", fn.Type().String())
-				fmt.Fprintf(buf, "%s\n\n", msg)
-				fmt.Fprintf(buf, "%s", string(debug.Stack()))
-				panic(string(buf.Bytes()))
-			}
-		}()
-		responseValues := fn.Call(valueArgs)
-
-		responseArgs := make([]interface{}, len(responseValues))
-		for i, v := range responseValues {
-			responseArgs[i] = v.Interface()
-		}
-
-		responseMsg := newResponseMessage(decoded.id, responseArgs)
-
-		select {
-		case <-connection.DeathNotify():
-			return
+func (t *wsTransport) Close() error {
+	// Closing writes an close frame, so it must not happen during Send.
+	t.writeAccess.Lock()
+	defer t.writeAccess.Unlock()
 
-		case connection.messageChan <- responseMsg:
-			break
-		}
-	}
+	return t.ws.Close()
 }
 
-func (s *Server) webSocketWaitForDeath(ws *websocket.Conn, connection *Connection) {
-	// Wait untill someone wants this connection dead
-	select {
-	case <-connection.deathWantedNotify:
-		break
-
-	case <-connection.disconnectFromTimeout:
-		break
-	}
-
-	// Inform everyone it's dead
-	connection.deathNotify <- true
-
-	// Wait for above to complete
-	<-connection.deathCompletedNotify
+func (t *wsTransport) Method() Method {
+	return WebSocket
+}
 
-	// Close the WebSocket now
-	ws.Close()
+func (t *wsTransport) Codec() Codec {
+	return t.codec
 }
 
 func (s *Server) handleWebSocket(ws *websocket.Conn) {
@@ -250,33 +115,37 @@ func (s *Server) handleWebSocket(ws *websocket.Conn) {
 
 	// WebSocket just connected, so we need to store it with their Session
 	//
-	// In this case, we'll use the actualy websocket connection as the key, since WebSocket is an
-	// connection-based protocol.
-	connection := newConnection(ws.Request().RemoteAddr, session, ws, WebSocket)
-
-	go s.webSocketWrite(ws, connection)
-	go s.webSocketWaitForDeath(ws, connection)
-	connection.disconnectTimer(s.PingTimeout(), s.PingRate())
-
-	s.doConnectHandler(connection)
+	// The key is random, just like long-polling connection ids, as it is what an resumable
+	// connection is resumed by.
+	key, err := s.generateSessionKey()
+	if err != nil {
+		// This should really never happen
+		logger().Println("Failed to generate connection key identifier:", err)
+		ws.Close()
+		return
+	}
+	// The codec was negotiated during the handshake, see handleWebSocketHandshake below.
+	codec := s.codecForProtocol(ws.Config().Protocol)
+	t := &wsTransport{ws: ws, codec: codec, limit: s.MaxBufferSize()}
 
-	for {
-		msg, err := receiveMessage(ws, s.MaxBufferSize())
+	// In token mode, they store the session key themselves (see
+	// SetSessionTokens), it is sent before anything else.
+	if _, tokenMode := s.sessionToken(ws.Request()); tokenMode {
+		frame, err := newTokenMessage(session.sessionKey()).encode(codec)
+		if err == nil {
+			err = t.Send(frame)
+		}
 		if err != nil {
-			if err != io.EOF {
-				logger().Println("receiveMessage() failed:", err)
-			}
-			connection.Kill()
-			break
+			logger().Println("Failed to send session token:", err)
+			ws.Close()
+			return
 		}
-
-		defer func() {
-			if e := recover(); e != nil {
-				logger().Println(fmt.Sprint(e))
-			}
-		}()
-		s.webSocketHandleMessage(msg, ws, connection)
 	}
+
+	connection, resumed := s.connectionFor(ws.Request(), session, key, t)
+
+	// Defined in dispatch.go
+	s.serve(t, connection, resumed)
 }
 
 func (s *Server) handleWebSocketHandshake(config *websocket.Config, req *http.Request) error {
@@ -289,19 +158,26 @@ func (s *Server) handleWebSocketHandshake(config *websocket.Config, req *http.Re
 	}
 
 	if !s.OriginAccess(origin) {
-		err = fmt.Errorf("WebSocket connection from disallowed origin %q, dropped.")
+		err = fmt.Errorf("WebSocket connection from disallowed origin %q, dropped.", origin)
 		logger().Println(err)
 		return err
 	}
 
+	// The client offers the codecs it supports as subprotocols, we choose one (see codec.go).
+	_, protocol := s.negotiateCodec(config.Protocol)
+	config.Protocol = nil
+	if len(protocol) > 0 {
+		config.Protocol = []string{protocol}
+	}
+
 	_, ok := s.ensureSessionExists(req, func(cookie *http.Cookie) {
 		// Set cookie
 		config.Header = make(http.Header)
 		config.Header.Set("Set-Cookie", cookie.String())
 
 		// Set Cookie header so that handleWebSocket above can see the updated
-		// cookie.
-		req.Header.Set("Cookie", cookie.String())
+		// cookie (without it's attributes, as the browser would send it).
+		req.Header.Set("Cookie", (&http.Cookie{Name: cookie.Name, Value: cookie.Value}).String())
 	})
 	if !ok {
 		err = errors.New(http.StatusText(http.StatusInternalServerError))
@@ -0,0 +1,180 @@
+// Copyright 2012 Lightpoke. All rights reserved.
+// This source code is subject to the terms and
+// conditions defined in the "License.txt" file.
+
+package organics
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// DispatchMode describes how the requests which come in over an single
+// connection are handed to their request handlers, see
+// Server.SetDispatchMode().
+type DispatchMode uint8
+
+const (
+	// Requests are handled one at an time, in the order they came in.
+	DispatchSequential DispatchMode = iota
+
+	// Requests are handled concurrently, by the worker pool.
+	DispatchConcurrent
+
+	// Requests with the same request name are handled one at an time, in the
+	// order they came in, but requests with different names are handled
+	// concurrently, by the worker pool.
+	DispatchKeyed
+)
+
+// String returns an string formatted version of the specified mode, or an
+// empty string if the mode is invalid (unknown).
+func (m DispatchMode) String() string {
+	switch m {
+	case DispatchSequential:
+		return "DispatchSequential"
+
+	case DispatchConcurrent:
+		return "DispatchConcurrent"
+
+	case DispatchKeyed:
+		return "DispatchKeyed"
+	}
+	return ""
+}
+
+// workerPool limits the number of request handlers which run at once, across
+// every connection of an server.
+type workerPool struct {
+	access sync.Mutex
+	freed  *sync.Cond
+	busy   int
+}
+
+// acquire blocks until less than limit workers are busy (or forever, if limit
+// is zero), and then takes an worker.
+func (p *workerPool) acquire(limit int) {
+	p.access.Lock()
+	defer p.access.Unlock()
+
+	for limit > 0 && p.busy >= limit {
+		p.freed.Wait()
+	}
+	p.busy++
+}
+
+// release gives back an worker taken by acquire.
+func (p *workerPool) release() {
+	p.access.Lock()
+	p.busy--
+	p.access.Unlock()
+
+	// The limit may differ between those waiting.
+	p.freed.Broadcast()
+}
+
+func newWorkerPool() *workerPool {
+	p := new(workerPool)
+	p.freed = sync.NewCond(&p.access)
+	return p
+}
+
+// handleWorker handles the request using an worker from the server's worker
+// pool, it blocks until there is one free.
+func (s *Server) handleWorker(request *message, connection *Connection) {
+	s.workers.acquire(s.Workers())
+	defer s.workers.release()
+
+	s.handleRequest(request, connection)
+}
+
+// serveRequests hands the requests which come in over the connection to their
+// request handlers (see handleRequest), using the server's dispatch mode, until
+// the connection is dead.
+//
+// They are handled on an different goroutine than the one reading from the
+// transport. That way an request handler may wait for an response from the
+// other end (see RequestContext) without blocking that response from being
+// read.
+func (s *Server) serveRequests(connection *Connection) {
+	// For DispatchKeyed; the requests waiting for the one with the same key
+	// which is being handled (an key is only present while one is).
+	var access sync.Mutex
+	waiting := make(map[interface{}][]*message)
+
+	for {
+		var request *message
+		select {
+		case request = <-connection.requests:
+		case <-connection.ctx.Done():
+			// Those still waiting in connection.requests are never handled,
+			// nor counted.
+			return
+		}
+
+		// From now on the request is handled (see handleRequest), which
+		// Shutdown waits for.
+		s.requestStarted()
+
+		switch s.DispatchMode() {
+		case DispatchConcurrent:
+			// Waiting for an worker here means no more requests are taken from
+			// the connection until there is one.
+			s.workers.acquire(s.Workers())
+			go func() {
+				defer s.workers.release()
+				s.handleRequest(request, connection)
+			}()
+
+		case DispatchKeyed:
+			// Requests are ordered by their request name, which is always an
+			// valid map key as serveRead rejects the others (see
+			// validRequestName).
+			key := request.requestName
+			access.Lock()
+			if queued, busy := waiting[key]; busy {
+				waiting[key] = append(queued, request)
+				access.Unlock()
+				continue
+			}
+			waiting[key] = nil
+			access.Unlock()
+
+			go func() {
+				for request != nil {
+					s.handleWorker(request, connection)
+
+					access.Lock()
+					queued := waiting[key]
+					if len(queued) == 0 {
+						delete(waiting, key)
+						request = nil
+					} else {
+						request = queued[0]
+						waiting[key] = queued[1:]
+					}
+					access.Unlock()
+				}
+			}()
+
+		default:
+			s.handleRequest(request, connection)
+		}
+	}
+}
+
+// validRequestName tells weather the request name can be an map key, which
+// request names must be in order to look up their request handler (e.g. an
+// JSON array cannot).
+func validRequestName(requestName interface{}) bool {
+	return requestName == nil || reflect.TypeOf(requestName).Comparable()
+}
+
+// checkRequestName panics if an handler cannot be registered for the request
+// name, see validRequestName.
+func checkRequestName(requestName interface{}) {
+	if !validRequestName(requestName) {
+		panic(fmt.Sprintf("organics: request name %v cannot be an map key, type %T is not comparable", requestName, requestName))
+	}
+}
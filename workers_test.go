@@ -0,0 +1,69 @@
+// Copyright 2012 Lightpoke. All rights reserved.
+// This source code is subject to the terms and
+// conditions defined in the "License.txt" file.
+
+package organics_test
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sinni800/organics"
+)
+
+func TestDispatchModes(t *testing.T) {
+	tests := []struct {
+		mode    organics.DispatchMode
+		workers int
+		want    string
+	}{
+		// One at an time, in order.
+		{organics.DispatchSequential, 0, "slow1 slow2 fast"},
+		// Fast is not held up by the slow ones.
+		{organics.DispatchConcurrent, 0, "fast"},
+		// Nor is it by the keyed mode, but the slow ones wait for each other.
+		{organics.DispatchKeyed, 0, "fast slow1 slow2"},
+		// An single worker handles one at an time.
+		{organics.DispatchConcurrent, 1, "slow1 slow2 fast"},
+	}
+	for _, test := range tests {
+		t.Run(test.mode.String(), func(t *testing.T) {
+			s := newServer(t)
+			s.SetDispatchMode(test.mode)
+			s.SetWorkers(test.workers)
+
+			var access sync.Mutex
+			var order []string
+			handled := func(id string) {
+				access.Lock()
+				defer access.Unlock()
+				order = append(order, id)
+			}
+			s.Handle("Slow", func(id string, c *organics.Connection) {
+				time.Sleep(100 * time.Millisecond)
+				handled(id)
+			})
+			s.Handle("Fast", func(id string, c *organics.Connection) {
+				handled(id)
+			})
+
+			c, _ := s.dial(t, s.wsURL())
+			done := make(chan bool, 3)
+			complete := func() { done <- true }
+			c.Request("Slow", "slow1", complete)
+			c.Request("Slow", "slow2", complete)
+			c.Request("Fast", "fast", complete)
+			for i := 0; i < 3; i++ {
+				receive(t, done)
+			}
+
+			access.Lock()
+			defer access.Unlock()
+			if got := strings.Join(order, " "); !strings.HasPrefix(got, test.want) {
+				t.Fatalf("handled in order %q, want %q", got, test.want)
+			}
+		})
+	}
+}